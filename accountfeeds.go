@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+)
+
+// Timelines of the authenticated account, served as /feed/{timeline}.xml
+// under the feed key "me/{timeline}".
+const (
+	timelineMentions  = "mentions"
+	timelineHome      = "home"
+	timelineBookmarks = "bookmarks"
+)
+
+// accountTimelines are the titles of the account's timelines, by name.
+var accountTimelines = map[string]string{
+	timelineMentions:  "Mentions",
+	timelineHome:      "Home timeline",
+	timelineBookmarks: "Bookmarks",
+}
+
+func accountFeedKey(timeline string) string {
+	return networkAccount + "/" + timeline
+}
+
+// accountTimelineSource reads the authenticated account's own timelines,
+// which only a user context client can. Mentions and the home timeline
+// come from the v1.1 API through client, bookmarks from the v2 API through
+// bookmarks, which takes OAuth 2.0 user auth; either can be nil.
+type accountTimelineSource struct {
+	client    *http.Client
+	bookmarks *feedgen.TwitterV2Source
+}
+
+// Timelines names the timelines s can read, sorted.
+func (s *accountTimelineSource) Timelines() []string {
+	var names []string
+	if s.client != nil {
+		names = append(names, timelineHome, timelineMentions)
+	}
+	if s.bookmarks != nil {
+		names = append(names, timelineBookmarks)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Route is the route pattern matching the name of every timeline s reads.
+func (s *accountTimelineSource) Route() string {
+	return "/feed/{timeline:" + strings.Join(s.Timelines(), "|") + "}.xml"
+}
+
+// FetchTimeline fetches one of the account's timelines. Mentions are
+// mostly replies, so opts.ExcludeReplies only applies to the home timeline,
+// which is the accounts it follows in the order the app shows them.
+func (s *accountTimelineSource) FetchTimeline(timeline string, opts fetchOptions) ([]item, error) {
+	if timeline == timelineBookmarks && s.bookmarks != nil {
+		return s.bookmarks.FetchBookmarks(opts)
+	}
+	if s.client == nil {
+		return nil, fmt.Errorf("account timeline %q needs -access-token", timeline)
+	}
+	api := twitter.NewClient(feedgen.WithContext(s.client, opts.Context))
+	var tweets []twitter.Tweet
+	var resp *http.Response
+	var err error
+	switch timeline {
+	case timelineMentions:
+		tweets, resp, err = api.Timelines.MentionTimeline(&twitter.MentionTimelineParams{Count: opts.Count, TweetMode: "extended"})
+		recordUpstream("mentions_timeline", resp, err)
+	case timelineHome:
+		tweets, resp, err = api.Timelines.HomeTimeline(&twitter.HomeTimelineParams{
+			Count:          opts.Count,
+			ExcludeReplies: twitter.Bool(opts.ExcludeReplies),
+			TweetMode:      "extended",
+		})
+		recordUpstream("home_timeline", resp, err)
+	default:
+		return nil, fmt.Errorf("unknown account timeline %q", timeline)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]item, 0, len(tweets))
+	for _, tweet := range tweets {
+		items = append(items, feedgen.ItemFromV1("", tweet))
+	}
+	return items, nil
+}
+
+// addAccountFeeds serves the account's timelines from source.
+func addAccountFeeds(cfg *config, status *feedStatus, routed *routedSource, source *accountTimelineSource) {
+	routed.Add(networkAccount, source)
+	for _, timeline := range source.Timelines() {
+		key := accountFeedKey(timeline)
+		if cfg.Add(feedConfig{Username: key, Title: accountTimelines[timeline]}) {
+			status.Add(key)
+			log.Print(feedPath(key))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Admin auth types selectable with admin_auth.type in the config.
+const (
+	adminAuthToken = "token"
+	adminAuthBasic = "basic"
+	adminAuthOIDC  = "oidc"
+	adminAuthMTLS  = "mtls"
+)
+
+// adminAuth decides who may use the admin routes.
+type adminAuth interface {
+	// Authenticate returns who made the request, or false when they
+	// aren't an admin.
+	Authenticate(r *http.Request) (string, bool)
+	// Challenge tells a refused client how to authenticate.
+	Challenge(w http.ResponseWriter)
+}
+
+// adminAuthConfig selects and configures the admin auth. It is read at
+// startup only.
+type adminAuthConfig struct {
+	Type string `json:"type"`
+	// Token is the bearer token for the token type.
+	Token string `json:"token,omitempty"`
+	// Users maps basic auth usernames to bcrypt password hashes.
+	Users map[string]string `json:"users,omitempty"`
+	// Issuer and Audience are what OIDC tokens must be issued by and for.
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	// Emails limits OIDC admins to these verified emails.
+	Emails []string `json:"emails,omitempty"`
+	// ClientCA is the PEM file of CAs that mTLS client certificates must
+	// chain to.
+	ClientCA string `json:"client_ca,omitempty"`
+	// Subjects limits OIDC admins to these subjects, and mTLS admins to
+	// certificates with these common names.
+	Subjects []string `json:"subjects,omitempty"`
+}
+
+// newAdminAuth builds the configured admin auth, falling back on
+// -admin-token. It returns nil, disabling the admin routes, when neither
+// is set.
+func newAdminAuth(c *adminAuthConfig, token string) (adminAuth, error) {
+	if c == nil {
+		if token == "" {
+			return nil, nil
+		}
+		return tokenAuth{token: token}, nil
+	}
+	if token != "" {
+		return nil, fmt.Errorf("-admin-token can't be combined with admin_auth in the config")
+	}
+	switch c.Type {
+	case adminAuthToken:
+		if c.Token == "" {
+			return nil, fmt.Errorf("admin_auth token needs a token")
+		}
+		return tokenAuth{token: c.Token}, nil
+	case adminAuthBasic:
+		if len(c.Users) == 0 {
+			return nil, fmt.Errorf("admin_auth basic needs users")
+		}
+		for username, hash := range c.Users {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("admin_auth user %s: password must be a bcrypt hash", username)
+			}
+		}
+		return basicAuth{users: c.Users}, nil
+	case adminAuthOIDC:
+		return newOIDCAuth(c.Issuer, c.Audience, c.Subjects, c.Emails)
+	case adminAuthMTLS:
+		return newMTLSAuth(c.ClientCA, c.Subjects)
+	}
+	return nil, fmt.Errorf("unknown admin_auth type %q", c.Type)
+}
+
+// tokenAuth accepts a bearer token. Browsers can send the token as the
+// basic auth password instead, with any username.
+type tokenAuth struct {
+	token string
+}
+
+func (a tokenAuth) Authenticate(r *http.Request) (string, bool) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return "token", subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) == 1
+}
+
+func (a tokenAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="twitterrss"`)
+	w.Header().Add("WWW-Authenticate", `Basic realm="twitterrss"`)
+}
+
+// basicAuth accepts named users with bcrypt hashed passwords.
+type basicAuth struct {
+	users map[string]string
+}
+
+func (a basicAuth) Authenticate(r *http.Request) (string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, ok := a.users[username]
+	if !ok {
+		return "", false
+	}
+	return username, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (a basicAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="twitterrss"`)
+}
+
+// mtlsAuth accepts clients presenting a certificate issued by the client
+// CA, which needs the server to terminate TLS itself.
+type mtlsAuth struct {
+	pool     *x509.CertPool
+	subjects map[string]bool
+}
+
+func newMTLSAuth(caFile string, subjects []string) (*mtlsAuth, error) {
+	if caFile == "" {
+		return nil, fmt.Errorf("admin_auth mtls needs a client_ca")
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read admin client CA")
+	}
+	a := &mtlsAuth{pool: x509.NewCertPool(), subjects: map[string]bool{}}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse admin client CA")
+		}
+		a.pool.AddCert(cert)
+	}
+	for _, subject := range subjects {
+		a.subjects[subject] = true
+	}
+	return a, nil
+}
+
+func (a *mtlsAuth) Authenticate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return "", false
+	}
+	name := cert.Subject.CommonName
+	return name, len(a.subjects) == 0 || a.subjects[name]
+}
+
+func (a *mtlsAuth) Challenge(w http.ResponseWriter) {}
+
+type adminKey struct{}
+
+// adminFrom is the admin who made the request, as their auth names them.
+func adminFrom(ctx context.Context) string {
+	admin, _ := ctx.Value(adminKey{}).(string)
+	return admin
+}
+
+// RequireAdmin guards admin routes with the admin auth. Admin routes are
+// disabled entirely when there is none.
+func RequireAdmin(auth adminAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			http.NotFound(w, r)
+			return
+		}
+		admin, ok := auth.Authenticate(r)
+		if !ok {
+			auth.Challenge(w)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), adminKey{}, admin)))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const eventMetricThreshold = "metric.threshold"
+
+// alertConfig notifies webhooks when an item's metric reaches a threshold,
+// e.g. 1000 retweets within 24 hours of posting.
+type alertConfig struct {
+	Name string `json:"name"`
+	// Feed limits the rule to one feed; empty applies it to every feed.
+	Feed      string `json:"feed,omitempty"`
+	Metric    string `json:"metric"`
+	Threshold int    `json:"threshold"`
+	// Within is how soon after posting the threshold has to be reached, as
+	// a Go duration. Empty means any time.
+	Within   string          `json:"within,omitempty"`
+	Webhooks []webhookConfig `json:"webhooks"`
+}
+
+func (a alertConfig) validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("alert has no name")
+	}
+	if _, ok := metricValue(itemMetrics{}, a.Metric); !ok {
+		return fmt.Errorf("alert %s: unknown metric %q", a.Name, a.Metric)
+	}
+	if a.Threshold <= 0 {
+		return fmt.Errorf("alert %s: threshold must be positive", a.Name)
+	}
+	if _, err := a.window(); err != nil {
+		return fmt.Errorf("alert %s: %s", a.Name, err)
+	}
+	if len(a.Webhooks) == 0 {
+		return fmt.Errorf("alert %s has no webhooks", a.Name)
+	}
+	return nil
+}
+
+func (a alertConfig) window() (time.Duration, error) {
+	if a.Within == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(a.Within)
+}
+
+// metricValue picks a named metric out of m.
+func metricValue(m itemMetrics, name string) (int, bool) {
+	switch name {
+	case "retweets":
+		return m.Retweets, true
+	case "likes":
+		return m.Likes, true
+	case "replies":
+		return m.Replies, true
+	case "quotes":
+		return m.Quotes, true
+	}
+	return 0, false
+}
+
+// crossed reports whether the newest sample of an item's series is the one
+// that took the metric over the rule's threshold, inside its window. Since
+// only the crossing sample matches, each rule fires once per item without
+// remembering what it already sent.
+func (a alertConfig) crossed(it item, series []metricSample) (int, bool) {
+	if len(series) == 0 {
+		return 0, false
+	}
+	latest := series[len(series)-1]
+	value, _ := metricValue(latest.Metrics, a.Metric)
+	if value < a.Threshold {
+		return 0, false
+	}
+	if len(series) > 1 {
+		if previous, _ := metricValue(series[len(series)-2].Metrics, a.Metric); previous >= a.Threshold {
+			return 0, false
+		}
+	}
+	if window, _ := a.window(); window > 0 && latest.At.Sub(it.CreatedAt) > window {
+		return 0, false
+	}
+	return value, true
+}
+
+// alertPayload describes the rule that fired in a webhook payload.
+type alertPayload struct {
+	Name      string `json:"name"`
+	Metric    string `json:"metric"`
+	Value     int    `json:"value"`
+	Threshold int    `json:"threshold"`
+}
+
+// alerter evaluates alert rules after each refresh.
+type alerter struct {
+	cfg      *config
+	store    *store
+	bus      *eventBus
+	notifier *webhookNotifier
+}
+
+// Check evaluates every rule against the feed's current items.
+func (a *alerter) Check(ev event) {
+	if ev.Type != eventFeedRefreshed {
+		return
+	}
+	entry := a.store.Fresh(ev.Feed)
+	if entry == nil {
+		return
+	}
+	for _, rule := range a.cfg.alerts() {
+		if rule.Feed != "" && rule.Feed != ev.Feed {
+			continue
+		}
+		for _, it := range entry.Items {
+			value, ok := rule.crossed(it, a.store.MetricSeries(ev.Feed, it.ID))
+			if !ok {
+				continue
+			}
+			payload := newTweetPayload(it)
+			a.bus.Publish(event{Type: eventMetricThreshold, Feed: ev.Feed, Item: &payload, Count: value})
+			a.notify(rule, ev.Feed, it, value)
+		}
+	}
+}
+
+func (a *alerter) notify(rule alertConfig, username string, it item, value int) {
+	payload := webhookPayload{
+		Feed:   username,
+		Tweets: []tweetPayload{newTweetPayload(it)},
+		Alert:  &alertPayload{Name: rule.Name, Metric: rule.Metric, Value: value, Threshold: rule.Threshold},
+	}
+	for _, hook := range rule.Webhooks {
+		bodies, err := formatWebhook(hook.Format, payload)
+		if err != nil {
+			log.Print(errors.Wrapf(err, "unable to create alert payload for %s", hook.URL))
+			continue
+		}
+		a.notifier.enqueue(username, hook, bodies)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/feeds"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// archiveSearchLimit is how many hits a search returns unless max_items
+// asks for fewer.
+const archiveSearchLimit = 100
+
+// archiveHit is an archived item matching a search, with the feed it's
+// archived under.
+type archiveHit struct {
+	Feed string `json:"feed"`
+	Item item   `json:"item"`
+}
+
+// searchWords splits text into the lowercase words searches match,
+// keeping hashtags and mentions whole.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '#' && r != '@' && r != '*'
+	})
+}
+
+// archiveRef names an archived item.
+type archiveRef struct {
+	Feed string
+	ID   string
+}
+
+// searchIndex maps the words of archived items to the items that have
+// them, so a search looks its terms up rather than reading the archive.
+// It lives in memory alongside the archive and is rebuilt as the store is
+// loaded.
+type searchIndex struct {
+	postings map[string]map[archiveRef]bool
+	// words are the words indexed for each item, by feed and id, so it can
+	// be taken out again.
+	words map[string]map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: map[string]map[archiveRef]bool{}, words: map[string]map[string][]string{}}
+}
+
+// add indexes the text of it, and of the post it quotes, in place of
+// whatever was indexed for it before.
+func (x *searchIndex) add(feed string, it item) {
+	x.remove(feed, it.ID)
+	text := it.Text
+	if it.Quoted != nil {
+		text += " " + it.Quoted.Text
+	}
+	ref := archiveRef{Feed: feed, ID: it.ID}
+	var words []string
+	for _, word := range searchWords(text) {
+		if x.postings[word][ref] {
+			continue
+		}
+		if x.postings[word] == nil {
+			x.postings[word] = map[archiveRef]bool{}
+		}
+		x.postings[word][ref] = true
+		words = append(words, word)
+	}
+	if x.words[feed] == nil {
+		x.words[feed] = map[string][]string{}
+	}
+	x.words[feed][it.ID] = words
+}
+
+func (x *searchIndex) remove(feed string, id string) {
+	ref := archiveRef{Feed: feed, ID: id}
+	for _, word := range x.words[feed][id] {
+		delete(x.postings[word], ref)
+		if len(x.postings[word]) == 0 {
+			delete(x.postings, word)
+		}
+	}
+	delete(x.words[feed], id)
+}
+
+func (x *searchIndex) removeFeed(feed string) {
+	for id := range x.words[feed] {
+		x.remove(feed, id)
+	}
+	delete(x.words, feed)
+}
+
+// lookup returns the items with term, or with any word it starts when it
+// ends in *.
+func (x *searchIndex) lookup(term string) map[archiveRef]bool {
+	found := map[archiveRef]bool{}
+	prefix := strings.TrimSuffix(term, "*")
+	for word, refs := range x.postings {
+		if word == term || (prefix != term && strings.HasPrefix(word, prefix)) {
+			for ref := range refs {
+				found[ref] = true
+			}
+		}
+	}
+	return found
+}
+
+// match returns the items with every term.
+func (x *searchIndex) match(terms []string) map[archiveRef]bool {
+	var matched map[archiveRef]bool
+	for _, term := range terms {
+		var found map[archiveRef]bool
+		if !strings.HasSuffix(term, "*") {
+			found = x.postings[term]
+		} else {
+			found = x.lookup(term)
+		}
+		if matched == nil {
+			matched = make(map[archiveRef]bool, len(found))
+			for ref := range found {
+				matched[ref] = true
+			}
+			continue
+		}
+		for ref := range matched {
+			if !found[ref] {
+				delete(matched, ref)
+			}
+		}
+		if len(matched) == 0 {
+			break
+		}
+	}
+	return matched
+}
+
+// Search finds archived items whose text, or the text they quote, has
+// every word of query, newest first. It searches username's archive, or
+// every feed's when username is empty, leaving out feeds allowed rejects
+// before limiting the hits; redacted items are already gone.
+func (s *store) Search(query string, username string, limit int, allowed func(feed string) bool) []archiveHit {
+	terms := searchWords(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	s.mu.RLock()
+	var hits []archiveHit
+	opens := map[string]bool{}
+	for ref := range s.index.match(terms) {
+		if username != "" && ref.Feed != username {
+			continue
+		}
+		open, checked := opens[ref.Feed]
+		if !checked {
+			open = allowed(ref.Feed)
+			opens[ref.Feed] = open
+		}
+		if it, ok := s.archive[ref.Feed][ref.ID]; ok && open {
+			hits = append(hits, archiveHit{Feed: ref.Feed, Item: it})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i].Item, hits[j].Item
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Query}} - twitterrss</title>
+<link rel="alternate" type="application/rss+xml" title="{{.Query}}" href="{{.RSS}}">
+</head>
+<body>
+<form action="/search">
+<input type="search" name="q" value="{{.Query}}">
+{{- if .User}}<input type="hidden" name="user" value="{{.User}}">{{end}}
+<button>{{.Locale.T "search"}}</button>
+</form>
+{{- if .Hits}}
+<ol>
+{{- range .Hits}}
+<li><a href="{{.Item.URL}}">{{$.Locale.Date .Item.CreatedAt}}</a> &mdash; <a href="{{.Path}}">{{.Feed}}</a>: {{.Item.Text}}</li>
+{{- end}}
+</ol>
+{{- else if .Query}}
+<p>{{.Locale.T "no_results"}}</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// searchPage is an archiveHit as the HTML view shows it.
+type searchPage struct {
+	archiveHit
+	Path string
+}
+
+// ArchiveSearchHandler searches archived tweets for ?q=, in the feed
+// ?user= or all of them, as an HTML page, JSON (?format=json) or RSS
+// (?format=rss). Feeds with their own tokens are only searched when the
+// request carries one of them.
+func ArchiveSearchHandler(cfg *config, st *store, auth *feedAuth) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		user := query.Get("user")
+		if user != "" {
+			if feedCfg, ok := cfg.Feed(user); ok {
+				user = feedCfg.Username
+			}
+		}
+		limit, err := maxItems(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limit == 0 || limit > archiveSearchLimit {
+			limit = archiveSearchLimit
+		}
+
+		hits := st.Search(q, user, limit, func(feed string) bool { return auth.Opens(r, feed) })
+
+		switch query.Get("format") {
+		case "json":
+			if hits == nil {
+				hits = []archiveHit{}
+			}
+			jsonBody, err := json.Marshal(hits)
+			if err != nil {
+				panic(errors.Wrap(err, "Unable to create response"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonBody)
+		case formatRSS:
+			items := make([]item, 0, len(hits))
+			for _, hit := range hits {
+				items = append(items, hit.Item)
+			}
+			doc := newFeedDocument(&feeds.Feed{
+				Title:       fmt.Sprintf("Archive search: %s", q),
+				Link:        &feeds.Link{Href: r.URL.String()},
+				Description: fmt.Sprintf("archived tweets matching %s", q),
+				Author:      &feeds.Author{Name: "https://github.com/halkeye/twitterrss"},
+				Created:     newestItem(items).UTC(),
+			})
+			for _, it := range items {
+				doc.Items = append(doc.Items, &feeds.Item{
+					Id:          it.ID,
+					Title:       it.FeedTitle(),
+					Link:        &feeds.Link{Href: it.URL},
+					Author:      &feeds.Author{Name: it.Author.Attribution()},
+					Description: feedgen.ItemHTML(it),
+					Created:     it.CreatedAt.UTC(),
+				})
+				doc.Annotate(it)
+			}
+			rss, err := feedgen.RenderRSS(doc, nil)
+			if err != nil {
+				panic(errors.Wrap(err, "unable to create rss feed"))
+			}
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(rss))
+		case "", "html":
+			pages := make([]searchPage, 0, len(hits))
+			for _, hit := range hits {
+				pages = append(pages, searchPage{archiveHit: hit, Path: feedPath(hit.Feed)})
+			}
+			rssQuery := r.URL.Query()
+			rssQuery.Set("format", formatRSS)
+			var body bytes.Buffer
+			data := struct {
+				Locale locale
+				Query  string
+				User   string
+				RSS    string
+				Hits   []searchPage
+			}{negotiateLocale(r), q, user, "/search?" + rssQuery.Encode(), pages}
+			if err := searchTemplate.Execute(&body, data); err != nil {
+				panic(errors.Wrap(err, "unable to render search"))
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Add("Vary", "Accept-Language")
+			w.WriteHeader(http.StatusOK)
+			w.Write(body.Bytes())
+		default:
+			http.Error(w, "format must be html, json or rss", http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// auditEntry is a record of an administrative action.
+type auditEntry struct {
+	At      time.Time         `json:"at"`
+	Action  string            `json:"action"`
+	Remote  string            `json:"remote,omitempty"`
+	Admin   string            `json:"admin,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// auditLog keeps recent admin actions in memory and, when a path is set,
+// appends every entry to a JSON lines file.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	size    int
+	entries []auditEntry
+}
+
+func newAuditLog(path string, size int) *auditLog {
+	return &auditLog{path: path, size: size}
+}
+
+// Record appends an entry for action performed by the request r.
+func (a *auditLog) Record(r *http.Request, action string, details map[string]string) error {
+	entry := auditEntry{At: time.Now(), Action: action, Details: details}
+	if r != nil {
+		entry.Remote = r.RemoteAddr
+		entry.Admin = adminFrom(r.Context())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.size {
+		a.entries = a.entries[len(a.entries)-a.size:]
+	}
+
+	if a.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode audit entry")
+	}
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to open audit log")
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return errors.Wrap(err, "unable to write audit log")
+}
+
+// Entries returns the retained entries, oldest first.
+func (a *auditLog) Entries() []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]auditEntry{}, a.entries...)
+}
+
+func AuditHandler(audit *auditLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(audit.Entries())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
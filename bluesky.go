@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// blueskyAppViewURL is the public AT Protocol appview, which serves author
+// feeds without authentication.
+const blueskyAppViewURL = "https://public.api.bsky.app/xrpc"
+
+type blueskyProfile struct {
+	DID         string `json:"did"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName"`
+	Avatar      string `json:"avatar"`
+}
+
+type blueskyImage struct {
+	Fullsize    string `json:"fullsize"`
+	Thumb       string `json:"thumb"`
+	Alt         string `json:"alt"`
+	AspectRatio *struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"aspectRatio"`
+}
+
+type blueskyPost struct {
+	URI         string         `json:"uri"`
+	Author      blueskyProfile `json:"author"`
+	RepostCount int            `json:"repostCount"`
+	LikeCount   int            `json:"likeCount"`
+	ReplyCount  int            `json:"replyCount"`
+	QuoteCount  int            `json:"quoteCount"`
+	Record      struct {
+		Text      string    `json:"text"`
+		CreatedAt time.Time `json:"createdAt"`
+		Langs     []string  `json:"langs"`
+		Facets    []struct {
+			Features []struct {
+				Type string `json:"$type"`
+				Tag  string `json:"tag"`
+			} `json:"features"`
+		} `json:"facets"`
+		Reply *struct {
+			Parent struct {
+				URI string `json:"uri"`
+			} `json:"parent"`
+		} `json:"reply"`
+	} `json:"record"`
+	Embed *struct {
+		Images []blueskyImage `json:"images"`
+		Media  *struct {
+			Images []blueskyImage `json:"images"`
+		} `json:"media"`
+		// Record is the quoted post; with media attached it is nested
+		// one level further.
+		Record *blueskyQuotedRecord `json:"record"`
+	} `json:"embed"`
+}
+
+type blueskyQuotedRecord struct {
+	URI    string         `json:"uri"`
+	Author blueskyProfile `json:"author"`
+	Value  struct {
+		Text      string    `json:"text"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"value"`
+	Record *blueskyQuotedRecord `json:"record"`
+}
+
+type blueskyFeedResponse struct {
+	Feed []struct {
+		Post blueskyPost `json:"post"`
+	} `json:"feed"`
+}
+
+// blueskySource reads an account's posts from the Bluesky appview.
+// Accounts are handles, e.g. "jay.bsky.team".
+type blueskySource struct {
+	client *http.Client
+}
+
+func newBlueskySource() *blueskySource {
+	return &blueskySource{client: audited(&http.Client{Timeout: 30 * time.Second})}
+}
+
+func (s *blueskySource) FetchTimeline(handle string, opts fetchOptions) ([]item, error) {
+	filter := "posts_no_replies"
+	if !opts.ExcludeReplies {
+		filter = "posts_with_replies"
+	}
+	query := url.Values{
+		"actor":  {handle},
+		"limit":  {fmt.Sprint(opts.Count)},
+		"filter": {filter},
+	}
+	resp, err := feedgen.WithContext(s.client, opts.Context).Get(blueskyAppViewURL + "/app.bsky.feed.getAuthorFeed?" + query.Encode())
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("bluesky: %s", resp.Status)
+	}
+	recordUpstream("bsky_author_feed", resp, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch bluesky feed")
+	}
+	defer resp.Body.Close()
+
+	body := blueskyFeedResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decode bluesky feed")
+	}
+
+	items := make([]item, 0, len(body.Feed))
+	for _, entry := range body.Feed {
+		items = append(items, itemFromBluesky(entry.Post))
+	}
+	return items, nil
+}
+
+func itemFromBluesky(post blueskyPost) item {
+	// at://did:plc:xyz/app.bsky.feed.post/3k... ends in the record key
+	rkey := post.URI[strings.LastIndex(post.URI, "/")+1:]
+	it := item{
+		ID:        rkey,
+		URL:       fmt.Sprintf("https://bsky.app/profile/%s/post/%s", post.Author.Handle, rkey),
+		Text:      post.Record.Text,
+		CreatedAt: post.Record.CreatedAt,
+		Author: itemAuthor{
+			Username:  post.Author.Handle,
+			Name:      post.Author.DisplayName,
+			AvatarURL: post.Author.Avatar,
+			URL:       "https://bsky.app/profile/" + post.Author.Handle,
+		},
+	}
+	it.Metrics = &itemMetrics{Retweets: post.RepostCount, Likes: post.LikeCount, Replies: post.ReplyCount, Quotes: post.QuoteCount}
+	for _, facet := range post.Record.Facets {
+		for _, feature := range facet.Features {
+			if feature.Type == "app.bsky.richtext.facet#tag" {
+				it.Hashtags = append(it.Hashtags, feature.Tag)
+			}
+		}
+	}
+	if len(post.Record.Langs) > 0 {
+		it.Lang = post.Record.Langs[0]
+	}
+	if post.Record.Reply != nil {
+		// at://did:plc:xyz/... names the parent's author by DID
+		parent := strings.TrimPrefix(post.Record.Reply.Parent.URI, "at://")
+		if i := strings.Index(parent, "/"); i >= 0 {
+			it.InReplyToID = parent[strings.LastIndex(parent, "/")+1:]
+			it.InReplyToUser = parent[:i]
+			if it.InReplyToUser == post.Author.DID {
+				it.InReplyToUser = post.Author.Handle
+			}
+		}
+	}
+	if post.Embed != nil {
+		images := post.Embed.Images
+		if post.Embed.Media != nil {
+			images = append(images, post.Embed.Media.Images...)
+		}
+		for _, image := range images {
+			media := itemMedia{Type: "photo", URL: image.Fullsize, ThumbnailURL: image.Thumb, AltText: image.Alt}
+			if ratio := image.AspectRatio; ratio != nil {
+				media.Width, media.Height = feedgen.FitThumbnail(ratio.Width, ratio.Height)
+			}
+			it.Media = append(it.Media, media)
+		}
+		record := post.Embed.Record
+		if record != nil && record.Record != nil {
+			record = record.Record
+		}
+		if record != nil && record.URI != "" {
+			quoted := blueskyPost{URI: record.URI, Author: record.Author}
+			quoted.Record.Text = record.Value.Text
+			quoted.Record.CreatedAt = record.Value.CreatedAt
+			q := itemFromBluesky(quoted)
+			it.Quoted = &q
+		}
+	}
+	return it
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// CDN purge APIs selectable with -cdn.
+const (
+	cdnCloudflare = "cloudflare"
+	cdnFastly     = "fastly"
+)
+
+var surrogateKeyReplacer = strings.NewReplacer("/", "-", " ", "-", "%", "-")
+
+// surrogateKey tags a feed's responses so the CDN can purge just that feed.
+func surrogateKey(username string) string {
+	return "feed-" + surrogateKeyReplacer.Replace(strings.ToLower(username))
+}
+
+func groupSurrogateKey(name string) string {
+	return "group-" + surrogateKeyReplacer.Replace(strings.ToLower(name))
+}
+
+// setSurrogateKeys tags a response with keys as both Fastly's Surrogate-Key
+// and Cloudflare's Cache-Tag. Every feed also carries "feeds" so they can
+// all be purged at once.
+func setSurrogateKeys(w http.ResponseWriter, keys ...string) {
+	value := strings.Join(append(keys, "feeds"), " ")
+	w.Header().Set("Surrogate-Key", value)
+	w.Header().Set("Cache-Tag", strings.Replace(value, " ", ",", -1))
+}
+
+// cdnPurger purges a feed from the CDN in front of this instance when it
+// gains new items, so cached copies never hide them.
+type cdnPurger struct {
+	cfg      *config
+	kind     string
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func newCDNPurger(cfg *config, kind string, endpoint string, token string) (*cdnPurger, error) {
+	switch kind {
+	case cdnCloudflare, cdnFastly:
+	default:
+		return nil, fmt.Errorf("unknown CDN %q, expected %s or %s", kind, cdnCloudflare, cdnFastly)
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("-cdn-purge-endpoint is required with -cdn")
+	}
+	return &cdnPurger{
+		cfg:      cfg,
+		kind:     kind,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// keys are the surrogate keys to purge when username changes: its own and
+// those of the groups that include it.
+func (p *cdnPurger) keys(username string) []string {
+	keys := []string{surrogateKey(username)}
+	for _, group := range p.cfg.groups() {
+		for _, member := range group.Usernames {
+			if member == username {
+				keys = append(keys, groupSurrogateKey(group.Name))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// Publish purges the feed for username in the background.
+func (p *cdnPurger) Publish(username string, fresh []item) {
+	go func() {
+		if err := p.Purge(username); err != nil {
+			log.Print(err)
+		}
+	}()
+}
+
+// Purge asks the CDN to drop every cached response for username.
+func (p *cdnPurger) Purge(username string) error {
+	keys := p.keys(username)
+	switch p.kind {
+	case cdnCloudflare:
+		body, err := json.Marshal(map[string][]string{"tags": keys})
+		if err != nil {
+			return err
+		}
+		return p.send(p.endpoint, body, "Authorization", "Bearer "+p.token)
+	case cdnFastly:
+		for _, key := range keys {
+			if err := p.send(p.endpoint+"/"+url.PathEscape(key), nil, "Fastly-Key", p.token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *cdnPurger) send(endpoint string, body []byte, authHeader string, auth string) error {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "unable to create CDN purge request")
+	}
+	req.Header.Set(authHeader, auth)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to purge CDN")
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("CDN purge returned %s", resp.Status)
+	}
+	return nil
+}
+
+// CDNPurgeHandler purges a feed from the CDN on demand.
+func CDNPurgeHandler(p *cdnPurger, audit *auditLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+		if err := p.Purge(username); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err := audit.Record(r, "cdn_purge", map[string]string{"feed": username}); err != nil {
+			log.Print(errors.Wrapf(err, "unable to audit CDN purge of %s", username))
+			http.Error(w, "the CDN was purged but the purge couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+
+		jsonBody, err := json.Marshal(map[string]interface{}{"feed": username, "keys": p.keys(username)})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
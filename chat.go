@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Webhook formats.
+const (
+	webhookFormatJSON    = "json"
+	webhookFormatDiscord = "discord"
+	webhookFormatSlack   = "slack"
+)
+
+// discordMaxEmbeds is the most embeds Discord accepts in one message.
+const discordMaxEmbeds = 10
+
+type discordEmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type discordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbed struct {
+	Description string             `json:"description"`
+	URL         string             `json:"url"`
+	Timestamp   string             `json:"timestamp,omitempty"`
+	Author      discordEmbedAuthor `json:"author"`
+	Image       *discordEmbedImage `json:"image,omitempty"`
+	Thumbnail   *discordEmbedImage `json:"thumbnail,omitempty"`
+}
+
+type discordMessage struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+	ImageURL string         `json:"image_url,omitempty"`
+	AltText  string         `json:"alt_text,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+func discordEmbedFor(tweet tweetPayload) discordEmbed {
+	embed := discordEmbed{
+		Description: tweet.Text,
+		URL:         tweet.URL,
+		Author: discordEmbedAuthor{
+			Name:    "@" + tweet.Author,
+			URL:     tweet.AuthorURL,
+			IconURL: tweet.AvatarURL,
+		},
+	}
+	if !tweet.CreatedAt.IsZero() {
+		embed.Timestamp = tweet.CreatedAt.Format(time.RFC3339)
+	}
+	if len(tweet.Media) > 0 {
+		embed.Image = &discordEmbedImage{URL: tweet.Media[0]}
+	}
+	return embed
+}
+
+func slackBlocksFor(tweet tweetPayload) []slackBlock {
+	context := slackBlock{Type: "context"}
+	if tweet.AvatarURL != "" {
+		context.Elements = append(context.Elements, slackElement{Type: "image", ImageURL: tweet.AvatarURL, AltText: tweet.Author})
+	}
+	author := "@" + tweet.Author
+	if tweet.AuthorURL != "" {
+		author = fmt.Sprintf("<%s|@%s>", tweet.AuthorURL, tweet.Author)
+	}
+	context.Elements = append(context.Elements, slackElement{Type: "mrkdwn", Text: author})
+
+	blocks := []slackBlock{
+		context,
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("%s\n<%s|View tweet>", tweet.Text, tweet.URL)}},
+	}
+	for _, media := range tweet.Media {
+		blocks = append(blocks, slackBlock{Type: "image", ImageURL: media, AltText: "attached media"})
+	}
+	return blocks
+}
+
+// formatWebhook renders payload in the wire format of a webhook. Formats
+// with a per-message limit may need several messages.
+func formatWebhook(format string, payload webhookPayload) ([][]byte, error) {
+	var messages []interface{}
+	switch format {
+	case "", webhookFormatJSON:
+		messages = append(messages, payload)
+	case webhookFormatDiscord:
+		for start := 0; start < len(payload.Tweets); start += discordMaxEmbeds {
+			end := start + discordMaxEmbeds
+			if end > len(payload.Tweets) {
+				end = len(payload.Tweets)
+			}
+			message := discordMessage{}
+			for _, tweet := range payload.Tweets[start:end] {
+				message.Username = "@" + tweet.Author
+				message.AvatarURL = tweet.AvatarURL
+				message.Embeds = append(message.Embeds, discordEmbedFor(tweet))
+			}
+			messages = append(messages, message)
+		}
+	case webhookFormatSlack:
+		for _, tweet := range payload.Tweets {
+			messages = append(messages, slackMessage{
+				Text:   fmt.Sprintf("@%s: %s %s", tweet.Author, tweet.Text, tweet.URL),
+				Blocks: slackBlocksFor(tweet),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+
+	var bodies [][]byte
+	for _, message := range messages {
+		body, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
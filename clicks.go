@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// itemClicks is how often an item's redirect link has been followed.
+type itemClicks struct {
+	Feed      string    `json:"feed"`
+	ID        string    `json:"id"`
+	Clicks    int       `json:"clicks"`
+	LastClick time.Time `json:"last_click"`
+}
+
+// clickCounter routes item links through /r/{id} and counts each redirect.
+// Counts are saved to path, when set, so they survive a restart. Redirects
+// only mark them changed; Run writes them out.
+type clickCounter struct {
+	store *store
+	path  string
+
+	mu     sync.Mutex
+	clicks map[string]*itemClicks
+	dirty  bool
+}
+
+func newClickCounter(st *store, path string) (*clickCounter, error) {
+	c := &clickCounter{store: st, path: path, clicks: map[string]*itemClicks{}}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.clicks); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	return c, nil
+}
+
+func (c *clickCounter) saveLocked() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.clicks)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Run saves the click counts on an interval whenever they've changed.
+func (c *clickCounter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.mu.Lock()
+		var err error
+		if c.dirty {
+			err = c.saveLocked()
+		}
+		c.mu.Unlock()
+		if err != nil {
+			log.Print(errors.Wrap(err, "unable to save click counts"))
+		}
+	}
+}
+
+// Rewrite points every item link in feed at the redirect endpoint.
+func (c *clickCounter) Rewrite(base string, feed *feedDocument) {
+	for _, feedItem := range feed.Items {
+		if feedItem.Link != nil && feedItem.Link.Href != "" {
+			feedItem.Link.Href = base + "/r/" + url.PathEscape(feedItem.Id)
+		}
+	}
+}
+
+// Click counts a redirect for item id, returning the feed and item it
+// belongs to. It returns false for items that aren't in the archive.
+func (c *clickCounter) Click(id string) (string, item, bool) {
+	feed, it, ok := c.store.Find(id)
+	if !ok || it.URL == "" {
+		return "", item{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counted, ok := c.clicks[id]
+	if !ok {
+		counted = &itemClicks{Feed: feed, ID: id}
+		c.clicks[id] = counted
+	}
+	counted.Clicks++
+	counted.LastClick = time.Now()
+	c.dirty = true
+	return feed, it, true
+}
+
+// Stats lists the click counts, most clicked first.
+func (c *clickCounter) Stats() []itemClicks {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]itemClicks, 0, len(c.clicks))
+	for _, counted := range c.clicks {
+		stats = append(stats, *counted)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Clicks != stats[j].Clicks {
+			return stats[i].Clicks > stats[j].Clicks
+		}
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+// PurgeFeed forgets the clicks on username's items and saves the counts
+// straight away, so they're gone from disk when the purge finishes.
+func (c *clickCounter) PurgeFeed(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, counted := range c.clicks {
+		if counted.Feed == username {
+			delete(c.clicks, id)
+		}
+	}
+	return errors.Wrap(c.saveLocked(), "unable to save click counts")
+}
+
+func (c *clickCounter) HasFeed(username string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, counted := range c.clicks {
+		if counted.Feed == username {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectHandler counts a click on an item link and redirects to the post,
+// on the feed's alternative frontend when it has one.
+func RedirectHandler(cfg *config, c *clickCounter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed, it, ok := c.Click(mux.Vars(r)["id"])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if feedCfg, ok := cfg.Feed(feed); ok && feedCfg.usesFrontend() {
+			it = withFrontend(feedCfg.Frontend, it)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, it.URL, http.StatusFound)
+	}
+}
+
+// ClicksHandler lists the click counts of every item that has had one.
+func ClicksHandler(c *clickCounter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(c.Stats())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
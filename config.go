@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// feedConfig describes a single feed served by this instance.
+type feedConfig struct {
+	Username   string          `json:"username"`
+	Title      string          `json:"title,omitempty"`
+	Categories []string        `json:"categories,omitempty"`
+	Webhooks   []webhookConfig `json:"webhooks,omitempty"`
+	// TelegramChats are chat ids or @channel names new tweets are posted to.
+	TelegramChats []string `json:"telegram_chats,omitempty"`
+	// Heartbeat adds a monthly "still alive" item while the account is quiet.
+	Heartbeat bool `json:"heartbeat,omitempty"`
+	// UnrollThreads keeps self-replies and combines each thread into one item.
+	UnrollThreads bool `json:"unroll_threads,omitempty"`
+	// SavedSearch marks search feeds created from the account's saved
+	// searches, which are removed again when the search is.
+	SavedSearch bool `json:"saved_search,omitempty"`
+	// Filter drops items before they are rendered.
+	Filter itemFilter `json:"filter,omitempty"`
+	// Frontend is an alternative Twitter frontend, such as a Nitter mirror,
+	// that item links point at instead of twitter.com.
+	Frontend string `json:"frontend,omitempty"`
+	// Templates customise the feed and item titles and descriptions.
+	Templates *feedTemplates `json:"templates,omitempty"`
+	// Tokens are required to read the feed, alongside any -feed-token.
+	Tokens []string `json:"tokens,omitempty"`
+	// Timezone, Locale and DateFormat add when each item was posted to its
+	// description: in Timezone (UTC unless set), laid out as DateFormat, a
+	// Go time layout, or else the way Locale (en, de, fr or es) writes dates.
+	Timezone   string `json:"timezone,omitempty"`
+	Locale     string `json:"locale,omitempty"`
+	DateFormat string `json:"date_format,omitempty"`
+	// MarkDeleted prefixes the titles of items whose tweets were deleted
+	// with "[deleted]".
+	MarkDeleted bool `json:"mark_deleted,omitempty"`
+}
+
+// FeedTitle is the configured title, falling back to the generic one.
+func (f feedConfig) FeedTitle() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return fmt.Sprintf("%s tweets", f.Username)
+}
+
+type config struct {
+	mu      sync.RWMutex
+	Feeds   []feedConfig   `json:"feeds"`
+	Digests []digestConfig `json:"digests,omitempty"`
+	Groups  []groupConfig  `json:"groups,omitempty"`
+	Alerts  []alertConfig  `json:"alerts,omitempty"`
+	// Headers maps a route group (all, feeds, api, admin, pages) to static
+	// response headers.
+	Headers map[string]map[string]string `json:"headers,omitempty"`
+	// LegacyRoutes redirect URLs from older route schemes.
+	LegacyRoutes []legacyRoute `json:"legacy_routes,omitempty"`
+	// AdminAuth replaces -admin-token with another way to authenticate
+	// admins.
+	AdminAuth *adminAuthConfig `json:"admin_auth,omitempty"`
+	// DenyUsernames are accounts never served, even with
+	// -allow-any-username, alongside any -deny-usernames.
+	DenyUsernames []string `json:"deny_usernames,omitempty"`
+
+	// deniedByFlag are the -deny-usernames, kept across reloads.
+	deniedByFlag []string
+}
+
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read config")
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrap(err, "unable to parse config")
+	}
+	for i, feed := range cfg.Feeds {
+		if feed.Username == "" {
+			return nil, fmt.Errorf("feed %d in config has no username", i)
+		}
+		if err := feed.Filter.validate(); err != nil {
+			return nil, fmt.Errorf("feed %s: %s", feed.Username, err)
+		}
+		if feed.Templates != nil {
+			if err := feed.Templates.compile(); err != nil {
+				return nil, fmt.Errorf("feed %s: %s", feed.Username, err)
+			}
+		}
+		if feed.Frontend != "" {
+			if err := validateFrontend(feed.Frontend); err != nil {
+				return nil, fmt.Errorf("feed %s: %s", feed.Username, err)
+			}
+		}
+		if _, err := time.LoadLocation(feed.Timezone); err != nil {
+			return nil, errors.Wrapf(err, "feed %s", feed.Username)
+		}
+		if _, ok := dateLayouts[feed.Locale]; feed.Locale != "" && !ok {
+			return nil, fmt.Errorf("feed %s: unknown locale %q", feed.Username, feed.Locale)
+		}
+		for _, hook := range feed.Webhooks {
+			switch hook.Format {
+			case "", webhookFormatJSON, webhookFormatDiscord, webhookFormatSlack:
+			default:
+				return nil, fmt.Errorf("feed %s: unknown webhook format %q", feed.Username, hook.Format)
+			}
+		}
+	}
+	for _, digest := range cfg.Digests {
+		if err := digest.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range cfg.Groups {
+		if err := group.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, alert := range cfg.Alerts {
+		if err := alert.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range cfg.LegacyRoutes {
+		if err := cfg.LegacyRoutes[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	for group := range cfg.Headers {
+		switch group {
+		case routeGroupAll, routeGroupFeeds, routeGroupAPI, routeGroupAdmin, routeGroupPages:
+		default:
+			return nil, fmt.Errorf("unknown header route group %q", group)
+		}
+	}
+	return cfg, nil
+}
+
+// addUsernames appends feeds for usernames not already present in the config.
+func (c *config) addUsernames(usernames []string) {
+	for _, username := range usernames {
+		c.Add(feedConfig{Username: username})
+	}
+}
+
+// denyUsernames blocks usernames on top of the config's denylist.
+func (c *config) denyUsernames(usernames []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deniedByFlag = usernames
+	c.DenyUsernames = append(c.DenyUsernames, usernames...)
+}
+
+// Denied reports whether username is on the denylist, however it's spelled.
+func (c *config) Denied(username string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key := normalizeFeedKey(username)
+	for _, denied := range c.DenyUsernames {
+		if normalizeFeedKey(denied) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Add registers a new feed, returning false if the username is already served.
+func (c *config) Add(feed feedConfig) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.feedLocked(feed.Username) != nil {
+		return false
+	}
+	c.Feeds = append(c.Feeds, feed)
+	return true
+}
+
+// Feed looks up the configuration for username. Its Username is the
+// canonical spelling, which the feed is served and cached under.
+func (c *config) Feed(username string) (feedConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	feed := c.feedLocked(username)
+	if feed == nil {
+		return feedConfig{}, false
+	}
+	return *feed, true
+}
+
+// feedLocked finds the feed username names, however it's spelled.
+func (c *config) feedLocked(username string) *feedConfig {
+	key := normalizeFeedKey(username)
+	for i := range c.Feeds {
+		if normalizeFeedKey(c.Feeds[i].Username) == key {
+			return &c.Feeds[i]
+		}
+	}
+	return nil
+}
+
+// List returns a copy of every configured feed.
+func (c *config) List() []feedConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]feedConfig(nil), c.Feeds...)
+}
+
+// ResponseHeaders returns the configured static headers by route group.
+func (c *config) ResponseHeaders() map[string]map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Headers
+}
+
+func (c *config) usernames() []string {
+	var usernames []string
+	for _, feed := range c.List() {
+		usernames = append(usernames, feed.Username)
+	}
+	return usernames
+}
+
+// Reload applies a freshly loaded config: feeds already served take the new
+// settings, new feeds are added and groups, response headers, legacy
+// routes and the denylist are replaced. It returns the usernames that were added.
+func (c *config) Reload(next *config) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Groups = next.Groups
+	c.Alerts = next.Alerts
+	c.Headers = next.Headers
+	c.LegacyRoutes = next.LegacyRoutes
+	c.DenyUsernames = append(append([]string(nil), next.DenyUsernames...), c.deniedByFlag...)
+
+	var added []string
+	for _, feed := range next.Feeds {
+		if current := c.feedLocked(feed.Username); current != nil {
+			*current = feed
+			continue
+		}
+		c.Feeds = append(c.Feeds, feed)
+		added = append(added, feed.Username)
+	}
+	return added
+}
+
+// digest returns the digest called name.
+func (c *config) digest(name string) (digestConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, digest := range c.Digests {
+		if digest.Name == name {
+			return digest, true
+		}
+	}
+	return digestConfig{}, false
+}
+
+// legacyRoutes returns a copy of the legacy routes.
+func (c *config) legacyRoutes() []legacyRoute {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]legacyRoute(nil), c.LegacyRoutes...)
+}
+
+// alerts returns a copy of the alert rules.
+func (c *config) alerts() []alertConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]alertConfig(nil), c.Alerts...)
+}
+
+// PurgeFeed removes username from the feeds, digests and groups, however
+// each spells it.
+func (c *config) PurgeFeed(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := normalizeFeedKey(username)
+	kept := c.Feeds[:0]
+	for _, feed := range c.Feeds {
+		if normalizeFeedKey(feed.Username) != key {
+			kept = append(kept, feed)
+		}
+	}
+	c.Feeds = kept
+	for i := range c.Digests {
+		var feeds []string
+		for _, name := range c.Digests[i].Feeds {
+			if normalizeFeedKey(name) != key {
+				feeds = append(feeds, name)
+			}
+		}
+		c.Digests[i].Feeds = feeds
+	}
+	for i := range c.Groups {
+		var members []string
+		for _, name := range c.Groups[i].Usernames {
+			if normalizeFeedKey(name) != key {
+				members = append(members, name)
+			}
+		}
+		c.Groups[i].Usernames = members
+	}
+	return nil
+}
+
+func (c *config) HasFeed(username string) bool {
+	_, ok := c.Feed(username)
+	return ok
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialLowWater is the share of an endpoint's rate limit left at
+// which calls move on to another credential set.
+const credentialLowWater = 0.1
+
+var (
+	credentialRequests = newCounterVec("twitterrss_credential_requests_total",
+		"Twitter API calls made with each credential set.", "credential")
+	credentialRemaining = newGaugeVec("twitterrss_credential_rate_limit_remaining",
+		"Calls left in the current rate limit window, by credential set and endpoint.", "credential", "endpoint")
+)
+
+// twitterCredential is one set of Twitter API credentials.
+type twitterCredential struct {
+	// name labels the credential in metrics without revealing it.
+	name      string
+	transport http.RoundTripper
+}
+
+// parseTwitterCredential reads a -twitter-credentials value, either
+// "KEY:SECRET" for an app's consumer credentials or "bearer:TOKEN".
+func parseTwitterCredential(value string) (http.RoundTripper, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("-twitter-credentials must be KEY:SECRET or bearer:TOKEN")
+	}
+	if parts[0] == "bearer" {
+		return &bearerTransport{token: parts[1], next: twitterEgress}, nil
+	}
+	return appOnlyTransport(parts[0], parts[1]), nil
+}
+
+// bearerTransport authorizes requests with a fixed bearer token.
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(authorized)
+}
+
+// credentialQuota is what a credential set has left of an endpoint's rate
+// limit, as the last response told.
+type credentialQuota struct {
+	remaining int
+	limit     int
+	reset     time.Time
+}
+
+// usable reports whether calls can keep going to the credential at now.
+func (q credentialQuota) usable(now time.Time) bool {
+	if !now.Before(q.reset) {
+		return true
+	}
+	if q.limit > 0 {
+		return float64(q.remaining) > float64(q.limit)*credentialLowWater
+	}
+	return q.remaining > 0
+}
+
+// share is the part of the limit left, 1 when a new window has started.
+func (q credentialQuota) share(now time.Time) float64 {
+	switch {
+	case !now.Before(q.reset):
+		return 1
+	case q.limit > 0:
+		return float64(q.remaining) / float64(q.limit)
+	case q.remaining > 0:
+		return 1
+	}
+	return 0
+}
+
+// credentialPool spreads Twitter calls over several credential sets. Each
+// endpoint sticks with one until it runs low on that endpoint's rate
+// limit, then moves to whichever has the most left.
+type credentialPool struct {
+	credentials []twitterCredential
+
+	mu     sync.Mutex
+	active map[string]int
+	quotas map[string][]*credentialQuota
+}
+
+func newCredentialPool(credentials []twitterCredential) *credentialPool {
+	return &credentialPool{credentials: credentials, active: map[string]int{}, quotas: map[string][]*credentialQuota{}}
+}
+
+// rateLimitedEndpoint names the endpoint of a request as rate limits are
+// counted, e.g. "api.twitter.com/2/users/:id/tweets".
+func rateLimitedEndpoint(req *http.Request) string {
+	return req.URL.Host + idSegment.ReplaceAllString(req.URL.Path, "/:id$1")
+}
+
+// pick chooses the credential for endpoint, skipping those in skip.
+func (p *credentialPool) pick(endpoint string, skip map[int]bool, now time.Time) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	quotas, ok := p.quotas[endpoint]
+	if !ok {
+		quotas = make([]*credentialQuota, len(p.credentials))
+		for i := range quotas {
+			quotas[i] = &credentialQuota{}
+		}
+		p.quotas[endpoint] = quotas
+	}
+	if active := p.active[endpoint]; !skip[active] && quotas[active].usable(now) {
+		return active, true
+	}
+	best, bestShare := -1, -1.0
+	for i, quota := range quotas {
+		if skip[i] {
+			continue
+		}
+		if share := quota.share(now); share > bestShare {
+			best, bestShare = i, share
+		}
+	}
+	if best < 0 || bestShare <= 0 {
+		return 0, false
+	}
+	p.active[endpoint] = best
+	return best, true
+}
+
+// record keeps the rate limit a response reported.
+func (p *credentialPool) record(endpoint string, i int, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("x-rate-limit-remaining"))
+	if err != nil {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return
+		}
+		remaining = 0
+	}
+	quota := credentialQuota{remaining: remaining}
+	quota.limit, _ = strconv.Atoi(resp.Header.Get("x-rate-limit-limit"))
+	if reset, err := strconv.ParseInt(resp.Header.Get("x-rate-limit-reset"), 10, 64); err == nil {
+		quota.reset = time.Unix(reset, 0)
+	} else {
+		quota.reset = time.Now().Add(15 * time.Minute)
+	}
+	p.mu.Lock()
+	*p.quotas[endpoint][i] = quota
+	p.mu.Unlock()
+	credentialRemaining.Set(float64(remaining), p.credentials[i].name, endpoint)
+}
+
+// RoundTrip sends req with the credential set chosen for its endpoint. A
+// request refused for its rate limit is retried with another set, as long
+// as one has calls left.
+func (p *credentialPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := rateLimitedEndpoint(req)
+	tried := map[int]bool{}
+	for {
+		i, ok := p.pick(endpoint, tried, time.Now())
+		if !ok {
+			return nil, fmt.Errorf("every Twitter credential set is rate limited on %s", endpoint)
+		}
+		tried[i] = true
+		credentialRequests.Inc(p.credentials[i].name)
+		resp, err := p.credentials[i].transport.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		p.record(endpoint, i, resp)
+		if resp.StatusCode != http.StatusTooManyRequests || req.Body != nil || len(tried) == len(p.credentials) {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+// newCredentialPoolFromFlags pools the -consumer-key/-consumer-secret pair,
+// if set, with every -twitter-credentials value, named credential-1,
+// credential-2 and so on in that order.
+func newCredentialPoolFromFlags(consumerKey string, consumerSecret string, values []string) (*credentialPool, error) {
+	var transports []http.RoundTripper
+	if consumerKey != "" && consumerSecret != "" {
+		transports = append(transports, appOnlyTransport(consumerKey, consumerSecret))
+	}
+	for _, value := range values {
+		transport, err := parseTwitterCredential(value)
+		if err != nil {
+			return nil, err
+		}
+		transports = append(transports, transport)
+	}
+	credentials := make([]twitterCredential, len(transports))
+	for i, transport := range transports {
+		credentials[i] = twitterCredential{name: fmt.Sprintf("credential-%d", i+1), transport: transport}
+	}
+	return newCredentialPool(credentials), nil
+}
@@ -0,0 +1,118 @@
+//go:build !minimal
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	newDashboard = func(status *feedStatus, queue *approvalQueue, sla *slaTracker, notifications *notifyQueue) http.HandlerFunc {
+		return DashboardHandler(status, queue, sla, notifications)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Locale.T "admin_title"}}</title>
+</head>
+<body>
+<h1>{{.Locale.T "pending_feeds"}}</h1>
+{{- if .Pending}}
+<table>
+<tr><th>{{.Locale.T "feed"}}</th><th>{{.Locale.T "first_request"}}</th><th>{{.Locale.T "requests"}}</th><th></th></tr>
+{{- range .Pending}}
+<tr>
+<td>{{.Username}}</td>
+<td>{{$.Locale.Date .RequestedAt}}</td>
+<td>{{.Requests}}</td>
+<td>
+<form method="post" action="/admin/pending/{{.Username}}/approve" style="display:inline"><button>{{$.Locale.T "approve"}}</button></form>
+<form method="post" action="/admin/pending/{{.Username}}/reject" style="display:inline"><button>{{$.Locale.T "reject"}}</button></form>
+</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>{{.Locale.T "no_pending"}}</p>
+{{- end}}
+<h1>{{.Locale.T "feeds"}}</h1>
+<ul>
+{{- range .Feeds}}
+<li><a href="{{.Path}}">{{.Username}}</a> &mdash; {{if .LastUpdated.IsZero}}{{$.Locale.T "not_fetched"}}{{else}}{{$.Locale.T "last_updated" ($.Locale.Date .LastUpdated)}}{{end}}
+{{- with .Daily}}{{if .Attempts}} &mdash; {{$.Locale.T "sla" (printf "%.1f%%" .Availability) .P95Age.String}}{{end}}{{end}}</li>
+{{- end}}
+</ul>
+<h1>{{.Locale.T "dead_letters"}}</h1>
+{{- if .Dead}}
+<table>
+<tr><th>{{.Locale.T "notification"}}</th><th>{{.Locale.T "feed"}}</th><th>{{.Locale.T "queued"}}</th><th>{{.Locale.T "attempts"}}</th><th>{{.Locale.T "last_error"}}</th><th></th></tr>
+{{- range .Dead}}
+<tr>
+<td>{{.Kind}} {{.Target}}</td>
+<td>{{.Feed}}</td>
+<td>{{$.Locale.Date .CreatedAt}}</td>
+<td>{{.Attempts}}</td>
+<td>{{.LastError}}</td>
+<td>
+<form method="post" action="/admin/notifications/{{.ID}}/retry" style="display:inline"><button>{{$.Locale.T "retry"}}</button></form>
+<form method="post" action="/admin/notifications/{{.ID}}/discard" style="display:inline"><button>{{$.Locale.T "discard"}}</button></form>
+</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>{{.Locale.T "no_dead_letters"}}</p>
+{{- end}}
+</body>
+</html>
+`))
+
+type dashboardFeed struct {
+	indexEntry
+	// Daily is the feed's health over the last 24 hours.
+	Daily slaWindow
+}
+
+// DashboardHandler renders the admin overview of served and pending feeds.
+func DashboardHandler(status *feedStatus, queue *approvalQueue, sla *slaTracker, notifications *notifyQueue) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Locale  locale
+			Pending []pendingFeed
+			Feeds   []dashboardFeed
+			Dead    []notification
+		}{Locale: negotiateLocale(r), Dead: notifications.Notifications(true)}
+		if queue != nil {
+			data.Pending = queue.Pending()
+		}
+		now := time.Now()
+		for _, info := range status.List() {
+			feed := dashboardFeed{indexEntry: indexEntry{feedInfo: info, Path: feedPath(info.Username)}}
+			for _, window := range sla.Report(info.Username, now) {
+				if window.Window == "24h" {
+					feed.Daily = window
+					feed.Daily.P95Age = window.P95Age.Round(time.Second)
+				}
+			}
+			data.Feeds = append(data.Feeds, feed)
+		}
+
+		var body bytes.Buffer
+		if err := dashboardTemplate.Execute(&body, data); err != nil {
+			panic(errors.Wrap(err, "unable to render dashboard"))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Add("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !minimal
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	registerDebugging = handleDebug
+}
+
+// handleDebug serves the runtime profiles and expvar counters under
+// /debug, to admins only. Profiling adds no overhead until a profile is
+// requested. The command line is left out of both, as flags can carry
+// credentials.
+func handleDebug(r *mux.Router, admin adminAuth) {
+	r.HandleFunc("/debug/vars", RequireAdmin(admin, ExpvarHandler))
+	r.HandleFunc("/debug/pprof/cmdline", http.NotFound)
+	r.HandleFunc("/debug/pprof/profile", RequireAdmin(admin, pprof.Profile))
+	r.HandleFunc("/debug/pprof/symbol", RequireAdmin(admin, pprof.Symbol))
+	r.HandleFunc("/debug/pprof/trace", RequireAdmin(admin, pprof.Trace))
+	// the index lists the profiles and serves each by name, such as heap
+	r.PathPrefix("/debug/pprof/").HandlerFunc(RequireAdmin(admin, pprof.Index))
+}
+
+// ExpvarHandler writes the published expvars as expvar.Handler does,
+// without cmdline.
+func ExpvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
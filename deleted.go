@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// eventItemDeleted is published when an archived tweet is found deleted.
+const eventItemDeleted = "item.deleted"
+
+var errNoLookups = errors.New("source can't look posts up")
+
+func deletedPosts(source timelineSource, ids []string) ([]string, error) {
+	lookups, ok := source.(lookupSource)
+	if !ok {
+		return nil, errNoLookups
+	}
+	return lookups.DeletedPosts(ids)
+}
+
+func (s *fallbackSource) DeletedPosts(ids []string) ([]string, error) {
+	var errs []string
+	for _, named := range s.sources {
+		deleted, err := deletedPosts(named.source, ids)
+		if err == nil {
+			return deleted, nil
+		}
+		if err != errNoLookups {
+			errs = append(errs, fmt.Sprintf("%s: %s", named.name, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errNoLookups
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+// DeletedPosts looks up Twitter posts, the only ones checked for
+// deletion.
+func (s *routedSource) DeletedPosts(ids []string) ([]string, error) {
+	return deletedPosts(s.twitter, ids)
+}
+
+// deletionChecker re-checks the recently archived tweets of every Twitter
+// feed in polling mode, marking those deleted since they were fetched.
+type deletionChecker struct {
+	cfg     *config
+	fetcher *fetcher
+	// window is how long after they were posted tweets are re-checked.
+	window   time.Duration
+	interval time.Duration
+}
+
+// checkFeed marks the tweets of username that were deleted, a batch of
+// lookups at a time. Feeds whose account can't be read are skipped, as
+// lookups can't tell their tweets from deleted ones; a batch that fails is
+// logged and the rest are still checked.
+func (c *deletionChecker) checkFeed(username string) error {
+	if network, _ := splitFeedKey(username); network != "" {
+		return nil
+	}
+	if _, err := c.fetcher.Refresh(context.Background(), username); err != nil {
+		if _, isAccount := accountUnavailable(err); isAccount {
+			debugf(username, "not checking for deleted tweets: %s", err)
+			return nil
+		}
+		return err
+	}
+	ids := c.fetcher.store.Undeleted(username, time.Now().Add(-c.window))
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > feedgen.MaxLookupIDs {
+			batch = batch[:feedgen.MaxLookupIDs]
+		}
+		ids = ids[len(batch):]
+
+		deleted, err := deletedPosts(c.fetcher.source, batch)
+		if err == errNoLookups {
+			return err
+		}
+		if err != nil {
+			log.Print(errors.Wrapf(err, "checking %s for deleted tweets failed", username))
+			continue
+		}
+		for _, it := range c.fetcher.store.MarkDeleted(username, deleted, time.Now()) {
+			debugf(username, "tweet %s was deleted", it.ID)
+			payload := newTweetPayload(it)
+			c.fetcher.bus.Publish(event{Type: eventItemDeleted, Feed: username, Item: &payload})
+		}
+	}
+	return nil
+}
+
+// checkOnce checks every feed, giving up with errNoLookups if the source
+// can't look tweets up at all.
+func (c *deletionChecker) checkOnce() error {
+	for _, username := range c.cfg.usernames() {
+		err := c.checkFeed(username)
+		if err == errNoLookups {
+			return err
+		}
+		if err != nil {
+			log.Print(errors.Wrapf(err, "checking %s for deleted tweets failed", username))
+		}
+	}
+	return nil
+}
+
+func (c *deletionChecker) Run() {
+	for {
+		time.Sleep(c.interval)
+		if err := c.checkOnce(); err != nil {
+			log.Print(errors.Wrap(err, "deleted tweet checks stopped"))
+			return
+		}
+	}
+}
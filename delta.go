@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxItems is the ?max_items= a reader asked for, or 0 for every item.
+func maxItems(query url.Values) (int, error) {
+	value := query.Get("max_items")
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("max_items must be a positive number")
+	}
+	return n, nil
+}
+
+// limitItems keeps the newest n items, or all of them when n is 0.
+func limitItems(items []item, n int) []item {
+	if n > 0 && len(items) > n {
+		return items[:n]
+	}
+	return items
+}
+
+func newestItem(items []item) time.Time {
+	var newest time.Time
+	for _, it := range items {
+		if it.CreatedAt.After(newest) {
+			newest = it.CreatedAt
+		}
+	}
+	return newest
+}
+
+// feedETag names a rendering of a feed. It leads with the newest item's
+// time so a later RFC 3229 request can say which items the reader already
+// has.
+func feedETag(items []item, body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return fmt.Sprintf(`"%d-%s"`, newestItem(items).UnixNano(), hex.EncodeToString(sum[:8]))
+}
+
+// acceptsFeedDelta reports whether the request's A-IM header asks for the
+// "feed" instance manipulation.
+func acceptsFeedDelta(r *http.Request) bool {
+	for _, im := range strings.Split(r.Header.Get("A-IM"), ",") {
+		// manipulations can carry parameters, e.g. "feed;q=1.0"
+		if name := strings.TrimSpace(strings.SplitN(im, ";", 2)[0]); strings.EqualFold(name, "feed") {
+			return true
+		}
+	}
+	return false
+}
+
+// deltaBase is the newest item time in the copy a reader's If-None-Match
+// names, when it is one of our ETags.
+func deltaBase(r *http.Request) (time.Time, bool) {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		i := strings.Index(tag, "-")
+		if i < 0 {
+			continue
+		}
+		if nanos, err := strconv.ParseInt(tag[:i], 10, 64); err == nil {
+			return time.Unix(0, nanos), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// etagMatches reports whether If-None-Match names etag.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFeed answers with the RSS render makes of items. Readers that
+// already have it get a 304, and readers sending "A-IM: feed" with an
+// older copy's ETag get a 226 carrying only the items newer than it, as
+// RFC 3229 describes for feeds.
+func writeFeed(w http.ResponseWriter, r *http.Request, items []item, render func(items []item) (string, error)) error {
+	rss, err := render(items)
+	if err != nil {
+		return err
+	}
+	etag := feedETag(items, rss)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "A-IM")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	status := http.StatusOK
+	if base, ok := deltaBase(r); ok && acceptsFeedDelta(r) {
+		var newer []item
+		for _, it := range items {
+			if it.CreatedAt.After(base) {
+				newer = append(newer, it)
+			}
+		}
+		if len(newer) == 0 {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		if rss, err = render(newer); err != nil {
+			return err
+		}
+		status = http.StatusIMUsed
+		w.Header().Set("IM", "feed")
+		// a delta is only meaningful to the reader that asked for it
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml")
+	w.WriteHeader(status)
+	w.Write([]byte(rss))
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+// digestConfig describes a scheduled email of recent tweets.
+type digestConfig struct {
+	Name       string   `json:"name"`
+	Recipients []string `json:"recipients"`
+	Feeds      []string `json:"feeds"`
+	// Schedule is "daily" or "weekly".
+	Schedule string `json:"schedule"`
+	// Weekday is the day weekly digests are sent, e.g. "monday".
+	Weekday string `json:"weekday,omitempty"`
+	// Hour is the hour of the day the digest is sent.
+	Hour     int    `json:"hour"`
+	Timezone string `json:"timezone,omitempty"`
+	// Hours is how far back the digest reaches. It defaults to the schedule
+	// period so consecutive digests don't overlap.
+	Hours int `json:"hours,omitempty"`
+}
+
+func (d digestConfig) validate() error {
+	if len(d.Recipients) == 0 || len(d.Feeds) == 0 {
+		return fmt.Errorf("digest %q needs recipients and feeds", d.Name)
+	}
+	switch d.Schedule {
+	case "daily":
+	case "weekly":
+		if _, err := parseWeekday(d.Weekday); err != nil {
+			return errors.Wrapf(err, "digest %q", d.Name)
+		}
+	default:
+		return fmt.Errorf("digest %q: schedule must be daily or weekly", d.Name)
+	}
+	if d.Hour < 0 || d.Hour > 23 {
+		return fmt.Errorf("digest %q: hour must be between 0 and 23", d.Name)
+	}
+	if _, err := time.LoadLocation(d.Timezone); err != nil {
+		return errors.Wrapf(err, "digest %q", d.Name)
+	}
+	return nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+func (d digestConfig) period() time.Duration {
+	if d.Hours > 0 {
+		return time.Duration(d.Hours) * time.Hour
+	}
+	if d.Schedule == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// next returns the first send time after now.
+func (d digestConfig) next(now time.Time) time.Time {
+	loc, _ := time.LoadLocation(d.Timezone)
+	now = now.In(loc)
+	at := time.Date(now.Year(), now.Month(), now.Day(), d.Hour, 0, 0, 0, loc)
+	if d.Schedule == "weekly" {
+		weekday, _ := parseWeekday(d.Weekday)
+		at = at.AddDate(0, 0, (int(weekday)-int(at.Weekday())+7)%7)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 7)
+		}
+		return at
+	}
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// digestSender renders digests from the archive and mails them over SMTP.
+type digestSender struct {
+	store *store
+	addr  string
+	auth  smtp.Auth
+	from  string
+	queue *notifyQueue
+}
+
+// emailJob is a queued message.
+type emailJob struct {
+	To      []string `json:"to"`
+	Message []byte   `json:"message"`
+}
+
+func newDigestSender(st *store, addr string, username string, password string, from string, queue *notifyQueue) *digestSender {
+	sender := &digestSender{store: st, addr: addr, from: from, queue: queue}
+	if username != "" {
+		host := strings.Split(addr, ":")[0]
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+	queue.Register(notifyEmail, sender.Deliver)
+	return sender
+}
+
+// Render builds the digest body covering the period before now. It reports
+// false when there is nothing to send.
+func (s *digestSender) Render(digest digestConfig, now time.Time) ([]byte, bool, error) {
+	sections := newsletterSections(s.store, digest.Feeds, now.Add(-digest.period()), now)
+	if len(sections) == 0 {
+		return nil, false, nil
+	}
+	body, err := renderNewsletter(digest.subject(now), sections)
+	return body, true, err
+}
+
+// displayName is the digest's name, or a generic one.
+func (d digestConfig) displayName() string {
+	if d.Name == "" {
+		return "Tweets"
+	}
+	return d.Name
+}
+
+func (d digestConfig) subject(now time.Time) string {
+	return fmt.Sprintf("%s digest for %s", d.displayName(), now.Format("Jan 2, 2006"))
+}
+
+// Send renders a digest and queues it to be mailed, skipping empty ones.
+func (s *digestSender) Send(digest digestConfig, now time.Time) error {
+	html, ok, err := s.Render(digest, now)
+	if err != nil || !ok {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(digest.Recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", digest.subject(now)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(html)
+
+	job := emailJob{To: digest.Recipients, Message: msg.Bytes()}
+	return s.queue.Enqueue(notifyEmail, "", strings.Join(digest.Recipients, ", "), job)
+}
+
+// Deliver makes one attempt at mailing a queued message. Permanent SMTP
+// failures, such as an unknown recipient, aren't retried.
+func (s *digestSender) Deliver(job notification) error {
+	message := emailJob{}
+	if err := json.Unmarshal(job.Payload, &message); err != nil {
+		return backoff.Permanent(err)
+	}
+	err := smtp.SendMail(s.addr, s.auth, s.from, message.To, message.Message)
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return backoff.Permanent(err)
+	}
+	return err
+}
+
+// Run sends digest on its schedule forever.
+func (s *digestSender) Run(digest digestConfig) {
+	for {
+		at := digest.next(time.Now())
+		time.Sleep(time.Until(at))
+		if err := s.Send(digest, at); err != nil {
+			log.Print(errors.Wrapf(err, "unable to send digest %q", digest.Name))
+		}
+	}
+}
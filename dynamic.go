@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	twitterUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+	mastodonFeedKeyPattern = regexp.MustCompile(`^mastodon/[A-Za-z0-9.-]+/[A-Za-z0-9_]+$`)
+	blueskyFeedKeyPattern  = regexp.MustCompile(`^bsky/[A-Za-z0-9.-]+$`)
+)
+
+// validFeedKey reports whether key could name a real account, so the
+// dynamic mode doesn't queue or fetch garbage.
+func validFeedKey(key string) bool {
+	return twitterUsernamePattern.MatchString(key) ||
+		mastodonFeedKeyPattern.MatchString(key) ||
+		blueskyFeedKeyPattern.MatchString(key)
+}
+
+// pendingFeed is a feed someone asked for that an admin hasn't approved.
+type pendingFeed struct {
+	Username    string    `json:"username"`
+	RequestedAt time.Time `json:"requested_at"`
+	Requests    int       `json:"requests"`
+}
+
+type approvalState struct {
+	Pending  map[string]*pendingFeed `json:"pending"`
+	Approved map[string]time.Time    `json:"approved"`
+	Rejected map[string]time.Time    `json:"rejected"`
+}
+
+// approvalQueue holds dynamic feed requests until an admin decides on
+// them. Decisions are saved to path, when set, so approved feeds survive a
+// restart.
+type approvalQueue struct {
+	mu    sync.Mutex
+	path  string
+	state approvalState
+}
+
+func newApprovalQueue(path string) (*approvalQueue, error) {
+	q := &approvalQueue{path: path, state: approvalState{
+		Pending:  map[string]*pendingFeed{},
+		Approved: map[string]time.Time{},
+		Rejected: map[string]time.Time{},
+	}}
+	if path == "" {
+		return q, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.state); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	return q, nil
+}
+
+func (q *approvalQueue) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(q.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data)
+}
+
+// maxPendingFeeds caps the approval queue, so requests can't grow it, and
+// the file it is saved to, without bound.
+const maxPendingFeeds = 1000
+
+// Request records a request for username, queueing it if it hasn't been
+// decided on yet. It fails with errFeedQuota when the queue is full.
+func (q *approvalQueue) Request(username string) (approved bool, pending bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.state.Approved[username]; ok {
+		return true, false, nil
+	}
+	if _, ok := q.state.Rejected[username]; ok {
+		return false, false, nil
+	}
+	if p, ok := q.state.Pending[username]; ok {
+		p.Requests++
+		return false, true, nil
+	}
+	if len(q.state.Pending) >= maxPendingFeeds {
+		return false, false, errFeedQuota
+	}
+	q.state.Pending[username] = &pendingFeed{Username: username, RequestedAt: time.Now(), Requests: 1}
+	return false, true, q.saveLocked()
+}
+
+// Decide approves or rejects username, returning false if it isn't pending.
+func (q *approvalQueue) Decide(username string, approve bool) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.state.Pending[username]; !ok {
+		return false, nil
+	}
+	delete(q.state.Pending, username)
+	if approve {
+		q.state.Approved[username] = time.Now()
+	} else {
+		q.state.Rejected[username] = time.Now()
+	}
+	return true, q.saveLocked()
+}
+
+// Approved lists the approved feeds, so they can be served again after a
+// restart.
+func (q *approvalQueue) Approved() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usernames := make([]string, 0, len(q.state.Approved))
+	for username := range q.state.Approved {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// Pending lists the queued requests, oldest first.
+func (q *approvalQueue) Pending() []pendingFeed {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := make([]pendingFeed, 0, len(q.state.Pending))
+	for _, p := range q.state.Pending {
+		pending = append(pending, *p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].RequestedAt.Before(pending[j].RequestedAt) })
+	return pending
+}
+
+func (q *approvalQueue) PurgeFeed(username string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.state.Pending, username)
+	delete(q.state.Approved, username)
+	delete(q.state.Rejected, username)
+	return errors.Wrap(q.saveLocked(), "unable to save approvals")
+}
+
+func (q *approvalQueue) HasFeed(username string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, pending := q.state.Pending[username]
+	_, approved := q.state.Approved[username]
+	_, rejected := q.state.Rejected[username]
+	return pending || approved || rejected
+}
+
+// errFeedQuota is returned when a client or the whole instance has added
+// as many dynamic feeds as it may.
+var errFeedQuota = errors.New("feed quota exceeded")
+
+// dynamicFeeds serves feeds that aren't configured when -allow-any-username
+// is set, optionally holding each one for admin approval first.
+type dynamicFeeds struct {
+	cfg    *config
+	status *feedStatus
+	queue  *approvalQueue
+
+	// newPerIP and maxFeeds cap how many feeds can be added; either is
+	// unlimited when unset.
+	newPerIP *windowLimiter
+	maxFeeds int
+
+	// failures remembers accounts that couldn't be fetched for failureTTL,
+	// so repeated requests for them don't go upstream.
+	failureTTL time.Duration
+	mu         sync.Mutex
+	failures   map[string]time.Time
+}
+
+// Admit starts serving an unconfigured feed, unless it has to wait for
+// approval, has been rejected or recently failed. ok reports whether the
+// feed is now served and pending whether it is waiting in the queue.
+func (d *dynamicFeeds) Admit(r *http.Request, username string) (ok bool, pending bool, err error) {
+	if !validFeedKey(username) || d.recentlyFailed(username) {
+		return false, false, nil
+	}
+	if network, account := splitFeedKey(username); network == networkMastodon {
+		instance := strings.SplitN(account, "/", 2)[0]
+		if err := checkPublicHost(r.Context(), instance); err != nil {
+			log.Print(errors.Wrapf(err, "not serving %s", username))
+			return false, false, nil
+		}
+	}
+	if d.queue == nil || !d.queue.HasFeed(username) {
+		// asking again for a feed the queue already has costs nothing
+		if err := d.checkQuota(r); err != nil {
+			return false, false, err
+		}
+	}
+	if d.queue != nil {
+		approved, pending, err := d.queue.Request(username)
+		if err != nil || !approved {
+			return false, pending, err
+		}
+	}
+	d.add(username)
+	return true, false, nil
+}
+
+// checkQuota fails with errFeedQuota when the instance has as many feeds,
+// served or waiting for approval, as it may, or r's client has added as
+// many as it may.
+func (d *dynamicFeeds) checkQuota(r *http.Request) error {
+	feeds := len(d.cfg.usernames())
+	if d.queue != nil {
+		feeds += len(d.queue.Pending())
+	}
+	if d.maxFeeds > 0 && feeds >= d.maxFeeds {
+		return errFeedQuota
+	}
+	if d.newPerIP != nil {
+		if ok, _ := d.newPerIP.Allow(clientIP(r)); !ok {
+			return errFeedQuota
+		}
+	}
+	return nil
+}
+
+// Verify fetches a newly admitted feed, dropping it again and returning
+// why if the account can't be fetched.
+func (d *dynamicFeeds) Verify(ctx context.Context, f *fetcher, username string) error {
+	_, err := f.Refresh(ctx, username)
+	if err != nil {
+		log.Print(errors.Wrapf(err, "dropping dynamic feed %s", username))
+		d.cfg.PurgeFeed(username)
+		d.status.PurgeFeed(username)
+		d.mu.Lock()
+		if d.failures == nil {
+			d.failures = map[string]time.Time{}
+		}
+		d.failures[username] = time.Now()
+		d.mu.Unlock()
+	}
+	return err
+}
+
+func (d *dynamicFeeds) recentlyFailed(username string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	failedAt, ok := d.failures[username]
+	if ok && time.Since(failedAt) >= d.failureTTL {
+		delete(d.failures, username)
+		return false
+	}
+	return ok
+}
+
+func (d *dynamicFeeds) add(username string) {
+	if d.cfg.Add(feedConfig{Username: username}) {
+		d.status.Add(username)
+	}
+}
+
+// restoreApproved serves every previously approved feed.
+func (d *dynamicFeeds) restoreApproved() {
+	for _, username := range d.queue.Approved() {
+		d.add(username)
+	}
+}
+
+// PendingHandler lists the feeds waiting for approval.
+func PendingHandler(queue *approvalQueue) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(queue.Pending())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
+
+// DecideHandler approves or rejects a pending feed. Form posts from the
+// dashboard are sent back to it.
+func DecideHandler(d *dynamicFeeds, audit *auditLog, approve bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+
+		// a decision that couldn't be saved still stands until a restart,
+		// so it's acted on and audited before the failure is reported
+		decided, saveErr := d.queue.Decide(username, approve)
+		if !decided {
+			http.NotFound(w, r)
+			return
+		}
+		action := "feed.reject"
+		if approve {
+			d.add(username)
+			action = "feed.approve"
+		}
+		if err := audit.Record(r, action, map[string]string{"feed": username}); err != nil {
+			log.Print(errors.Wrapf(err, "unable to audit %s of %s", action, username))
+			http.Error(w, "the feed was decided but couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+		if saveErr != nil {
+			log.Print(errors.Wrap(saveErr, "unable to save approvals"))
+			http.Error(w, "the feed was decided but the decision couldn't be saved", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+			return
+		}
+		jsonBody, err := json.Marshal(map[string]interface{}{"feed": username, "approved": approve})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
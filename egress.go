@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// egressSettings are the flags for how Twitter API calls leave the
+// network: through which proxy, trusting which certificates, and how long
+// they may take.
+type egressSettings struct {
+	// proxy is an http, https or socks5 proxy URL. Without one the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+	proxy string
+	// caBundle is a PEM file of CAs trusted besides the system's, for
+	// proxies that intercept TLS.
+	caBundle string
+	// minTLSVersion is "1.2" or "1.3".
+	minTLSVersion string
+	// timeout bounds each call, response body included; connectTimeout
+	// just the dial.
+	timeout        time.Duration
+	connectTimeout time.Duration
+	// userAgent is sent on every call, twitterrss/<version> unless set.
+	userAgent string
+	// retries is how many times a call failing with a 5xx or network
+	// error is retried.
+	retries int
+	// breakerFailures consecutive transient failures open the circuit
+	// breaker for breakerCooldown; 0 disables it.
+	breakerFailures int
+	breakerCooldown time.Duration
+}
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// transport builds the transport the settings describe.
+func (e egressSettings) transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if e.connectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: e.connectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+	if e.proxy != "" {
+		proxyURL, err := url.Parse(e.proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -twitter-proxy")
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("-twitter-proxy must be an http, https or socks5 URL")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if e.minTLSVersion != "" {
+		version, ok := tlsVersions[e.minTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("-twitter-tls-min-version must be 1.2 or 1.3")
+		}
+		config.MinVersion = version
+	}
+	if e.caBundle != "" {
+		data, err := os.ReadFile(e.caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read -twitter-ca-bundle")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", e.caBundle)
+		}
+		config.RootCAs = pool
+	}
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+// twitterEgress is the transport under every Twitter API call and token
+// exchange, below auth. It is http.DefaultTransport until configured.
+var twitterEgress = &egressTransport{}
+
+type egressTransport struct {
+	mu        sync.RWMutex
+	next      http.RoundTripper
+	breaker   *circuitBreaker
+	userAgent string
+}
+
+// Configure sends calls over the transport settings describes, each
+// attempt bounded by the timeout, retried and guarded by the breaker.
+func (t *egressTransport) Configure(settings egressSettings) error {
+	transport, err := settings.transport()
+	if err != nil {
+		return err
+	}
+	var attempt http.RoundTripper = transport
+	if settings.timeout > 0 {
+		attempt = &timeoutTransport{next: transport, timeout: settings.timeout}
+	}
+	breaker := newCircuitBreaker(&retryTransport{next: attempt, retries: settings.retries}, settings.breakerFailures, settings.breakerCooldown)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next, t.breaker, t.userAgent = breaker, breaker, settings.userAgent
+	if t.userAgent == "" {
+		t.userAgent = "twitterrss/" + currentBuild().Version
+	}
+	return nil
+}
+
+// RetryIn is how long until the circuit breaker lets calls through again,
+// or 0 if it isn't refusing them.
+func (t *egressTransport) RetryIn(now time.Time) time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.breaker == nil {
+		return 0
+	}
+	return t.breaker.RetryIn(now)
+}
+
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	next, userAgent := t.next, t.userAgent
+	t.mu.RUnlock()
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if userAgent != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", userAgent)
+	}
+	return next.RoundTrip(req)
+}
+
+// timeoutTransport bounds each call, reading its response included.
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose ends a call's timeout once its body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
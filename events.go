@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event types published on the bus.
+const (
+	eventFeedRefreshed  = "feed.refreshed"
+	eventItemAdded      = "item.added"
+	eventFetchFailed    = "fetch.failed"
+	eventConfigReloaded = "config.reloaded"
+)
+
+// event is something that happened inside the service.
+type event struct {
+	ID    int64         `json:"id"`
+	Type  string        `json:"type"`
+	At    time.Time     `json:"at"`
+	Feed  string        `json:"feed,omitempty"`
+	Item  *tweetPayload `json:"item,omitempty"`
+	Count int           `json:"count,omitempty"`
+	Error string        `json:"error,omitempty"`
+
+	// Fresh carries the new items of a feed.refreshed event to in-process
+	// consumers. It is not serialised.
+	Fresh []item `json:"-"`
+}
+
+// eventBus fans events out to in-process handlers and streaming
+// subscribers, and keeps a short history for /api/events.
+type eventBus struct {
+	mu          sync.RWMutex
+	nextID      int64
+	history     []event
+	size        int
+	handlers    []func(event)
+	subscribers map[chan event]bool
+}
+
+func newEventBus(size int) *eventBus {
+	return &eventBus{size: size, subscribers: map[chan event]bool{}}
+}
+
+// Handle registers fn to be called synchronously for every event. Handlers
+// must not block.
+func (b *eventBus) Handle(fn func(event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+// OnNewItems registers fn for feeds that gained items.
+func (b *eventBus) OnNewItems(fn func(username string, fresh []item)) {
+	b.Handle(func(ev event) {
+		if ev.Type == eventFeedRefreshed && len(ev.Fresh) > 0 {
+			fn(ev.Feed, ev.Fresh)
+		}
+	})
+}
+
+// Publish assigns the event an id and delivers it.
+func (b *eventBus) Publish(ev event) {
+	b.mu.Lock()
+	b.nextID++
+	ev.ID = b.nextID
+	ev.At = time.Now()
+	b.history = append(b.history, ev)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+	handlers := b.handlers
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// slow subscribers miss events rather than stall the bus
+		}
+	}
+	b.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(ev)
+	}
+}
+
+// Since returns the retained events with an id greater than id.
+func (b *eventBus) Since(id int64) []event {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	events := []event{}
+	for _, ev := range b.history {
+		if ev.ID > id {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func (b *eventBus) subscribe() chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan event, 64)
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// EventsHandler lists recent events, optionally after ?since=<id>.
+func EventsHandler(bus *eventBus) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+
+		jsonBody, err := json.Marshal(bus.Since(since))
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
+
+// EventStreamHandler streams events as server-sent events, replaying any
+// missed since the Last-Event-ID the client reconnects with. Streams end
+// after lifetime, when set, so the write timeout doesn't cut them off
+// mid-event; clients reconnect and pick up where they left off.
+func EventStreamHandler(bus *eventBus, lifetime time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := bus.subscribe()
+		defer bus.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		write := func(ev event) {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+		}
+
+		lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		if lastID > 0 {
+			for _, ev := range bus.Since(lastID) {
+				write(ev)
+				lastID = ev.ID
+			}
+		}
+		flusher.Flush()
+
+		var end <-chan time.Time
+		if lifetime > 0 {
+			timer := time.NewTimer(lifetime)
+			defer timer.Stop()
+			end = timer.C
+		}
+		keepalive := time.NewTicker(30 * time.Second)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-end:
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+				flusher.Flush()
+			case ev := <-ch:
+				if ev.ID <= lastID {
+					continue
+				}
+				write(ev)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// PurgeFeed drops retained events about username.
+func (b *eventBus) PurgeFeed(username string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.history[:0]
+	for _, ev := range b.history {
+		if ev.Feed != username {
+			kept = append(kept, ev)
+		}
+	}
+	b.history = kept
+	return nil
+}
+
+func (b *eventBus) HasFeed(username string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ev := range b.history {
+		if ev.Feed == username {
+			return true
+		}
+	}
+	return false
+}
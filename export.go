@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// exportArchiveItems is how many of each feed's newest archived items go
+// into an export.
+const exportArchiveItems = 500
+
+// writeExport zips up the OPML, every feed's current XML and its recent
+// archive as JSON. Feeds are rendered from what is already stored; an
+// export never fetches.
+func writeExport(buf *bytes.Buffer, cfg *config, st *store, r *http.Request) error {
+	zw := zip.NewWriter(buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	opml, err := renderOPML(cfg, baseURL(r))
+	if err != nil {
+		return err
+	}
+	if err := add("feeds.opml", opml); err != nil {
+		return err
+	}
+
+	for _, feedCfg := range cfg.List() {
+		name := strings.TrimSuffix(strings.TrimPrefix(feedPath(feedCfg.Username), "/feed/"), ".xml")
+		items := st.ArchivedAsOf(feedCfg.Username, time.Now(), exportArchiveItems)
+
+		feedItems := items
+		if len(feedItems) > timelineSize {
+			feedItems = feedItems[:timelineSize]
+		}
+		rss, err := feedgen.RenderRSS(buildFeed(feedCfg, r, feedItems, time.Now(), nil), nil)
+		if err != nil {
+			return errors.Wrapf(err, "render %s", feedCfg.Username)
+		}
+		if err := add(fmt.Sprintf("feeds/%s.xml", name), []byte(rss)); err != nil {
+			return err
+		}
+
+		archive, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add(fmt.Sprintf("archive/%s.json", name), archive); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ExportHandler downloads a zip backup of the instance's feeds.
+func ExportHandler(cfg *config, st *store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		if err := writeExport(&body, cfg, st, r); err != nil {
+			panic(errors.Wrap(err, "unable to create export"))
+		}
+
+		filename := fmt.Sprintf("twitterrss-%s.zip", time.Now().UTC().Format("2006-01-02"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+	}
+}
+
+// archiveRecord is an archived item as exported for analysis, flattened
+// to what's useful outside a feed.
+type archiveRecord struct {
+	ID          string       `json:"id"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Author      string       `json:"author"`
+	URL         string       `json:"url"`
+	Text        string       `json:"text"`
+	Hashtags    []string     `json:"hashtags,omitempty"`
+	Media       []string     `json:"media,omitempty"`
+	InReplyToID string       `json:"in_reply_to_id,omitempty"`
+	RetweetOf   string       `json:"retweet_of,omitempty"`
+	QuotedID    string       `json:"quoted_id,omitempty"`
+	Lang        string       `json:"lang,omitempty"`
+	Metrics     *itemMetrics `json:"metrics,omitempty"`
+	DeletedAt   *time.Time   `json:"deleted_at,omitempty"`
+}
+
+func newArchiveRecord(it item) archiveRecord {
+	record := archiveRecord{
+		ID:          it.ID,
+		CreatedAt:   it.CreatedAt.UTC(),
+		Author:      it.Author.Username,
+		URL:         it.URL,
+		Text:        it.Text,
+		Hashtags:    it.Hashtags,
+		InReplyToID: it.InReplyToID,
+		RetweetOf:   it.RetweetOf,
+		Lang:        it.Lang,
+		Metrics:     it.Metrics,
+		DeletedAt:   it.DeletedAt,
+	}
+	for _, m := range it.Media {
+		record.Media = append(record.Media, m.URL)
+	}
+	if it.Quoted != nil {
+		record.QuotedID = it.Quoted.ID
+	}
+	return record
+}
+
+// archiveCSVHeader names the columns of a CSV archive export.
+var archiveCSVHeader = []string{"id", "created_at", "author", "url", "text", "hashtags", "media", "in_reply_to_id", "retweet_of", "quoted_id", "lang", "retweets", "likes", "replies", "quotes", "deleted_at"}
+
+func (r archiveRecord) csvRow() []string {
+	metrics := []string{"", "", "", ""}
+	if m := r.Metrics; m != nil {
+		metrics = []string{strconv.Itoa(m.Retweets), strconv.Itoa(m.Likes), strconv.Itoa(m.Replies), strconv.Itoa(m.Quotes)}
+	}
+	deletedAt := ""
+	if r.DeletedAt != nil {
+		deletedAt = r.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return append(append([]string{
+		r.ID, r.CreatedAt.Format(time.RFC3339), r.Author, r.URL, r.Text,
+		strings.Join(r.Hashtags, " "), strings.Join(r.Media, " "),
+		r.InReplyToID, r.RetweetOf, r.QuotedID, r.Lang,
+	}, metrics...), deletedAt)
+}
+
+// writeArchive writes items, oldest first, as JSON Lines or CSV.
+func writeArchive(w io.Writer, items []item, format string) error {
+	switch format {
+	case archiveFormatJSONL:
+		enc := json.NewEncoder(w)
+		for i := len(items) - 1; i >= 0; i-- {
+			if err := enc.Encode(newArchiveRecord(items[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case archiveFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(archiveCSVHeader); err != nil {
+			return err
+		}
+		for i := len(items) - 1; i >= 0; i-- {
+			if err := cw.Write(newArchiveRecord(items[i]).csvRow()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown archive format %q", format)
+}
+
+// Archive export formats.
+const (
+	archiveFormatJSONL = "jsonl"
+	archiveFormatCSV   = "csv"
+)
+
+// ArchiveExportHandler downloads a feed's whole archive as JSON Lines or,
+// with ?format=csv, CSV. Feeds no longer served can still be exported
+// while their archive is kept.
+func ArchiveExportHandler(cfg *config, st *store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+		if feedCfg, ok := cfg.Feed(username); ok {
+			username = feedCfg.Username
+		} else if !st.HasFeed(username) {
+			http.NotFound(w, r)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		contentType := "application/x-ndjson"
+		switch format {
+		case "", archiveFormatJSONL:
+			format = archiveFormatJSONL
+		case archiveFormatCSV:
+			contentType = "text/csv; charset=utf-8"
+		default:
+			http.Error(w, "format must be jsonl or csv", http.StatusBadRequest)
+			return
+		}
+
+		var body bytes.Buffer
+		if err := writeArchive(&body, st.Archived(username), format); err != nil {
+			panic(errors.Wrap(err, "unable to export archive"))
+		}
+		name := strings.Replace(username, "/", "-", -1)
+		filename := fmt.Sprintf("twitterrss-%s-%s.%s", name, time.Now().UTC().Format("2006-01-02"), format)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/gorilla/mux"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// timelineSize is how many tweets a user timeline request returns, and so
+// how many items a feed normally carries.
+const timelineSize = 20
+
+func feedPath(username string) string {
+	if slugs != nil {
+		return fmt.Sprintf("/feed/%s.xml", slugs.Slug(username))
+	}
+	network, account := splitFeedKey(username)
+	if network == networkSearch {
+		return fmt.Sprintf("/feed/search/%s.xml", url.PathEscape(account))
+	}
+	if network == networkTrends {
+		return fmt.Sprintf("/feed/trends/%s.xml", account)
+	}
+	if network == networkAccount {
+		return fmt.Sprintf("/feed/%s.xml", account)
+	}
+	return fmt.Sprintf("/feed/%s.xml", username)
+}
+
+// routeFeedKey returns the feed key a /feed/ route was requested for.
+func routeFeedKey(r *http.Request) string {
+	vars := mux.Vars(r)
+	if instance, ok := vars["instance"]; ok {
+		return networkMastodon + "/" + instance + "/" + vars["user"]
+	}
+	if handle, ok := vars["handle"]; ok {
+		return networkBluesky + "/" + handle
+	}
+	if query, ok := vars["query"]; ok {
+		return searchFeedKey(query)
+	}
+	if woeid, ok := vars["woeid"]; ok {
+		return trendsFeedKey(woeid)
+	}
+	if timeline, ok := vars["timeline"]; ok {
+		return accountFeedKey(timeline)
+	}
+	return vars["username"]
+}
+
+// parseAsOf parses the as_of query parameter. A bare date means the end of
+// that day (UTC), so the feed includes everything posted on it.
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("as_of must be a date (2006-01-02) or RFC 3339 timestamp")
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+// newFeedDocument wraps feed, naming this release as its generator.
+func newFeedDocument(feed *feeds.Feed) *feedDocument {
+	doc := feedgen.NewDocument(feed)
+	doc.Generator = generator()
+	return doc
+}
+
+func buildFeed(feedCfg feedConfig, r *http.Request, items []item, created time.Time, media mediaProxy) *feedDocument {
+	username := feedCfg.Username
+	feed := &feeds.Feed{
+		Title:       feedCfg.templatedTitle(),
+		Link:        &feeds.Link{Href: r.URL.Path},
+		Description: fmt.Sprintf("%s tweets", username),
+		Author:      &feeds.Author{Name: "https://github.com/halkeye/twitterrss"},
+		Created:     created.UTC(),
+	}
+	doc := newFeedDocument(feed)
+
+	var feedItems []*feeds.Item
+	for i := 0; i < len(items); i++ {
+		it := items[i]
+		var enclosure *feeds.Enclosure
+		if media != nil {
+			enclosure = media.Enclosure(baseURL(r), username, it)
+			it = media.Proxied(baseURL(r), username, it)
+		}
+		feedItem := &feeds.Item{
+			Id:          it.ID,
+			Title:       feedCfg.itemTitle(it, feedCfg.deletedTitle(it)),
+			Link:        &feeds.Link{Href: it.URL},
+			Description: feedCfg.itemDescription(it, feedgen.ItemHTML(it)+feedCfg.postedHTML(it)),
+			Created:     it.CreatedAt.UTC(),
+			Enclosure:   enclosure,
+		}
+		feedItems = append(feedItems, feedItem)
+		doc.Annotate(it)
+	}
+
+	feed.Items = feedItems
+	return doc
+}
+
+// deletedTitle is it's title, marked when its tweet was deleted and the
+// feed asks for that.
+func (f feedConfig) deletedTitle(it item) string {
+	if f.MarkDeleted && it.DeletedAt != nil {
+		return "[deleted] " + it.FeedTitle()
+	}
+	return it.FeedTitle()
+}
+
+// postedHTML is when it was posted, for feeds that show dates in their
+// item descriptions.
+func (f feedConfig) postedHTML(it item) string {
+	if f.Timezone == "" && f.Locale == "" && f.DateFormat == "" || it.CreatedAt.IsZero() {
+		return ""
+	}
+	loc, _ := time.LoadLocation(f.Timezone)
+	layout := f.DateFormat
+	if layout == "" {
+		layout = locale{Lang: f.Locale}.DateLayout()
+	}
+	return fmt.Sprintf("\n<p><time datetime=\"%s\">%s</time></p>",
+		it.CreatedAt.UTC().Format(time.RFC3339), html.EscapeString(it.CreatedAt.In(loc).Format(layout)))
+}
+
+// feedItems is what a feed carries of items: its threads unrolled if it
+// wants them, filtered by its filter merged with extra, and linked to its
+// frontend.
+func feedItems(feedCfg feedConfig, items []item, extra itemFilter) ([]item, error) {
+	if feedCfg.UnrollThreads {
+		items = unrollThreads(items)
+	}
+	items, err := feedCfg.Filter.merge(extra).Apply(items)
+	if err != nil {
+		return nil, err
+	}
+	if feedCfg.usesFrontend() {
+		rewritten := make([]item, 0, len(items))
+		for _, it := range items {
+			rewritten = append(rewritten, withFrontend(feedCfg.Frontend, it))
+		}
+		items = rewritten
+	}
+	return items, nil
+}
+
+// UsernameHandler serves a feed, redirecting other spellings of its
+// username to the canonical URL. With paged set, the archive is also
+// served as RFC 5005 archive pages linked from the feed. Accounts that
+// don't exist or can't be read get a 404 or 403, or with tombstones set a
+// feed explaining why.
+func UsernameHandler(cfg *config, f *fetcher, hub *websubPublisher, media mediaProxy, dynamic *dynamicFeeds, clicks *clickCounter, profiles *profileCache, paged bool, tombstones bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requested := routeFeedKey(r)
+		if cfg.Denied(requested) {
+			http.Error(w, "this account isn't served here", http.StatusForbidden)
+			return
+		}
+		username := requested
+		feedCfg, ok := cfg.Feed(username)
+		if !ok && dynamic != nil {
+			username = normalizeFeedKey(username)
+			admitted, pending, err := dynamic.Admit(r, username)
+			if err == errFeedQuota {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
+				panic(errors.Wrap(err, "unable to queue feed"))
+			}
+			if pending {
+				http.Error(w, "feed is waiting for admin approval", http.StatusAccepted)
+				return
+			}
+			if admitted {
+				err := dynamic.Verify(r.Context(), f, username)
+				if unavailable, isAccount := accountUnavailable(err); isAccount {
+					http.Error(w, unavailable.Error(), unavailableStatus(unavailable))
+					return
+				}
+				if err == nil {
+					feedCfg, ok = cfg.Feed(username)
+				}
+			}
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if username = feedCfg.Username; username != requested {
+			// one URL per feed, so readers and caches don't split it
+			canonical := feedPath(username)
+			if r.URL.RawQuery != "" {
+				canonical += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+			return
+		}
+
+		var items []item
+		var pageLinks []atomLink
+		page := 0
+		created := time.Now()
+		if value := r.URL.Query().Get("page"); value != "" {
+			if !paged {
+				http.NotFound(w, r)
+				return
+			}
+			var err error
+			if page, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "page must be a number", http.StatusBadRequest)
+				return
+			}
+			var pages int
+			if items, pages = archivePage(f.store.Archived(username), page); items == nil {
+				http.NotFound(w, r)
+				return
+			}
+			pageLinks = archiveLinks(r, username, page, pages)
+			created = newestItem(items)
+		} else if value := r.URL.Query().Get("as_of"); value != "" {
+			asOf, err := parseAsOf(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			items = f.store.ArchivedAsOf(username, asOf, timelineSize)
+			created = asOf
+		} else {
+			var err error
+			items, err = f.cachedItems(r.Context(), username)
+			if unavailable, isAccount := accountUnavailable(err); isAccount {
+				writeUnavailable(w, r, feedCfg, unavailable, tombstones)
+				return
+			}
+			if err != nil {
+				writeFetchFailure(w, username, err)
+				return
+			}
+			if paged {
+				pageLinks = archiveLinks(r, username, 0, f.store.ArchiveSize(username)/archivePageSize)
+			}
+			if feedCfg.Heartbeat {
+				if beat, ok := heartbeatItem(baseURL(r)+feedPath(username), username, items, time.Now()); ok {
+					items = append([]item{beat}, items...)
+				}
+			}
+		}
+
+		filter, err := queryFilter(r.URL.Query())
+		if err == nil {
+			items, err = feedItems(feedCfg, items, filter)
+		}
+		limit, limitErr := maxItems(r.URL.Query())
+		if err == nil {
+			err = limitErr
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		items = limitItems(items, limit)
+
+		var links []atomLink
+		if hub != nil {
+			links = hub.Links(username)
+		}
+		links = append(links, pageLinks...)
+		render := func(items []item) (string, error) {
+			_, rendering := startSpan(r.Context(), "render feed", spanKindInternal)
+			feed := buildFeed(feedCfg, r, items, created, media)
+			feed.Archive = page > 0
+			if profiles != nil {
+				if p, ok := profiles.Get(username); ok {
+					applyProfile(feed, feedCfg, p)
+				}
+			}
+			if clicks != nil {
+				clicks.Rewrite(baseURL(r), feed)
+			}
+			feed.TTL = f.store.ttl
+			rss, err := feedgen.RenderRSS(feed, links)
+			rendering.End(err)
+			return rss, err
+		}
+
+		setLinkHeader(w, links)
+		setSurrogateKeys(w, surrogateKey(username))
+		if page > 0 {
+			setFeedCaching(w, archivePageLifetime)
+		} else {
+			setFeedCaching(w, f.store.FreshFor(username, time.Now()))
+		}
+		if err := writeFeed(w, r, items, render); err != nil {
+			panic(errors.Wrap(err, "unable to create rss feed"))
+		}
+	}
+}
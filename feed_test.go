@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/gorilla/mux"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+)
+
+// testFeedServer serves feeds from a fake v1.1 API, as -twitter-fixtures
+// would.
+type testFeedServer struct {
+	*mux.Router
+	client  *feedgen.FakeTwitterClient
+	cfg     *config
+	fetcher *fetcher
+}
+
+// newTestFeedServer serves the configured usernames' feeds, and search
+// feeds, caching timelines for ttl.
+func newTestFeedServer(t *testing.T, ttl time.Duration, usernames ...string) *testFeedServer {
+	t.Helper()
+	client := feedgen.NewFakeTwitterClient(feedgen.TwitterFixtures{})
+	cfg := &config{}
+	cfg.addUsernames(usernames)
+	routed := newRoutedSource(feedgen.NewTwitterV1SourceWithClient(client))
+	routed.Add(networkSearch, newTwitterSearchSource(client))
+	f := newFetcher(routed, cfg, newStore(ttl), newFeedStatus(cfg.usernames()), newEventBus(10))
+
+	r := mux.NewRouter()
+	feedHandler := UsernameHandler(cfg, f, nil, nil, nil, nil, nil, false, false)
+	r.HandleFunc("/feed/{username}.xml", feedHandler)
+	r.HandleFunc("/feed/search/{query}.xml", feedHandler)
+	return &testFeedServer{Router: r, client: client, cfg: cfg, fetcher: f}
+}
+
+func testTweet(id string, username string, text string) twitter.Tweet {
+	return twitter.Tweet{
+		IDStr:     id,
+		FullText:  text,
+		CreatedAt: "Mon Oct 12 10:00:00 +0000 2026",
+		User:      &twitter.User{ScreenName: username, Name: "Alice"},
+	}
+}
+
+func getFeed(r http.Handler, username string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/feed/"+username+".xml", nil))
+	return w
+}
+
+func TestUsernameHandlerServesTimeline(t *testing.T) {
+	s := newTestFeedServer(t, time.Minute, "alice")
+	s.client.AddTweet(testTweet("1500000000000000000", "alice", "hello from the fake"))
+
+	w := getFeed(s, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "hello from the fake") {
+		t.Errorf("feed doesn't include the tweet:\n%s", w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "https://twitter.com/alice/status/1500000000000000000") {
+		t.Errorf("feed doesn't link to the tweet:\n%s", w.Body)
+	}
+	if calls := s.client.Calls(); len(calls) != 1 || calls[0] != "user_timeline alice" {
+		t.Errorf("calls = %q, want one user_timeline", calls)
+	}
+}
+
+func TestUsernameHandlerUnconfiguredFeed(t *testing.T) {
+	s := newTestFeedServer(t, time.Minute, "alice")
+
+	if w := getFeed(s, "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if calls := s.client.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %q, want none for a feed that isn't served", calls)
+	}
+}
+
+func TestUsernameHandlerMissingAccount(t *testing.T) {
+	s := newTestFeedServer(t, time.Minute, "ghost")
+
+	if w := getFeed(s, "ghost"); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestUsernameHandlerSuspendedAccount(t *testing.T) {
+	s := newTestFeedServer(t, time.Minute, "alice")
+	s.client.FailWith(twitter.APIError{Errors: []twitter.ErrorDetail{{Code: 63, Message: "User has been suspended."}}})
+
+	if w := getFeed(s, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestUsernameHandlerUpstreamError(t *testing.T) {
+	s := newTestFeedServer(t, time.Minute, "alice")
+	s.client.FailWith(errors.New("connection reset"))
+
+	w := getFeed(s, "alice")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("503 has no Retry-After")
+	}
+}
+
+func TestUsernameHandlerServesStaleOnUpstreamError(t *testing.T) {
+	// nothing is fresh, so every request refetches
+	s := newTestFeedServer(t, 0, "alice")
+	s.client.AddTweet(testTweet("1500000000000000000", "alice", "cached before the outage"))
+	if w := getFeed(s, "alice"); w.Code != http.StatusOK {
+		t.Fatalf("first fetch status = %d, want 200", w.Code)
+	}
+
+	s.client.FailWith(errors.New("connection reset"))
+
+	w := getFeed(s, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 from the stale cache", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "cached before the outage") {
+		t.Errorf("stale feed doesn't include the cached tweet:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// feedToken is the token a reader presented: the basic auth password (with
+// any username), a bearer token or, for readers that can't send headers,
+// the token query parameter.
+func feedToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); bearer != r.Header.Get("Authorization") {
+		return bearer
+	}
+	return r.URL.Query().Get("token")
+}
+
+func tokenMatches(given string, tokens []string) bool {
+	ok := false
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// feedAuth protects feeds with tokens. Global tokens open every feed; a
+// feed's own tokens open just that feed. Feeds are public when neither
+// applies. Groups and briefings only take global tokens, since they would
+// otherwise expose protected member feeds.
+type feedAuth struct {
+	cfg    *config
+	tokens []string
+}
+
+// accepted lists the tokens that open the feed or group requested by r, and
+// whether one is needed at all.
+func (a *feedAuth) accepted(r *http.Request) ([]string, bool) {
+	vars := mux.Vars(r)
+	if name, ok := vars["group"]; ok {
+		group, _ := a.cfg.Group(name)
+		return a.combined(group.Usernames)
+	}
+	if name, ok := vars["digest"]; ok {
+		digest, _ := a.cfg.digest(name)
+		return a.combined(digest.Feeds)
+	}
+
+	feedCfg, _ := a.cfg.Feed(routeFeedKey(r))
+	tokens := append(append([]string(nil), a.tokens...), feedCfg.Tokens...)
+	return tokens, len(tokens) > 0
+}
+
+// combined is accepted for a page combining several feeds.
+func (a *feedAuth) combined(usernames []string) ([]string, bool) {
+	if len(a.tokens) > 0 {
+		return a.tokens, true
+	}
+	for _, username := range usernames {
+		if feedCfg, ok := a.cfg.Feed(username); ok && len(feedCfg.Tokens) > 0 {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// Opens reports whether r may read username's feed, for pages mixing
+// items from several feeds that only show those of the feeds it opens.
+func (a *feedAuth) Opens(r *http.Request, username string) bool {
+	feedCfg, _ := a.cfg.Feed(username)
+	if len(feedCfg.Tokens) == 0 {
+		return true
+	}
+	return tokenMatches(feedToken(r), append(append([]string(nil), a.tokens...), feedCfg.Tokens...))
+}
+
+// Require rejects requests for protected feeds without a matching token.
+func (a *feedAuth) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, required := a.accepted(r)
+		if required && !tokenMatches(feedToken(r), tokens) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="twitterrss feeds"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if required {
+			// shared caches mustn't hand a protected feed to anyone else
+			w.Header().Set("Cache-Control", "private")
+		}
+		next(w, r)
+	}
+}
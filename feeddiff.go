@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// diffItem is what "twitterrss diff" compares of a rendered item.
+type diffItem struct {
+	GUID      string
+	Link      string
+	Title     string
+	Published time.Time
+	// Hash is of the item's title and content, ignoring markup and spacing.
+	Hash string
+}
+
+var (
+	markup     = regexp.MustCompile(`<[^>]*>`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+func contentHash(parts ...string) string {
+	text := strings.Join(parts, "\n")
+	text = whitespace.ReplaceAllString(markup.ReplaceAllString(text, " "), " ")
+	sum := sha256.Sum256([]byte(strings.TrimSpace(text)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseFeedTime reads the date formats RSS, Atom and JSON feeds use.
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, time.RFC822Z, time.RFC822} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// readFeedSource reads a feed from a URL or a file.
+func readFeedSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := ioutil.ReadFile(source)
+		return data, errors.Wrapf(err, "unable to read %s", source)
+	}
+	resp, err := (&http.Client{Timeout: time.Minute}).Get(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to fetch %s", source)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", source, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	return data, errors.Wrapf(err, "unable to fetch %s", source)
+}
+
+// parseDiffItems reads the items of an RSS, Atom or JSON feed.
+func parseDiffItems(data []byte) ([]diffItem, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		doc := struct {
+			Items []struct {
+				ID            string `json:"id"`
+				URL           string `json:"url"`
+				Title         string `json:"title"`
+				ContentHTML   string `json:"content_html"`
+				ContentText   string `json:"content_text"`
+				Summary       string `json:"summary"`
+				DatePublished string `json:"date_published"`
+			} `json:"items"`
+		}{}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, errors.Wrap(err, "invalid JSON feed")
+		}
+		items := make([]diffItem, 0, len(doc.Items))
+		for _, it := range doc.Items {
+			items = append(items, diffItem{
+				GUID:      it.ID,
+				Link:      it.URL,
+				Title:     it.Title,
+				Published: parseFeedTime(it.DatePublished),
+				Hash:      contentHash(it.Title, it.ContentHTML+it.ContentText+it.Summary),
+			})
+		}
+		return items, nil
+	}
+
+	doc := struct {
+		Items []struct {
+			GUID        string `xml:"guid"`
+			Link        string `xml:"link"`
+			Title       string `xml:"title"`
+			Description string `xml:"description"`
+			Content     string `xml:"encoded"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"channel>item"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+			Summary   string `xml:"summary"`
+			Content   string `xml:"content"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+		} `xml:"entry"`
+	}{}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "invalid feed")
+	}
+	var items []diffItem
+	for _, it := range doc.Items {
+		guid := it.GUID
+		if guid == "" {
+			guid = it.Link
+		}
+		content := it.Content
+		if content == "" {
+			content = it.Description
+		}
+		items = append(items, diffItem{
+			GUID:      guid,
+			Link:      it.Link,
+			Title:     it.Title,
+			Published: parseFeedTime(it.PubDate),
+			Hash:      contentHash(it.Title, content),
+		})
+	}
+	for _, entry := range doc.Entries {
+		it := diffItem{GUID: entry.ID, Title: entry.Title}
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				it.Link = link.Href
+				break
+			}
+		}
+		published := entry.Published
+		if published == "" {
+			published = entry.Updated
+		}
+		it.Published = parseFeedTime(published)
+		content := entry.Content
+		if content == "" {
+			content = entry.Summary
+		}
+		it.Hash = contentHash(entry.Title, content)
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// diffFeeds lists how feed b's items differ from feed a's. Items are
+// matched by GUID, then by link, as other tools often pick other GUIDs
+// for the same tweet.
+func diffFeeds(a []diffItem, b []diffItem) []string {
+	byGUID := map[string]int{}
+	byLink := map[string]int{}
+	for i, it := range b {
+		byGUID[it.GUID] = i
+		if it.Link != "" {
+			byLink[it.Link] = i
+		}
+	}
+
+	var differences []string
+	matched := map[int]bool{}
+	for _, it := range a {
+		i, ok := byGUID[it.GUID]
+		if !ok && it.Link != "" {
+			i, ok = byLink[it.Link]
+		}
+		if !ok || matched[i] {
+			differences = append(differences, fmt.Sprintf("- %s %q", it.GUID, it.Title))
+			continue
+		}
+		matched[i] = true
+		other := b[i]
+		if other.GUID != it.GUID {
+			differences = append(differences, fmt.Sprintf("~ %s: guid is %s", it.GUID, other.GUID))
+		}
+		if other.Link != it.Link {
+			differences = append(differences, fmt.Sprintf("~ %s: link %s vs %s", it.GUID, it.Link, other.Link))
+		}
+		if !other.Published.Equal(it.Published) {
+			differences = append(differences, fmt.Sprintf("~ %s: published %s vs %s", it.GUID, it.Published.Format(time.RFC3339), other.Published.Format(time.RFC3339)))
+		}
+		if other.Hash != it.Hash {
+			differences = append(differences, fmt.Sprintf("~ %s: content differs", it.GUID))
+		}
+	}
+	for i, it := range b {
+		if !matched[i] {
+			differences = append(differences, fmt.Sprintf("+ %s %q", it.GUID, it.Title))
+		}
+	}
+	return differences
+}
+
+// runDiff is "twitterrss diff <feed> <feed>". Like diff(1), it exits 1
+// when the feeds differ and 2 when they can't be compared.
+func runDiff(args []string, out io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: twitterrss diff <feed-url-or-file> <feed-url-or-file>")
+		return 2
+	}
+	var parsed [2][]diffItem
+	for i, source := range args {
+		data, err := readFeedSource(source)
+		if err == nil {
+			parsed[i], err = parseDiffItems(data)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	differences := diffFeeds(parsed[0], parsed[1])
+	for _, line := range differences {
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintf(out, "%d items in %s, %d in %s, %d differences\n", len(parsed[0]), args[0], len(parsed[1]), args[1], len(differences))
+	if len(differences) > 0 {
+		return 1
+	}
+	return 0
+}
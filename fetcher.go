@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fetcher loads timelines from the source into the store and publishes
+// what happened on the event bus, whether the fetch came from a reader or
+// the poller.
+type fetcher struct {
+	source timelineSource
+	cfg    *config
+	store  *store
+	status *feedStatus
+	bus    *eventBus
+	// maxStale is how long past the ttl a cached timeline is still served
+	// while it's refreshed in the background; 0 refreshes before serving.
+	maxStale time.Duration
+
+	mu         sync.Mutex
+	revalidate map[string]bool
+}
+
+func newFetcher(source timelineSource, cfg *config, st *store, status *feedStatus, bus *eventBus) *fetcher {
+	return &fetcher{source: source, cfg: cfg, store: st, status: status, bus: bus, revalidate: map[string]bool{}}
+}
+
+// Refresh fetches username's timeline from the source regardless of the
+// cache. Cancelling ctx, as a reader disconnecting does, abandons the
+// fetch.
+func (f *fetcher) Refresh(ctx context.Context, username string) ([]item, error) {
+	ctx, s := startSpan(ctx, "fetch timeline", spanKindInternal)
+	s.SetAttribute("feed", username)
+	items, err := f.refresh(ctx, username)
+	s.End(err)
+	return items, err
+}
+
+func (f *fetcher) refresh(ctx context.Context, username string) ([]item, error) {
+	opts := defaultFetchOptions
+	opts.Context = ctx
+	feedCfg, _ := f.cfg.Feed(username)
+	if feedCfg.UnrollThreads {
+		opts.ExcludeReplies = false
+	}
+	debugf(username, "fetching %d items (exclude replies: %t)", opts.Count, opts.ExcludeReplies)
+	items, err := f.source.FetchTimeline(username, opts)
+	if err != nil && ctx.Err() != nil {
+		// the reader went away, which says nothing about the source
+		debugf(username, "fetch abandoned: %s", ctx.Err())
+		return nil, errors.Wrap(ctx.Err(), "Unable to get tweets")
+	}
+	if err != nil {
+		debugf(username, "fetch failed: %s", err)
+		err = errors.Wrap(err, "Unable to get tweets")
+		f.bus.Publish(event{Type: eventFetchFailed, Feed: username, Error: err.Error()})
+		return nil, err
+	}
+	if !opts.ExcludeReplies {
+		// threads need the self-replies, but replies to others stay out
+		kept := items[:0]
+		for _, it := range items {
+			if it.InReplyToID == "" || it.SelfReply() {
+				kept = append(kept, it)
+			}
+		}
+		items = kept
+	}
+	items = f.store.WithoutRedacted(items)
+
+	fresh := f.store.Put(username, items)
+	debugf(username, "fetched %d items, %d new", len(items), len(fresh))
+	f.status.Update(username, items)
+	for _, it := range fresh {
+		payload := newTweetPayload(it)
+		f.bus.Publish(event{Type: eventItemAdded, Feed: username, Item: &payload})
+	}
+	f.bus.Publish(event{Type: eventFeedRefreshed, Feed: username, Count: len(fresh), Fresh: fresh})
+	return items, nil
+}
+
+// cachedItems returns the cached timeline for username, fetching it when
+// the cache is missing or stale. Within maxStale of expiring the stale
+// copy is served at once and refreshed behind the reader's back, and when
+// the fetch fails it is served rather than the error, unless the account
+// itself is unavailable.
+func (f *fetcher) cachedItems(ctx context.Context, username string) ([]item, error) {
+	if entry := f.store.Fresh(username); entry != nil {
+		debugf(username, "serving %d cached items fetched at %s", len(entry.Items), entry.FetchedAt.Format(time.RFC3339))
+		return entry.Items, nil
+	}
+	if entry := f.store.Latest(username); entry != nil && f.maxStale > 0 && time.Since(entry.FetchedAt) <= f.store.ttl+f.maxStale {
+		debugf(username, "serving %d stale items fetched at %s while revalidating", len(entry.Items), entry.FetchedAt.Format(time.RFC3339))
+		f.revalidateInBackground(username)
+		return entry.Items, nil
+	}
+	items, err := f.Refresh(ctx, username)
+	var unavailable *accountError
+	if err != nil && !errors.As(err, &unavailable) {
+		// whatever went wrong upstream, the last fetch beats an error page
+		if entry := f.store.Latest(username); entry != nil {
+			debugf(username, "fetch failed, serving %d stale items fetched at %s", len(entry.Items), entry.FetchedAt.Format(time.RFC3339))
+			staleServed.Inc()
+			return entry.Items, nil
+		}
+	}
+	return items, err
+}
+
+// revalidateInBackground refreshes username unless a refresh is already
+// under way. It isn't tied to the reader's request, so it finishes even if
+// they go away.
+func (f *fetcher) revalidateInBackground(username string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.revalidate[username] {
+		return
+	}
+	f.revalidate[username] = true
+	go func() {
+		if _, err := f.Refresh(context.Background(), username); err != nil {
+			log.Print(errors.Wrapf(err, "background refresh of %s failed", username))
+		}
+		f.mu.Lock()
+		delete(f.revalidate, username)
+		f.mu.Unlock()
+	}()
+}
+
+// defaultRetryAfter is how soon readers are asked to try again when a
+// feed's source failed and no breaker says when it will be back.
+const defaultRetryAfter = time.Minute
+
+// retryAfter is how soon username's source may answer again: once the
+// Twitter API breaker closes for feeds read from Twitter, or else after
+// defaultRetryAfter.
+func retryAfter(username string, now time.Time) time.Duration {
+	network, _ := splitFeedKey(username)
+	if network != networkMastodon && network != networkBluesky {
+		if wait := twitterEgress.RetryIn(now); wait > 0 {
+			return wait
+		}
+	}
+	return defaultRetryAfter
+}
+
+// writeFetchFailure answers for a feed whose fetch failed with nothing
+// cached to serve instead: 503, with when to try again.
+func writeFetchFailure(w http.ResponseWriter, username string, err error) {
+	log.Print(errors.Wrapf(err, "unable to serve %s", username))
+	wait := retryAfter(username, time.Now())
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	http.Error(w, "the feed can't be fetched right now", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// itemFilter keeps or drops items by their text. Each term is a keyword
+// matched case-insensitively, a "#hashtag" matched as a whole tag, or a
+// "/regular expression/".
+type itemFilter struct {
+	// Include keeps only items matching at least one term, when set.
+	Include []string `json:"include,omitempty"`
+	// Exclude drops items matching any term.
+	Exclude []string `json:"exclude,omitempty"`
+	// MinLikes and MinRetweets keep only items with at least that much
+	// engagement. Items whose source has no metrics are dropped.
+	MinLikes    int `json:"min_likes,omitempty"`
+	MinRetweets int `json:"min_retweets,omitempty"`
+	// Languages keeps only items in one of these languages. Items the
+	// source couldn't detect a language for are kept.
+	Languages []string `json:"languages,omitempty"`
+}
+
+type filterTerm func(text string) bool
+
+func compileFilterTerm(term string) (filterTerm, error) {
+	switch {
+	case len(term) > 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/"):
+		re, err := regexp.Compile(term[1 : len(term)-1])
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %s", term, err)
+		}
+		return re.MatchString, nil
+	case len(term) > 1 && strings.HasPrefix(term, "#"):
+		re := regexp.MustCompile(`(?i)(^|[^\pL\pN_])` + regexp.QuoteMeta(term) + `($|[^\pL\pN_])`)
+		return re.MatchString, nil
+	case term == "":
+		return nil, fmt.Errorf("empty filter term")
+	}
+	lower := strings.ToLower(term)
+	return func(text string) bool { return strings.Contains(strings.ToLower(text), lower) }, nil
+}
+
+func compileFilterTerms(terms []string) ([]filterTerm, error) {
+	var compiled []filterTerm
+	for _, term := range terms {
+		fn, err := compileFilterTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, fn)
+	}
+	return compiled, nil
+}
+
+func (f itemFilter) validate() error {
+	if f.MinLikes < 0 || f.MinRetweets < 0 {
+		return fmt.Errorf("engagement minimums can't be negative")
+	}
+	if _, err := compileFilterTerms(f.Include); err != nil {
+		return err
+	}
+	_, err := compileFilterTerms(f.Exclude)
+	return err
+}
+
+// merge adds other's terms to f, keeping the higher engagement minimums.
+func (f itemFilter) merge(other itemFilter) itemFilter {
+	merged := itemFilter{
+		Include:     append(append([]string(nil), f.Include...), other.Include...),
+		Exclude:     append(append([]string(nil), f.Exclude...), other.Exclude...),
+		MinLikes:    f.MinLikes,
+		MinRetweets: f.MinRetweets,
+		Languages:   f.Languages,
+	}
+	// asking for languages narrows the feed to them whatever is configured
+	if len(other.Languages) > 0 {
+		merged.Languages = other.Languages
+	}
+	if other.MinLikes > merged.MinLikes {
+		merged.MinLikes = other.MinLikes
+	}
+	if other.MinRetweets > merged.MinRetweets {
+		merged.MinRetweets = other.MinRetweets
+	}
+	return merged
+}
+
+// inLanguage reports whether it is in one of the filter's languages,
+// matching "en" against regional tags like "en-GB".
+func (f itemFilter) inLanguage(it item) bool {
+	if len(f.Languages) == 0 {
+		return true
+	}
+	lang := strings.ToLower(it.Lang)
+	if lang == "" || lang == "und" {
+		return true
+	}
+	if i := strings.Index(lang, "-"); i >= 0 {
+		lang = lang[:i]
+	}
+	for _, want := range f.Languages {
+		if strings.EqualFold(want, lang) || strings.EqualFold(want, it.Lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// engaged reports whether it meets the engagement minimums.
+func (f itemFilter) engaged(it item) bool {
+	if f.MinLikes == 0 && f.MinRetweets == 0 {
+		return true
+	}
+	if it.Metrics == nil {
+		return false
+	}
+	return it.Metrics.Likes >= f.MinLikes && it.Metrics.Retweets >= f.MinRetweets
+}
+
+func (f itemFilter) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.MinLikes == 0 && f.MinRetweets == 0 && len(f.Languages) == 0
+}
+
+// filterVerdict is whether the filter keeps an item, and why.
+type filterVerdict struct {
+	Item item
+	Kept bool
+	// Reason names the rule that decided: the include or exclude term
+	// that matched, or the minimum or language the item missed.
+	Reason string
+}
+
+// Explain decides every item, saying which rule kept or dropped it.
+func (f itemFilter) Explain(items []item) ([]filterVerdict, error) {
+	include, err := compileFilterTerms(f.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileFilterTerms(f.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	// matching is the first of terms that matches text
+	matching := func(terms []filterTerm, text string) (int, bool) {
+		for i, term := range terms {
+			if term(text) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	verdicts := make([]filterVerdict, 0, len(items))
+	for _, it := range items {
+		text := it.Text
+		if it.Quoted != nil {
+			text += "\n" + it.Quoted.Text
+		}
+		verdict := filterVerdict{Item: it}
+		i, included := matching(include, text)
+		j, excluded := matching(exclude, text)
+		switch {
+		case len(include) > 0 && !included:
+			verdict.Reason = "matches no include term"
+		case excluded:
+			verdict.Reason = "excluded by " + f.Exclude[j]
+		case !f.engaged(it):
+			verdict.Reason = fmt.Sprintf("below min_likes %d or min_retweets %d", f.MinLikes, f.MinRetweets)
+		case !f.inLanguage(it):
+			verdict.Reason = fmt.Sprintf("language %q isn't one of %s", it.Lang, strings.Join(f.Languages, ", "))
+		case included:
+			verdict.Kept, verdict.Reason = true, "included by "+f.Include[i]
+		default:
+			verdict.Kept = true
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	return verdicts, nil
+}
+
+// Apply returns the items that pass the filter.
+func (f itemFilter) Apply(items []item) ([]item, error) {
+	if f.empty() {
+		return items, nil
+	}
+	verdicts, err := f.Explain(items)
+	if err != nil {
+		return nil, err
+	}
+	var kept []item
+	for _, verdict := range verdicts {
+		if verdict.Kept {
+			kept = append(kept, verdict.Item)
+		}
+	}
+	return kept, nil
+}
+
+// queryFilter reads ?include=, ?exclude= and ?lang= lists, comma separated
+// or repeated, and the ?min_likes= and ?min_retweets= minimums.
+func queryFilter(query url.Values) (itemFilter, error) {
+	split := func(values []string) []string {
+		var terms []string
+		for _, value := range values {
+			for _, term := range strings.Split(value, ",") {
+				if term = strings.TrimSpace(term); term != "" {
+					terms = append(terms, term)
+				}
+			}
+		}
+		return terms
+	}
+	minimum := func(name string) (int, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s must be a non-negative number", name)
+		}
+		return n, nil
+	}
+
+	filter := itemFilter{
+		Include:   split(query["include"]),
+		Exclude:   split(query["exclude"]),
+		Languages: split(query["lang"]),
+	}
+	var err error
+	if filter.MinLikes, err = minimum("min_likes"); err != nil {
+		return itemFilter{}, err
+	}
+	if filter.MinRetweets, err = minimum("min_retweets"); err != nil {
+		return itemFilter{}, err
+	}
+	return filter, nil
+}
+
+type filterTestRequest struct {
+	Username string     `json:"username"`
+	Filter   itemFilter `json:"filter"`
+	// Configured adds the feed's configured filter to the one under test,
+	// to try a rule alongside the existing ones.
+	Configured bool `json:"configured,omitempty"`
+}
+
+type filterTestItem struct {
+	tweetPayload
+	Included bool   `json:"included"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// FilterTestHandler runs a filter over a feed's recent tweets and reports
+// which it would include or exclude and why, without changing the feed.
+func FilterTestHandler(cfg *config, f *fetcher) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := filterTestRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid filter test request", http.StatusBadRequest)
+			return
+		}
+		feedCfg, ok := cfg.Feed(req.Username)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// the feed is cached under its canonical key, not the request's
+		req.Username = feedCfg.Username
+		filter := req.Filter
+		if req.Configured {
+			filter = feedCfg.Filter.merge(filter)
+		}
+		if err := filter.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items, err := f.cachedItems(r.Context(), req.Username)
+		if err != nil {
+			writeFetchFailure(w, req.Username, err)
+			return
+		}
+		verdicts, err := filter.Explain(items)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := struct {
+			Username string           `json:"username"`
+			Included int              `json:"included"`
+			Excluded int              `json:"excluded"`
+			Items    []filterTestItem `json:"items"`
+		}{Username: req.Username, Items: []filterTestItem{}}
+		for _, verdict := range verdicts {
+			if verdict.Kept {
+				response.Included++
+			} else {
+				response.Excluded++
+			}
+			response.Items = append(response.Items, filterTestItem{
+				tweetPayload: newTweetPayload(verdict.Item),
+				Included:     verdict.Kept,
+				Reason:       verdict.Reason,
+			})
+		}
+
+		jsonBody, err := json.Marshal(response)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
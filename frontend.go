@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// twitterLinkPattern matches the scheme and host of a twitter.com (or
+// x.com) link, so the rest of the path can be kept on another frontend.
+var twitterLinkPattern = regexp.MustCompile(`https?://(?:www\.|mobile\.)?(?:twitter|x)\.com/`)
+
+// mentionPattern matches an @mention or #hashtag that starts a word, and not
+// one inside a link, entity or existing anchor.
+var mentionPattern = regexp.MustCompile(`(^|[^\w@#&/">])([@#])(\w+)`)
+
+// validateFrontend checks frontend is an absolute http(s) URL.
+func validateFrontend(frontend string) error {
+	u, err := url.Parse(frontend)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("frontend %q must be an http or https URL", frontend)
+	}
+	return nil
+}
+
+// usesFrontend reports whether the feed's links are rewritten to its
+// frontend, which only applies to Twitter feeds.
+func (f feedConfig) usesFrontend() bool {
+	network, _ := splitFeedKey(f.Username)
+	return f.Frontend != "" && (network == "" || network == networkSearch || network == networkAccount)
+}
+
+// withFrontend points an item's permalinks and any twitter.com links in its
+// text at frontend, an alternative frontend such as a Nitter mirror, and
+// links mentions and hashtags to their pages there in its HTML.
+func withFrontend(frontend string, it item) item {
+	base := strings.TrimRight(frontend, "/") + "/"
+	it.URL = twitterLinkPattern.ReplaceAllLiteralString(it.URL, base)
+	it.Text = twitterLinkPattern.ReplaceAllLiteralString(it.Text, base)
+	// linked from the escaped text, so nothing in it is taken as markup
+	it.HTML = mentionPattern.ReplaceAllStringFunc(html.EscapeString(it.Text), func(match string) string {
+		parts := mentionPattern.FindStringSubmatch(match)
+		href := base + parts[3]
+		if parts[2] == "#" {
+			href = base + "search?q=" + url.QueryEscape("#"+parts[3])
+		}
+		return fmt.Sprintf(`%s<a href="%s">%s%s</a>`, parts[1], html.EscapeString(href), parts[2], parts[3])
+	})
+	if it.Quoted != nil {
+		quoted := withFrontend(frontend, *it.Quoted)
+		it.Quoted = &quoted
+	}
+	return it
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// Formats "twitterrss generate" can write.
+const (
+	formatRSS  = feedgen.FormatRSS
+	formatAtom = feedgen.FormatAtom
+	formatJSON = feedgen.FormatJSON
+)
+
+// staticFormat is how a feed is rendered and published as a file.
+type staticFormat struct {
+	extension   string
+	contentType string
+	render      func(feed *feedDocument) (string, error)
+}
+
+var staticFormats = map[string]staticFormat{
+	formatRSS: {".xml", "application/rss+xml", func(feed *feedDocument) (string, error) {
+		return feedgen.RenderRSS(feed, nil)
+	}},
+	formatAtom: {".atom", "application/atom+xml", feedgen.RenderAtom},
+	formatJSON: {".json", "application/feed+json", func(feed *feedDocument) (string, error) {
+		return feed.ToJSON()
+	}},
+}
+
+func lookupStaticFormat(format string) (staticFormat, error) {
+	if sf, ok := staticFormats[format]; ok {
+		return sf, nil
+	}
+	return staticFormat{}, fmt.Errorf("unknown format %q (rss, atom or json)", format)
+}
+
+// staticFeed fetches username's timeline once and builds its feed, with
+// links as if it were served from base. Media isn't proxied, as there is
+// no server to proxy it from.
+func staticFeed(ctx context.Context, cfg *config, f *fetcher, profiles *profileCache, username string, base string) (*feedDocument, error) {
+	feedCfg, ok := cfg.Feed(username)
+	if !ok {
+		feedCfg = feedConfig{Username: username}
+	}
+	r, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+feedPath(username), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid -base-url")
+	}
+
+	items, err := f.Refresh(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if items, err = feedItems(feedCfg, items, itemFilter{}); err != nil {
+		return nil, err
+	}
+	feed := buildFeed(feedCfg, r, items, time.Now(), nil)
+	if profiles != nil {
+		if p, ok := profiles.Get(username); ok {
+			applyProfile(feed, feedCfg, p)
+		}
+	}
+	feed.TTL = f.store.ttl
+	return feed, nil
+}
+
+// generateFeed renders username's feed once as format.
+func generateFeed(ctx context.Context, cfg *config, f *fetcher, profiles *profileCache, username string, format string, base string) (string, error) {
+	sf, err := lookupStaticFormat(format)
+	if err != nil {
+		return "", err
+	}
+	feed, err := staticFeed(ctx, cfg, f, profiles, username, base)
+	if err != nil {
+		return "", err
+	}
+	return sf.render(feed)
+}
+
+// writeGenerated writes a generated feed to path, or stdout for "-". The
+// file is replaced whole, so a web server publishing it never serves half
+// a feed.
+func writeGenerated(path string, feed string) error {
+	if path == "-" {
+		_, err := os.Stdout.WriteString(feed)
+		return err
+	}
+	if err := writeFileAtomic(path, []byte(feed)); err != nil {
+		return err
+	}
+	return errors.Wrap(os.Chmod(path, 0644), "unable to make feed readable")
+}
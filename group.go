@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/gorilla/mux"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// groupConfig is a virtual feed merging several accounts' timelines.
+type groupConfig struct {
+	Name      string   `json:"name"`
+	Title     string   `json:"title,omitempty"`
+	Usernames []string `json:"usernames"`
+	// DedupeRetweets shows a post once however many members retweeted it.
+	DedupeRetweets bool `json:"dedupe_retweets,omitempty"`
+}
+
+func (g groupConfig) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("group has no name")
+	}
+	if len(g.Usernames) == 0 {
+		return fmt.Errorf("group %s has no usernames", g.Name)
+	}
+	return nil
+}
+
+// GroupTitle is the configured title, falling back to the generic one.
+func (g groupConfig) GroupTitle() string {
+	if g.Title != "" {
+		return g.Title
+	}
+	return fmt.Sprintf("%s group", g.Name)
+}
+
+func groupPath(name string) string {
+	return fmt.Sprintf("/feed/group/%s.xml", name)
+}
+
+// Group returns the group called name.
+// groups returns a copy of the groups.
+func (c *config) groups() []groupConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]groupConfig(nil), c.Groups...)
+}
+
+func (c *config) Group(name string) (groupConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, group := range c.Groups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return groupConfig{}, false
+}
+
+// groupItems merges the members' timelines, newest first, along with the
+// member feed each item came from by id. Members that can't be fetched are
+// left out rather than failing the whole group.
+func groupItems(ctx context.Context, f *fetcher, group groupConfig) ([]item, map[string]string) {
+	var merged []item
+	members := map[string]string{}
+	for _, username := range group.Usernames {
+		items, err := f.cachedItems(ctx, username)
+		if err != nil {
+			log.Print(errors.Wrapf(err, "group %s: skipping %s", group.Name, username))
+			continue
+		}
+		for _, it := range items {
+			if it.Author.Username == "" {
+				it.Author.Username = username
+			}
+			merged = append(merged, it)
+			if _, ok := members[it.ID]; !ok {
+				members[it.ID] = username
+			}
+		}
+	}
+	feedgen.SortItems(merged)
+	if group.DedupeRetweets {
+		// keep the newest appearance of each post
+		seen := map[string]bool{}
+		kept := merged[:0]
+		for _, it := range merged {
+			if !seen[it.DedupeKey()] {
+				seen[it.DedupeKey()] = true
+				kept = append(kept, it)
+			}
+		}
+		merged = kept
+	}
+	if len(merged) > timelineSize {
+		merged = merged[:timelineSize]
+	}
+	return merged, members
+}
+
+// buildGroupFeed renders a group's items, with their media filed under the
+// member feeds they came from.
+func buildGroupFeed(group groupConfig, r *http.Request, items []item, members map[string]string, media mediaProxy) *feedDocument {
+	feed := &feeds.Feed{
+		Title:       group.GroupTitle(),
+		Link:        &feeds.Link{Href: r.URL.Path},
+		Description: fmt.Sprintf("tweets from %d accounts", len(group.Usernames)),
+		Author:      &feeds.Author{Name: "https://github.com/halkeye/twitterrss"},
+		Created:     time.Now().UTC(),
+	}
+	doc := newFeedDocument(feed)
+	for _, it := range items {
+		var enclosure *feeds.Enclosure
+		if media != nil {
+			enclosure = media.Enclosure(baseURL(r), members[it.ID], it)
+			it = media.Proxied(baseURL(r), members[it.ID], it)
+		}
+		feedItem := &feeds.Item{
+			Id:          it.ID,
+			Title:       fmt.Sprintf("@%s: %s", it.Author.Username, it.ID),
+			Link:        &feeds.Link{Href: it.URL},
+			Author:      &feeds.Author{Name: it.Author.Attribution()},
+			Description: fmt.Sprintf("%s: %s", it.Author.Attribution(), feedgen.ItemHTML(it)),
+			Created:     it.CreatedAt.UTC(),
+			Enclosure:   enclosure,
+		}
+		feed.Items = append(feed.Items, feedItem)
+		doc.Annotate(it)
+	}
+	return doc
+}
+
+// GroupHandler serves a group's merged feed.
+func GroupHandler(cfg *config, f *fetcher, media mediaProxy, clicks *clickCounter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group, ok := cfg.Group(mux.Vars(r)["group"])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		filter, err := queryFilter(r.URL.Query())
+		var items []item
+		var members map[string]string
+		if err == nil {
+			items, members = groupItems(r.Context(), f, group)
+			items, err = filter.Apply(items)
+		}
+		limit, limitErr := maxItems(r.URL.Query())
+		if err == nil {
+			err = limitErr
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		render := func(items []item) (string, error) {
+			feed := buildGroupFeed(group, r, items, members, media)
+			if clicks != nil {
+				clicks.Rewrite(baseURL(r), feed)
+			}
+			feed.TTL = f.store.ttl
+			return feedgen.RenderRSS(feed, nil)
+		}
+
+		setSurrogateKeys(w, groupSurrogateKey(group.Name))
+		setFeedCaching(w, f.store.ttl)
+		if err := writeFeed(w, r, limitItems(items, limit), render); err != nil {
+			panic(errors.Wrap(err, "unable to create rss feed"))
+		}
+	}
+}
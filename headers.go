@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route groups that response headers can be configured for. Headers under
+// "all" are sent on every response.
+const (
+	routeGroupAll   = "all"
+	routeGroupFeeds = "feeds"
+	routeGroupAPI   = "api"
+	routeGroupAdmin = "admin"
+	routeGroupPages = "pages"
+)
+
+// routeGroup classifies a request path.
+func routeGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/feed/"), path == "/opml.xml", path == "/search":
+		return routeGroupFeeds
+	case strings.HasPrefix(path, "/api/"):
+		return routeGroupAPI
+	case path == "/admin", strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/debug/"):
+		return routeGroupAdmin
+	}
+	return routeGroupPages
+}
+
+// ResponseHeaders adds the configured static headers to every response
+// before the route handler runs, so handlers can still override them.
+func ResponseHeaders(cfg *config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := cfg.ResponseHeaders()
+		for _, group := range []string{routeGroupAll, routeGroup(r.URL.Path)} {
+			for name, value := range headers[group] {
+				w.Header().Set(name, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setFeedCaching lets readers and caches reuse a feed response for maxAge,
+// the time left before the server would refetch it. Responses that needed
+// a feed token stay private.
+func setFeedCaching(w http.ResponseWriter, maxAge time.Duration) {
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	scope := "public"
+	if strings.Contains(w.Header().Get("Cache-Control"), "private") {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// readyCheck is the outcome of one readiness check.
+type readyCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// FetchedAt is when the bearer token in use was fetched.
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+}
+
+// endpointQuota is the rate limit Twitter last reported for an endpoint.
+type endpointQuota struct {
+	Endpoint  string    `json:"endpoint"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// feedReadiness is when a feed last refreshed successfully.
+type feedReadiness struct {
+	Username    string     `json:"username"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+type readiness struct {
+	Ready  bool            `json:"ready"`
+	Checks []readyCheck    `json:"checks"`
+	Quotas []endpointQuota `json:"quotas"`
+	Feeds  []feedReadiness `json:"feeds"`
+}
+
+// idSegment matches numeric path segments, such as the user id in v2
+// timeline paths, so quotas are reported per endpoint rather than per user.
+var idSegment = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// latestQuotas is the newest rate limit seen for each endpoint in log.
+func latestQuotas(log *outboundLog) []endpointQuota {
+	byEndpoint := map[string]endpointQuota{}
+	for _, entry := range log.Entries() {
+		remaining, err := strconv.Atoi(entry.RateLimits["x-rate-limit-remaining"])
+		if err != nil {
+			continue
+		}
+		quota := endpointQuota{
+			Endpoint:  entry.Host + idSegment.ReplaceAllString(entry.Path, "/:id$1"),
+			Remaining: remaining,
+			SeenAt:    entry.At,
+		}
+		quota.Limit, _ = strconv.Atoi(entry.RateLimits["x-rate-limit-limit"])
+		if reset, err := strconv.ParseInt(entry.RateLimits["x-rate-limit-reset"], 10, 64); err == nil {
+			quota.Reset = time.Unix(reset, 0).UTC()
+		}
+		// entries are oldest first, so later ones win
+		byEndpoint[quota.Endpoint] = quota
+	}
+
+	quotas := make([]endpointQuota, 0, len(byEndpoint))
+	for _, quota := range byEndpoint {
+		quotas = append(quotas, quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Endpoint < quotas[j].Endpoint })
+	return quotas
+}
+
+// LivezHandler reports that the process is up and serving requests.
+func LivezHandler(w http.ResponseWriter, r *http.Request) {
+	jsonBody, err := json.Marshal(map[string]string{"status": "ok"})
+	if err != nil {
+		panic(errors.Wrap(err, "Unable to create response"))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonBody)
+}
+
+// ReadyzHandler reports whether feeds can be fetched: the app's bearer
+// token can be obtained and no endpoint's rate limit is used up. It answers
+// 503 when not, with the details of every check, the last rate limit seen
+// per endpoint and when each feed last refreshed.
+func ReadyzHandler(status *feedStatus, sla *slaTracker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		ready := readiness{Ready: true, Checks: []readyCheck{}, Feeds: []feedReadiness{}}
+
+		for _, tokens := range appOnlyTransports() {
+			check := readyCheck{Name: "twitter_token", OK: true}
+			fetchedAt, err := tokens.Check(r.Context())
+			if err != nil {
+				check.OK, check.Error = false, err.Error()
+			} else {
+				check.FetchedAt = &fetchedAt
+			}
+			ready.Checks = append(ready.Checks, check)
+		}
+
+		ready.Quotas = latestQuotas(upstreamLog)
+		for _, quota := range ready.Quotas {
+			if quota.Remaining == 0 && quota.Reset.After(now) {
+				ready.Checks = append(ready.Checks, readyCheck{
+					Name:  "rate_limit",
+					Error: quota.Endpoint + " is rate limited until " + quota.Reset.Format(time.RFC3339),
+				})
+			}
+		}
+
+		for _, info := range status.List() {
+			feed := feedReadiness{Username: info.Username}
+			if at, ok := sla.LastSuccess(info.Username); ok {
+				feed.LastSuccess = &at
+			}
+			ready.Feeds = append(ready.Feeds, feed)
+		}
+
+		code := http.StatusOK
+		for _, check := range ready.Checks {
+			if !check.OK {
+				ready.Ready = false
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		jsonBody, err := json.Marshal(ready)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(jsonBody)
+	}
+}
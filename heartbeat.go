@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// heartbeatQuiet is how long a feed has to go without items before it gets
+// a heartbeat.
+const heartbeatQuiet = 30 * 24 * time.Hour
+
+// heartbeatItem returns the item announcing a quiet feed is still alive for
+// the current month, if it needs one. It is derived from the month alone so
+// readers see a single stable item per month rather than a new one on
+// every fetch.
+func heartbeatItem(feedURL string, username string, items []item, now time.Time) (item, bool) {
+	var newest time.Time
+	for _, it := range items {
+		if it.CreatedAt.After(newest) {
+			newest = it.CreatedAt
+		}
+	}
+	if now.Sub(newest) < heartbeatQuiet {
+		return item{}, false
+	}
+
+	now = now.UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	id := "heartbeat-" + month.Format("2006-01")
+	text := fmt.Sprintf("No new posts from %s this month. This feed is still being checked.", username)
+	if !newest.IsZero() {
+		text = fmt.Sprintf("No new posts from %s since %s. This feed is still being checked.", username, newest.UTC().Format("2006-01-02"))
+	}
+	return item{
+		ID:        id,
+		URL:       feedURL + "#" + id,
+		Text:      text,
+		CreatedAt: month,
+		Author:    itemAuthor{Username: username},
+	}, true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messages are the HTML views' UI strings by language. English is the
+// fallback for languages and keys a catalog doesn't have.
+var messages = map[string]map[string]string{
+	"en": {
+		"feeds":           "Feeds",
+		"not_fetched":     "not fetched yet",
+		"last_updated":    "last updated %s",
+		"pending_feeds":   "Pending feeds",
+		"feed":            "Feed",
+		"first_request":   "First requested",
+		"requests":        "Requests",
+		"approve":         "Approve",
+		"reject":          "Reject",
+		"no_pending":      "No feeds are waiting for approval.",
+		"admin_title":     "twitterrss admin",
+		"public_intro":    "This is a public twitterrss instance. It turns public accounts into RSS feeds you can add to any feed reader.",
+		"usage":           "Usage",
+		"public_caching":  "Feeds are cached for a while, so new posts can take some time to show up. Requests are rate limited per visitor and overall; please poll no more than every half hour.",
+		"source":          "Source",
+		"sla":             "%s available, p95 age %s over 24h",
+		"dead_letters":    "Failed notifications",
+		"notification":    "Notification",
+		"queued":          "Queued",
+		"attempts":        "Attempts",
+		"last_error":      "Last error",
+		"retry":           "Retry",
+		"discard":         "Discard",
+		"no_dead_letters": "No notifications have failed.",
+		"search":          "Search",
+		"no_results":      "No archived tweets match.",
+	},
+	"de": {
+		"feeds":           "Feeds",
+		"not_fetched":     "noch nicht abgerufen",
+		"last_updated":    "zuletzt aktualisiert %s",
+		"pending_feeds":   "Ausstehende Feeds",
+		"feed":            "Feed",
+		"first_request":   "Zuerst angefragt",
+		"requests":        "Anfragen",
+		"approve":         "Freigeben",
+		"reject":          "Ablehnen",
+		"no_pending":      "Keine Feeds warten auf Freigabe.",
+		"admin_title":     "twitterrss Verwaltung",
+		"public_intro":    "Dies ist eine öffentliche twitterrss-Instanz. Sie macht aus öffentlichen Konten RSS-Feeds, die du in jedem Feedreader abonnieren kannst.",
+		"usage":           "Verwendung",
+		"public_caching":  "Feeds werden eine Weile zwischengespeichert, neue Beiträge erscheinen daher mit Verzögerung. Anfragen sind pro Besucher und insgesamt begrenzt; bitte höchstens alle halbe Stunde abrufen.",
+		"source":          "Quellcode",
+		"sla":             "%s verfügbar, p95-Alter %s in 24 h",
+		"dead_letters":    "Fehlgeschlagene Benachrichtigungen",
+		"notification":    "Benachrichtigung",
+		"queued":          "Eingereiht",
+		"attempts":        "Versuche",
+		"last_error":      "Letzter Fehler",
+		"retry":           "Erneut versuchen",
+		"discard":         "Verwerfen",
+		"no_dead_letters": "Keine Benachrichtigungen sind fehlgeschlagen.",
+		"search":          "Suchen",
+		"no_results":      "Keine archivierten Tweets gefunden.",
+	},
+	"fr": {
+		"feeds":           "Flux",
+		"not_fetched":     "pas encore récupéré",
+		"last_updated":    "mis à jour le %s",
+		"pending_feeds":   "Flux en attente",
+		"feed":            "Flux",
+		"first_request":   "Première demande",
+		"requests":        "Demandes",
+		"approve":         "Approuver",
+		"reject":          "Refuser",
+		"no_pending":      "Aucun flux n'attend d'approbation.",
+		"admin_title":     "Administration twitterrss",
+		"public_intro":    "Ceci est une instance publique de twitterrss. Elle transforme des comptes publics en flux RSS à ajouter dans n'importe quel lecteur.",
+		"usage":           "Utilisation",
+		"public_caching":  "Les flux sont mis en cache un moment, les nouveaux messages peuvent donc tarder à apparaître. Les requêtes sont limitées par visiteur et au total ; merci de ne pas interroger plus d'une fois par demi-heure.",
+		"source":          "Code source",
+		"sla":             "%s disponible, âge p95 %s sur 24 h",
+		"dead_letters":    "Notifications en échec",
+		"notification":    "Notification",
+		"queued":          "En file depuis",
+		"attempts":        "Tentatives",
+		"last_error":      "Dernière erreur",
+		"retry":           "Réessayer",
+		"discard":         "Abandonner",
+		"no_dead_letters": "Aucune notification n'a échoué.",
+		"search":          "Rechercher",
+		"no_results":      "Aucun tweet archivé ne correspond.",
+	},
+	"es": {
+		"feeds":           "Feeds",
+		"not_fetched":     "aún no obtenido",
+		"last_updated":    "actualizado el %s",
+		"pending_feeds":   "Feeds pendientes",
+		"feed":            "Feed",
+		"first_request":   "Primera solicitud",
+		"requests":        "Solicitudes",
+		"approve":         "Aprobar",
+		"reject":          "Rechazar",
+		"no_pending":      "No hay feeds esperando aprobación.",
+		"admin_title":     "Administración de twitterrss",
+		"public_intro":    "Esta es una instancia pública de twitterrss. Convierte cuentas públicas en feeds RSS que puedes añadir a cualquier lector.",
+		"usage":           "Uso",
+		"public_caching":  "Los feeds se guardan en caché un tiempo, así que las publicaciones nuevas pueden tardar en aparecer. Las solicitudes están limitadas por visitante y en total; consulta como mucho cada media hora.",
+		"source":          "Código fuente",
+		"sla":             "%s disponible, antigüedad p95 %s en 24 h",
+		"dead_letters":    "Notificaciones fallidas",
+		"notification":    "Notificación",
+		"queued":          "En cola desde",
+		"attempts":        "Intentos",
+		"last_error":      "Último error",
+		"retry":           "Reintentar",
+		"discard":         "Descartar",
+		"no_dead_letters": "No ha fallado ninguna notificación.",
+		"search":          "Buscar",
+		"no_results":      "Ningún tweet archivado coincide.",
+	},
+}
+
+// dateLayouts format timestamps the way each language writes them.
+var dateLayouts = map[string]string{
+	"en": "Jan 2, 2006 15:04 MST",
+	"de": "02.01.2006 15:04 MST",
+	"fr": "02/01/2006 15:04 MST",
+	"es": "02/01/2006 15:04 MST",
+}
+
+// locale carries the negotiated language into templates.
+type locale struct {
+	Lang string
+}
+
+// T looks up a UI string, formatting any arguments into it.
+func (l locale) T(key string, args ...interface{}) string {
+	msg, ok := messages[l.Lang][key]
+	if !ok {
+		msg = messages["en"][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Date formats t for the locale.
+func (l locale) Date(t time.Time) string {
+	return t.Format(l.DateLayout())
+}
+
+// DateLayout is how the locale writes timestamps, English's unless it has
+// its own layout.
+func (l locale) DateLayout() string {
+	if layout, ok := dateLayouts[l.Lang]; ok {
+		return layout
+	}
+	return dateLayouts["en"]
+}
+
+// negotiateLocale picks the best supported language from Accept-Language.
+func negotiateLocale(r *http.Request) locale {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(lang, "-"); i >= 0 {
+			lang = lang[:i]
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value := strings.TrimPrefix(strings.TrimSpace(param), "q="); value != param {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if _, ok := messages[lang]; ok && q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	if len(choices) == 0 {
+		return locale{Lang: "en"}
+	}
+	return locale{Lang: choices[0].lang}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>twitterrss</title>
+{{- range .Feeds}}
+<link rel="alternate" type="application/rss+xml" title="{{.Username}} tweets" href="{{.Path}}">
+{{- end}}
+</head>
+<body>
+<h1>{{.Locale.T "feeds"}}</h1>
+<ul>
+{{- range .Feeds}}
+<li>
+{{- if .AvatarURL}}<img src="{{.AvatarURL}}" alt="" width="48" height="48"> {{end -}}
+<a href="{{.Path}}">{{.Username}}</a>
+{{- if .Name}} ({{.Name}}){{end}} &mdash;
+{{if .LastUpdated.IsZero}}{{$.Locale.T "not_fetched"}}{{else}}{{$.Locale.T "last_updated" ($.Locale.Date .LastUpdated)}}{{end -}}
+</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type indexEntry struct {
+	feedInfo
+	Path string
+}
+
+func IndexHandler(status *feedStatus) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entries []indexEntry
+		for _, info := range status.List() {
+			entries = append(entries, indexEntry{feedInfo: info, Path: feedPath(info.Username)})
+		}
+
+		var body bytes.Buffer
+		data := struct {
+			Locale locale
+			Feeds  []indexEntry
+		}{negotiateLocale(r), entries}
+		if err := indexTemplate.Execute(&body, data); err != nil {
+			panic(errors.Wrap(err, "unable to render index"))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Add("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+	}
+}
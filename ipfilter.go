@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipFilter restricts which clients may read feeds. A denied address is
+// always refused; when there is an allowlist, only addresses on it get in.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges, treating bare
+// addresses as a range of one.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func newIPFilter(allow string, deny string) (*ipFilter, error) {
+	allowed, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ipFilter{allow: allowed, deny: denied}, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the client at addr may read feeds.
+func (f *ipFilter) Allowed(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// Restrict refuses feed requests from clients the filter doesn't allow.
+// The client address is the one trustedProxies resolved, so
+// X-Forwarded-For only counts when a trusted proxy set it.
+func (f *ipFilter) Restrict(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeGroup(r.URL.Path) == routeGroupFeeds && !f.Allowed(clientIP(r)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,25 @@
+package main
+
+import "github.com/halkeye/twitterrss/pkg/feedgen"
+
+// The items sources return, and the feeds rendered from them, are modelled
+// in pkg/feedgen so other programs can embed them without the server.
+type (
+	item        = feedgen.Item
+	itemAuthor  = feedgen.Author
+	itemMedia   = feedgen.Media
+	itemMetrics = feedgen.Metrics
+	itemGeo     = feedgen.Geo
+	itemPoll    = feedgen.Poll
+	pollOption  = feedgen.PollOption
+
+	feedDocument = feedgen.Document
+	atomLink     = feedgen.AtomLink
+
+	timelineSource = feedgen.Source
+	fetchOptions   = feedgen.FetchOptions
+	profile        = feedgen.Profile
+	profileSource  = feedgen.ProfileSource
+	lookupSource   = feedgen.LookupSource
+	accountError   = feedgen.AccountError
+)
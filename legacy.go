@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var legacyRouteRequests = newCounterVec("twitterrss_legacy_route_requests_total",
+	"Requests for deprecated URLs, by the legacy route they matched.", "route")
+
+// legacyRoute keeps an old URL scheme working after the routes change.
+// Until Sunset requests for From are permanently redirected to To; after it
+// they get 410 Gone, still pointing at To. Both take {name} placeholders,
+// e.g. "/rss/{username}" to "/feed/{username}.xml".
+type legacyRoute struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Sunset time.Time `json:"sunset,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var routePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// compile turns From into the pattern requests are matched against.
+func (l *legacyRoute) compile() error {
+	if !strings.HasPrefix(l.From, "/") || l.To == "" {
+		return fmt.Errorf("legacy route %q needs a path to redirect from and a url to redirect to", l.From)
+	}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range routePlaceholder.FindAllStringSubmatchIndex(l.From, -1) {
+		pattern.WriteString(regexp.QuoteMeta(l.From[last:loc[0]]))
+		pattern.WriteString("(?P<" + l.From[loc[2]:loc[3]] + ">[^/]+)")
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(l.From[last:]) + "$")
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return errors.Wrapf(err, "legacy route %q", l.From)
+	}
+	for _, name := range routePlaceholder.FindAllStringSubmatch(l.To, -1) {
+		if compiled.SubexpIndex(name[1]) < 0 {
+			return fmt.Errorf("legacy route %q redirects to unknown placeholder %s", l.From, name[0])
+		}
+	}
+	l.pattern = compiled
+	return nil
+}
+
+// target is where a request for path should go instead, if the route
+// matches it.
+func (l legacyRoute) target(path string) (string, bool) {
+	if l.pattern == nil {
+		return "", false
+	}
+	match := l.pattern.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	return routePlaceholder.ReplaceAllStringFunc(l.To, func(placeholder string) string {
+		return match[l.pattern.SubexpIndex(placeholder[1:len(placeholder)-1])]
+	}), true
+}
+
+// legacyClient is a client that still requests a legacy route.
+type legacyClient struct {
+	Route     string    `json:"route"`
+	UserAgent string    `json:"user_agent"`
+	Remote    string    `json:"remote"`
+	Hits      int       `json:"hits"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// legacyMaxAgents is how many user agents are told apart on each legacy
+// route. Clients choose their user agent, so later ones share one entry
+// rather than growing the list without bound.
+const legacyMaxAgents = 50
+
+// legacyOtherAgent is the entry user agents past legacyMaxAgents share.
+const legacyOtherAgent = "(other)"
+
+// legacyHits tracks which clients still use legacy routes, so operators
+// know who to chase before a route's sunset.
+type legacyHits struct {
+	mu      sync.Mutex
+	clients map[string]*legacyClient
+	// agents counts the user agents told apart on each route
+	agents map[string]int
+}
+
+func newLegacyHits() *legacyHits {
+	return &legacyHits{clients: map[string]*legacyClient{}, agents: map[string]int{}}
+}
+
+func (h *legacyHits) record(route string, r *http.Request) {
+	legacyRouteRequests.Inc(route)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	agent := r.UserAgent()
+	key := route + "\x00" + agent
+	client, ok := h.clients[key]
+	if !ok && h.agents[route] >= legacyMaxAgents {
+		agent = legacyOtherAgent
+		key = route + "\x00" + agent
+		client, ok = h.clients[key]
+	}
+	if !ok {
+		client = &legacyClient{Route: route, UserAgent: agent}
+		h.clients[key] = client
+		if agent != legacyOtherAgent {
+			h.agents[route]++
+		}
+	}
+	client.Hits++
+	client.Remote = clientIP(r)
+	client.LastSeen = time.Now()
+}
+
+// Clients lists the clients seen on legacy routes, busiest first.
+func (h *legacyHits) Clients() []legacyClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients := make([]legacyClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Hits != clients[j].Hits {
+			return clients[i].Hits > clients[j].Hits
+		}
+		return clients[i].LastSeen.After(clients[j].LastSeen)
+	})
+	return clients
+}
+
+// LegacyRoutes answers requests for the configured legacy routes, passing
+// everything else through to next.
+func LegacyRoutes(cfg *config, hits *legacyHits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range cfg.legacyRoutes() {
+			target, ok := route.target(r.URL.Path)
+			if !ok {
+				continue
+			}
+			hits.record(route.From, r)
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, target))
+			if !route.Sunset.IsZero() {
+				w.Header().Set("Sunset", route.Sunset.UTC().Format(http.TimeFormat))
+				if time.Now().After(route.Sunset) {
+					http.Error(w, fmt.Sprintf("this URL was retired, use %s", target), http.StatusGone)
+					return
+				}
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LegacyClientsHandler reports the clients still requesting legacy routes.
+func LegacyClientsHandler(hits *legacyHits) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(hits.Clients())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+)
+
+// Store files written before feeds held items from any network kept the
+// go-twitter tweets themselves, and redactions by numeric tweet id. Load
+// converts them, so upgrading keeps the cache and archive.
+
+type legacyFeedEntry struct {
+	Tweets    []twitter.Tweet `json:"tweets"`
+	FetchedAt time.Time       `json:"fetched_at"`
+}
+
+type legacyRedaction struct {
+	Feed   string    `json:"feed"`
+	ID     int64     `json:"id"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type legacyStoreSnapshot struct {
+	Feeds      map[string]*legacyFeedEntry `json:"feeds"`
+	Archive    map[string][]twitter.Tweet  `json:"archive"`
+	Redactions []legacyRedaction           `json:"redactions,omitempty"`
+}
+
+// isLegacySnapshot reports whether data is a store file of tweets: cached
+// timelines under "tweets", archived tweets with an id_str, or redactions
+// with numeric ids.
+func isLegacySnapshot(data []byte) bool {
+	var probe struct {
+		Feeds      map[string]map[string]json.RawMessage   `json:"feeds"`
+		Archive    map[string][]map[string]json.RawMessage `json:"archive"`
+		Redactions []struct {
+			ID json.RawMessage `json:"id"`
+		} `json:"redactions"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	for _, entry := range probe.Feeds {
+		if _, ok := entry["tweets"]; ok {
+			return true
+		}
+	}
+	for _, records := range probe.Archive {
+		for _, record := range records {
+			if _, ok := record["id_str"]; ok {
+				return true
+			}
+		}
+	}
+	for _, red := range probe.Redactions {
+		if len(red.ID) > 0 && red.ID[0] != '"' {
+			return true
+		}
+	}
+	return false
+}
+
+// migrate converts the tweets to items, as the v1.1 backend would have
+// fetched them.
+func (l *legacyStoreSnapshot) migrate() *storeSnapshot {
+	snapshot := &storeSnapshot{
+		Feeds:   map[string]*feedEntry{},
+		Archive: map[string][]item{},
+	}
+	for username, entry := range l.Feeds {
+		if entry == nil {
+			continue
+		}
+		snapshot.Feeds[username] = &feedEntry{Items: itemsFromLegacy(username, entry.Tweets), FetchedAt: entry.FetchedAt}
+	}
+	for username, tweets := range l.Archive {
+		snapshot.Archive[username] = itemsFromLegacy(username, tweets)
+	}
+	for _, red := range l.Redactions {
+		snapshot.Redactions = append(snapshot.Redactions, redaction{
+			Feed:   red.Feed,
+			ID:     strconv.FormatInt(red.ID, 10),
+			Reason: red.Reason,
+			At:     red.At,
+		})
+	}
+	return snapshot
+}
+
+func itemsFromLegacy(username string, tweets []twitter.Tweet) []item {
+	items := make([]item, 0, len(tweets))
+	for _, tweet := range tweets {
+		items = append(items, feedgen.ItemFromV1(username, tweet))
+	}
+	return items
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// systemdListenFDsStart is the first file descriptor systemd passes to a
+// socket activated service.
+const systemdListenFDsStart = 3
+
+// inheritedListeners are sockets handed over by systemd socket activation,
+// in the order the unit's ListenStream lines declare them. systemd keeps
+// the sockets open across restarts, so connections wait in the backlog
+// instead of being refused while the binary is replaced.
+func inheritedListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	// the variables are only meant for this process, not anything it starts
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []net.Listener
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to use inherited socket %d", fd)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenerFor takes the index-th inherited listener when there is one, and
+// otherwise listens on addr, sharing the port with other processes when
+// reusePort is set.
+func listenerFor(inherited []net.Listener, index int, addr string, reusePort bool) (net.Listener, error) {
+	if index < len(inherited) {
+		log.Printf("Using inherited socket %s for %s\n", inherited[index].Addr(), addr)
+		return inherited[index], nil
+	}
+	config := net.ListenConfig{}
+	if reusePort {
+		config.Control = reusePortControl
+	}
+	return config.Listen(context.Background(), "tcp", addr)
+}
+
+// drainOnTerm stops server accepting connections on SIGTERM or SIGINT and
+// waits up to timeout for in-flight requests, so a new process already
+// listening on the port (or the systemd socket) takes over without
+// dropping readers mid-poll.
+func drainOnTerm(server *http.Server, timeout time.Duration) {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	<-term
+	signal.Stop(term)
+
+	log.Printf("Draining connections for up to %s\n", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print(errors.Wrap(err, "drain timed out"))
+		server.Close()
+	}
+}
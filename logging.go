@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Log levels, most verbose first. Errors are always logged; info adds the
+// request log; debug adds a trace of every fetch.
+const (
+	logDebug = "debug"
+	logInfo  = "info"
+	logError = "error"
+)
+
+var logLevels = map[string]int{logDebug: 0, logInfo: 1, logError: 2}
+
+// feedSampling turns debug logging on for one feed until it expires,
+// logging Rate of its debug lines.
+type feedSampling struct {
+	Until time.Time `json:"until"`
+	Rate  float64   `json:"rate"`
+}
+
+// logControl is the logging configuration, changeable at runtime from
+// /admin/logging.
+type logControl struct {
+	mu    sync.Mutex
+	level string
+	feeds map[string]feedSampling
+}
+
+var logging = &logControl{level: logInfo, feeds: map[string]feedSampling{}}
+
+// SetLevel changes the log level.
+func (c *logControl) SetLevel(level string) error {
+	if _, ok := logLevels[level]; !ok {
+		return fmt.Errorf("unknown log level %q, expected debug, info or error", level)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+	return nil
+}
+
+// Sample logs debug lines for feed for the next duration, whatever the
+// level. Feeds are matched however they're spelled.
+func (c *logControl) Sample(feed string, duration time.Duration, rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.feeds[normalizeFeedKey(feed)] = feedSampling{Until: time.Now().Add(duration), Rate: rate}
+}
+
+func (c *logControl) enabled(level string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return logLevels[level] >= logLevels[c.level]
+}
+
+// debugging reports whether a debug line about feed should be logged.
+func (c *logControl) debugging(feed string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.level == logDebug {
+		return true
+	}
+	key := normalizeFeedKey(feed)
+	sampling, ok := c.feeds[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(sampling.Until) {
+		delete(c.feeds, key)
+		return false
+	}
+	return rand.Float64() < sampling.Rate
+}
+
+// state is the current level and the feeds being sampled.
+func (c *logControl) state() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	feeds := map[string]feedSampling{}
+	for feed, sampling := range c.feeds {
+		if time.Now().Before(sampling.Until) {
+			feeds[feed] = sampling
+		}
+	}
+	return map[string]interface{}{"level": c.level, "feeds": feeds}
+}
+
+// debugf logs a debug line about feed when debugging is on for it.
+func debugf(feed string, format string, args ...interface{}) {
+	if logging.debugging(feed) {
+		log.Printf("debug: %s: %s", feed, fmt.Sprintf(format, args...))
+	}
+}
+
+// levelWriter drops writes below level, for the request log.
+type levelWriter struct {
+	level string
+	next  io.Writer
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	if !logging.enabled(w.level) {
+		return len(p), nil
+	}
+	return w.next.Write(p)
+}
+
+// loggingRequest changes the log configuration. Level is optional; Feed
+// starts debug sampling for that feed for Duration (default 15m) at Rate
+// (default every line).
+type loggingRequest struct {
+	Level    string  `json:"level"`
+	Feed     string  `json:"feed"`
+	Duration string  `json:"duration"`
+	Rate     float64 `json:"rate"`
+}
+
+// LogLevelHandler shows the log configuration on GET and changes it on
+// POST.
+func LogLevelHandler(audit *auditLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			req := loggingRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			if req.Level != "" {
+				if err := logging.SetLevel(req.Level); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if req.Feed != "" {
+				duration := 15 * time.Minute
+				if req.Duration != "" {
+					var err error
+					if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+						http.Error(w, "duration must be a positive duration such as 15m", http.StatusBadRequest)
+						return
+					}
+				}
+				if req.Rate < 0 || req.Rate > 1 {
+					http.Error(w, "rate must be between 0 and 1", http.StatusBadRequest)
+					return
+				}
+				if req.Rate == 0 {
+					req.Rate = 1
+				}
+				req.Feed = normalizeFeedKey(req.Feed)
+				logging.Sample(req.Feed, duration, req.Rate)
+			}
+			details := map[string]string{"level": req.Level, "feed": req.Feed, "duration": req.Duration}
+			if err := audit.Record(r, "logging", details); err != nil {
+				log.Print(errors.Wrap(err, "unable to audit logging change"))
+				http.Error(w, "logging was changed but the change couldn't be audited", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		jsonBody, err := json.Marshal(logging.state())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -1,23 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/coreos/pkg/flagutil"
-	"github.com/dghubble/go-twitter/twitter"
-	"github.com/gorilla/feeds"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
 	"github.com/pkg/errors"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/clientcredentials"
 )
 
 type arrayFlags []string
@@ -32,25 +33,339 @@ func (i *arrayFlags) Set(value string) error {
 }
 
 type flagStruct struct {
-	consumerKey    string
-	consumerSecret string
-	port           int
-	usernames      arrayFlags
+	consumerKey       string
+	consumerSecret    string
+	twitterAPIVersion string
+	twitterFixtures   string
+	accessToken       string
+	accessSecret      string
+	credentials       arrayFlags
+	vaultAddr         string
+	vaultRole         string
+	vaultAuthMount    string
+	vaultPath         string
+	egress            egressSettings
+	sources           string
+	nitterInstance    string
+	port              int
+	usernames         arrayFlags
+	feedTokens        arrayFlags
+	configPath        string
+	opmlPath          string
+	cacheTTL          time.Duration
+	maxStale          time.Duration
+	adminToken        string
+
+	replicateFrom     string
+	replicateToken    string
+	replicateInterval time.Duration
+
+	storePath     string
+	storeInterval time.Duration
+	notifyRetry   time.Duration
+	s3Bucket      string
+	s3Region      string
+	s3Endpoint    string
+	backupKey     string
+	restoreOnBoot bool
+
+	pollInterval     time.Duration
+	warmCache        bool
+	baseURL          string
+	websubHub        string
+	auditLogPath     string
+	telegramBotToken string
+	mediaDir         string
+	mediaRevalidate  time.Duration
+	mediaHosts       string
+
+	savedSearchInterval time.Duration
+
+	trendLocations string
+	trendsInterval time.Duration
+
+	deletedCheckInterval time.Duration
+	deletedCheckWindow   time.Duration
+
+	oauth2ClientID     string
+	oauth2ClientSecret string
+	oauth2TokenFile    string
+
+	allowAnyUsername bool
+	denyUsernames    string
+	ipNewFeeds       int
+	requireApproval  bool
+	approvalsPath    string
+
+	redirectLinks bool
+	clicksPath    string
+
+	public             bool
+	publicIPRequests   int
+	publicRequests     int
+	publicIPNewFeeds   int
+	publicMaxFeeds     int
+	publicFailureCache time.Duration
+
+	tombstones bool
+
+	logLevel string
+
+	server serverSettings
+
+	feedSlugs  string
+	slugSecret string
+
+	ipRate  float64
+	ipBurst int
+
+	allowIPs string
+	denyIPs  string
+
+	trustedProxies string
+
+	profileTTL time.Duration
+
+	cdn              string
+	cdnPurgeEndpoint string
+	cdnPurgeToken    string
+
+	upstreamLogSize     int
+	upstreamSampleRate  float64
+	upstreamSampleBytes int
+
+	smtpAddr     string
+	smtpUsername string
+	smtpPassword string
+	smtpFrom     string
+
+	signingKey string
+
+	debugEndpoints bool
+
+	mockItems    int
+	mockInterval time.Duration
+
+	generateUsername string
+	generateFormat   string
+	generateOutput   string
+
+	publishTo       string
+	publishInterval time.Duration
+	publishFormats  string
 }
 
 func main() {
 	flags := flagStruct{}
 
-	flag.Var(&flags.usernames, "usernames", "Allowed Usernames")
-	flag.StringVar(&flags.consumerKey, "consumer-key", "", "Twitter Consumer Key")
-	flag.StringVar(&flags.consumerSecret, "consumer-secret", "", "Twitter Consumer Secret")
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:], os.Stdout))
+	}
+
+	// "twitterrss mockserver" serves synthetic feeds instead of real ones
+	mockServer := len(os.Args) > 1 && os.Args[1] == "mockserver"
+	if mockServer {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	// "twitterrss generate" writes one feed and exits, for cron jobs
+	// publishing static files
+	generate := len(os.Args) > 1 && os.Args[1] == "generate"
+	if generate {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	// "twitterrss publish" uploads every feed on a schedule, without
+	// serving anything itself
+	publish := len(os.Args) > 1 && os.Args[1] == "publish"
+	if publish {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	flag.Var(&flags.usernames, "usernames", "Allowed Usernames (mastodon/{instance}/{user} for Mastodon, bsky/{handle} for Bluesky accounts)")
+	flag.Var(&flags.feedTokens, "feed-token", "Token required to read any feed, as the basic auth password or ?token= (repeatable)")
+	secretStringVar(&flags.consumerKey, "consumer-key", "Twitter Consumer Key")
+	secretStringVar(&flags.consumerSecret, "consumer-secret", "Twitter Consumer Secret")
+	flag.Var(&flags.credentials, "twitter-credentials", "Extra Twitter app credentials, as KEY:SECRET or bearer:TOKEN, rotated between as each nears its rate limit (repeatable)")
+	flag.StringVar(&flags.vaultAddr, "vault-addr", os.Getenv("VAULT_ADDR"), "Vault server Twitter credentials are read from, with -vault-path")
+	flag.StringVar(&flags.vaultRole, "vault-role", "", "Vault Kubernetes auth role to log in as (VAULT_TOKEN is used without one)")
+	flag.StringVar(&flags.vaultAuthMount, "vault-auth-mount", "kubernetes", "Path the Vault Kubernetes auth method is mounted at")
+	flag.StringVar(&flags.vaultPath, "vault-path", "", "Vault secret holding consumer_key and consumer_secret (and optionally access_token and access_secret), e.g. secret/data/twitterrss")
+	flag.StringVar(&flags.egress.proxy, "twitter-proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL for Twitter API calls (HTTP_PROXY and HTTPS_PROXY are honoured without it)")
+	flag.StringVar(&flags.egress.caBundle, "twitter-ca-bundle", "", "PEM file of extra CAs to trust for Twitter API calls, e.g. an intercepting proxy's")
+	flag.StringVar(&flags.egress.minTLSVersion, "twitter-tls-min-version", "1.2", "Lowest TLS version Twitter API calls accept (1.2 or 1.3)")
+	flag.DurationVar(&flags.egress.timeout, "twitter-timeout", 30*time.Second, "How long a Twitter API call may take, reading the response included (0 for no limit)")
+	flag.DurationVar(&flags.egress.connectTimeout, "twitter-connect-timeout", 10*time.Second, "How long connecting to the Twitter API may take")
+	flag.StringVar(&flags.egress.userAgent, "twitter-user-agent", "", "User-Agent sent to the Twitter API (twitterrss/<version> by default)")
+	flag.IntVar(&flags.egress.retries, "twitter-retries", 2, "How many times a Twitter API call failing with a 5xx or network error is retried")
+	flag.IntVar(&flags.egress.breakerFailures, "twitter-breaker-failures", 5, "Consecutive failed Twitter API calls that open the circuit breaker, serving stale feeds until it closes (0 disables)")
+	flag.DurationVar(&flags.egress.breakerCooldown, "twitter-breaker-cooldown", 30*time.Second, "How long the circuit breaker stays open before trying the Twitter API again")
+	flag.StringVar(&flags.twitterAPIVersion, "twitter-api-version", twitterAPIv1, "Twitter API version to read timelines from (1.1 or 2)")
+	flag.StringVar(&flags.twitterFixtures, "twitter-fixtures", "", "Answer Twitter API calls from this JSON file of recorded v1.1 responses instead")
+	secretStringVar(&flags.accessToken, "access-token", "Twitter user access token; makes every Twitter call in that user's context, reaching protected accounts they follow")
+	secretStringVar(&flags.accessSecret, "access-secret", "Twitter user access token secret")
+	flag.StringVar(&flags.oauth2ClientID, "twitter-oauth2-client-id", "", "OAuth 2.0 client ID of the Twitter app, for API v2 user context calls such as bookmarks")
+	secretStringVar(&flags.oauth2ClientSecret, "twitter-oauth2-client-secret", "OAuth 2.0 client secret, for confidential Twitter apps")
+	flag.StringVar(&flags.oauth2TokenFile, "twitter-oauth2-token-file", "", "JSON OAuth 2.0 user token (with refresh_token) serving /feed/bookmarks.xml; rewritten as the token is refreshed")
+	flag.DurationVar(&flags.savedSearchInterval, "saved-search-interval", 0, "Sync search feeds from the account's saved searches this often (needs -access-token; 0 disables)")
+	flag.StringVar(&flags.trendLocations, "trend-locations", "", "Comma separated WOEIDs (1 is worldwide) whose trending topics are served as /feed/trends/{woeid}.xml")
+	flag.DurationVar(&flags.trendsInterval, "trends-interval", 15*time.Minute, "How often trends feeds are refreshed")
+	flag.StringVar(&flags.sources, "sources", sourceTwitter, "Comma separated timeline sources to try in order (twitter, nitter)")
+	flag.StringVar(&flags.nitterInstance, "nitter-instance", "https://nitter.net", "Nitter instance used by the nitter source")
+	flag.DurationVar(&flags.profileTTL, "profile-ttl", 24*time.Hour, "How long account profiles used for feed titles and images are cached (0 disables profile lookups)")
+	flag.StringVar(&flags.cdn, "cdn", "", "Purge feeds from this CDN (cloudflare or fastly) when they gain new items")
+	flag.StringVar(&flags.cdnPurgeEndpoint, "cdn-purge-endpoint", "", "CDN purge API: the Cloudflare zone purge_cache URL or https://api.fastly.com/service/{id}/purge")
+	secretStringVar(&flags.cdnPurgeToken, "cdn-purge-token", "API token for the CDN purge API")
+	flag.IntVar(&flags.upstreamLogSize, "upstream-log-size", 500, "How many upstream API calls /admin/upstream keeps")
+	flag.Float64Var(&flags.upstreamSampleRate, "upstream-sample-rate", 0, "Fraction of upstream API calls whose response payload is captured (0 to 1)")
+	flag.IntVar(&flags.upstreamSampleBytes, "upstream-sample-bytes", 4096, "Most bytes of each sampled upstream payload to capture")
 	flag.IntVar(&flags.port, "port", 8000, "port")
+	flag.StringVar(&flags.configPath, "config", "", "Path to a JSON feed config file")
+	flag.StringVar(&flags.opmlPath, "opml", "", "Path to an OPML file of twitter.com feeds to serve")
+	flag.DurationVar(&flags.cacheTTL, "cache-ttl", 5*time.Minute, "How long a fetched timeline is served before refetching")
+	flag.DurationVar(&flags.maxStale, "max-stale", 0, "How long past -cache-ttl an expired timeline is still served at once while it's refreshed in the background (0 refreshes before serving)")
+	secretStringVar(&flags.adminToken, "admin-token", "Bearer token for admin routes, unless admin_auth is configured (admin routes are disabled when neither is)")
+	flag.StringVar(&flags.replicateFrom, "replicate-from", "", "Run as a warm standby replicating the store from this primary URL")
+	secretStringVar(&flags.replicateToken, "replicate-token", "Admin token of the primary being replicated")
+	flag.DurationVar(&flags.replicateInterval, "replicate-interval", 30*time.Second, "How often a standby pulls from the primary")
+	flag.StringVar(&flags.storePath, "store-path", "", "Persist the store to this file (in-memory only when empty)")
+	flag.DurationVar(&flags.storeInterval, "store-interval", time.Minute, "How often the store is checkpointed to disk")
+	flag.DurationVar(&flags.notifyRetry, "notify-retry-for", 24*time.Hour, "How long failed webhook, Telegram and email deliveries are retried before they're dead-lettered")
+	flag.StringVar(&flags.s3Bucket, "s3-bucket", "", "S3 bucket for store backups")
+	flag.StringVar(&flags.s3Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&flags.s3Endpoint, "s3-endpoint", "", "S3 endpoint URL for S3-compatible stores")
+	flag.StringVar(&flags.backupKey, "backup-key", "twitterrss/store.json", "S3 object key that store checkpoints are uploaded to")
+	flag.BoolVar(&flags.restoreOnBoot, "restore-on-boot", false, "Download the store from S3 when the local store file is missing")
+	flag.DurationVar(&flags.pollInterval, "poll-interval", 0, "Refresh every feed in the background on this interval (disabled when 0)")
+	flag.BoolVar(&flags.warmCache, "warm-cache", false, "Fetch every feed at startup, then refresh each in turn before its cache expires (staggered over -poll-interval, if set)")
+	flag.DurationVar(&flags.deletedCheckInterval, "deleted-check-interval", 0, "In polling mode, re-check recently archived tweets this often and mark the deleted ones (0 disables)")
+	flag.DurationVar(&flags.deletedCheckWindow, "deleted-check-window", 7*24*time.Hour, "How long after they were posted tweets are re-checked for deletion")
+	flag.StringVar(&flags.baseURL, "base-url", "", "Public URL of this instance, used where there is no request to derive it from")
+	flag.StringVar(&flags.websubHub, "websub-hub", "", "WebSub hub to notify when feeds change (requires -base-url)")
+	flag.StringVar(&flags.auditLogPath, "audit-log", "", "Append admin actions to this JSON lines file")
+	secretStringVar(&flags.telegramBotToken, "telegram-bot-token", "Telegram bot token for posting tweets to the chats configured on feeds")
+	flag.StringVar(&flags.smtpAddr, "smtp-addr", "", "SMTP server (host:port) used to send email digests")
+	flag.StringVar(&flags.smtpUsername, "smtp-username", "", "SMTP username")
+	secretStringVar(&flags.smtpPassword, "smtp-password", "SMTP password")
+	flag.StringVar(&flags.smtpFrom, "smtp-from", "", "From address of email digests")
+	flag.StringVar(&flags.mediaDir, "media-dir", "", "Proxy tweet media through this instance, caching files in this directory")
+	flag.DurationVar(&flags.mediaRevalidate, "media-revalidate", 24*time.Hour, "How often proxied media is rechecked upstream for changes")
+	flag.StringVar(&flags.mediaHosts, "media-hosts", "", "Comma separated hosts, with their subdomains, media is proxied from besides Twitter's and Bluesky's CDNs, e.g. Mastodon instances' media hosts")
+	flag.BoolVar(&flags.allowAnyUsername, "allow-any-username", false, "Serve feeds for usernames that aren't configured, on demand")
+	flag.StringVar(&flags.denyUsernames, "deny-usernames", "", "Comma separated usernames never served, even with -allow-any-username or -public")
+	flag.IntVar(&flags.ipNewFeeds, "ip-new-feeds", 0, "New feeds one IP can add per day with -allow-any-username (0 is unlimited; -public uses -public-ip-new-feeds)")
+	flag.BoolVar(&flags.requireApproval, "require-approval", false, "Queue feeds requested with -allow-any-username until an admin approves them")
+	flag.StringVar(&flags.approvalsPath, "approvals-path", "", "File to keep the approval queue and decisions in")
+	flag.BoolVar(&flags.redirectLinks, "redirect-links", false, "Route item links through /r/{id} to count clicks on each item")
+	flag.StringVar(&flags.clicksPath, "clicks-path", "", "File to keep item click counts in, saved every -store-interval")
+	flag.BoolVar(&flags.public, "public", false, "Run as a public instance: any username, rate limits, long cache TTLs and a landing page")
+	flag.IntVar(&flags.publicIPRequests, "public-ip-requests", 30, "Feed requests per minute allowed from one IP in public mode")
+	flag.IntVar(&flags.publicRequests, "public-requests", 600, "Feed requests per minute allowed in total in public mode")
+	flag.IntVar(&flags.publicIPNewFeeds, "public-ip-new-feeds", 10, "New feeds one IP can add per day in public mode")
+	flag.IntVar(&flags.publicMaxFeeds, "public-max-feeds", 1000, "Most feeds a public instance serves")
+	flag.DurationVar(&flags.publicFailureCache, "public-failure-cache", time.Hour, "How long an account that failed to fetch is refused in public mode")
+	flag.BoolVar(&flags.tombstones, "tombstones", false, "Serve feeds of accounts that don't exist, are suspended or are protected as a single item explaining why, instead of a 404 or 403")
+	flag.StringVar(&flags.feedSlugs, "feed-slugs", "", "Serve feeds under opaque slugs instead of usernames (hmac), hiding which accounts are followed")
+	secretStringVar(&flags.slugSecret, "slug-secret", "Secret the hmac feed slugs are derived from; changing it changes every feed URL")
+	flag.DurationVar(&flags.server.readHeaderTimeout, "read-header-timeout", 10*time.Second, "How long a client may take to send request headers")
+	flag.DurationVar(&flags.server.readTimeout, "read-timeout", 30*time.Second, "How long a client may take to send a whole request")
+	flag.DurationVar(&flags.server.writeTimeout, "write-timeout", 2*time.Minute, "How long a response may take to write; event streams end and reconnect before it")
+	flag.DurationVar(&flags.server.idleTimeout, "idle-timeout", 2*time.Minute, "How long an idle keep-alive connection is kept open")
+	flag.IntVar(&flags.server.maxHeaderBytes, "max-header-bytes", 64<<10, "Largest request headers accepted")
+	flag.IntVar(&flags.server.maxConcurrent, "max-concurrent-requests", 0, "Most requests handled at once, refusing the rest with 503 (0 is unlimited)")
+	flag.BoolVar(&flags.server.reusePort, "reuse-port", false, "Listen with SO_REUSEPORT so a new binary can start on the port while this one drains")
+	flag.DurationVar(&flags.server.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests get to finish after SIGTERM")
+	flag.StringVar(&flags.server.tls.certFile, "tls-cert", "", "Serve HTTPS with this certificate file (needs -tls-key)")
+	flag.StringVar(&flags.server.tls.keyFile, "tls-key", "", "Private key file for -tls-cert")
+	flag.StringVar(&flags.server.tls.autocertDomains, "autocert-domain", "", "Comma separated domains to serve HTTPS for with Let's Encrypt certificates")
+	flag.StringVar(&flags.server.tls.autocertCache, "autocert-cache", "autocert", "Directory Let's Encrypt certificates are cached in")
+	flag.StringVar(&flags.server.tls.autocertEmail, "autocert-email", "", "Contact email for the Let's Encrypt account")
+	flag.IntVar(&flags.server.tls.challengePort, "autocert-http-port", 80, "Port serving ACME challenges and redirecting to HTTPS with -autocert-domain")
+	flag.StringVar(&flags.logLevel, "log-level", logInfo, "Log level: debug, info (adds the request log) or error; can be changed at /admin/logging")
+	flag.Float64Var(&flags.ipRate, "ip-rate", 0, "Feed requests per second each client IP may make on average (0 disables)")
+	flag.IntVar(&flags.ipBurst, "ip-burst", 20, "Feed requests a client IP may burst above -ip-rate")
+	flag.StringVar(&flags.allowIPs, "allow-ips", "", "Comma separated IPs or CIDR ranges allowed to read feeds (default everyone)")
+	flag.StringVar(&flags.denyIPs, "deny-ips", "", "Comma separated IPs or CIDR ranges refused access to feeds")
+	flag.StringVar(&flags.trustedProxies, "trusted-proxies", "127.0.0.1,::1", "Comma separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Forwarded-Proto headers are honoured")
+	flag.BoolVar(&flags.debugEndpoints, "debug-endpoints", false, "Serve pprof profiles under /debug/pprof/ and expvar at /debug/vars to admins")
+	flag.StringVar(&flags.signingKey, "signing-key", "", "Sign feed responses with this PEM Ed25519 private key (X-JWS-Signature header, key at /.well-known/jwks.json)")
+	flag.IntVar(&flags.mockItems, "mock-items", 20, "Items in each feed served by mockserver")
+	flag.DurationVar(&flags.mockInterval, "mock-interval", time.Hour, "How often each mockserver feed gains a new item")
+	flag.StringVar(&flags.generateUsername, "username", "", "Feed written by generate")
+	flag.StringVar(&flags.generateFormat, "format", formatRSS, "Format written by generate (rss, atom or json)")
+	flag.StringVar(&flags.generateOutput, "o", "-", "File written by generate, or - for stdout")
+	flag.StringVar(&flags.publishTo, "publish-to", "", "Directory or s3://bucket/prefix that publish uploads feeds to")
+	flag.DurationVar(&flags.publishInterval, "publish-interval", 0, "How often publish uploads every feed (0 publishes once and exits)")
+	flag.StringVar(&flags.publishFormats, "publish-formats", formatRSS, "Comma separated formats publish uploads (rss, atom, json)")
 	flag.Parse()
 	flagutil.SetFlagsFromEnv(flag.CommandLine, "TWITTER")
+	if err := loadSecretFiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := twitterEgress.Configure(flags.egress); err != nil {
+		log.Fatal(err)
+	}
 
-	if flags.consumerKey == "" || flags.consumerSecret == "" {
+	var fixtures *feedgen.FakeTwitterClient
+	if flags.twitterFixtures != "" {
+		var err error
+		if fixtures, err = loadTwitterFixtures(flags.twitterFixtures); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sources := sourceSettings{
+		fixtures:          fixtures,
+		twitterAPIVersion: flags.twitterAPIVersion,
+		consumerKey:       flags.consumerKey,
+		consumerSecret:    flags.consumerSecret,
+		accessToken:       flags.accessToken,
+		accessSecret:      flags.accessSecret,
+		nitterInstance:    flags.nitterInstance,
+	}
+	if len(flags.credentials) > 0 {
+		pool, err := newCredentialPoolFromFlags(flags.consumerKey, flags.consumerSecret, flags.credentials)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources.credentials = pool
+	}
+	if flags.vaultPath != "" {
+		if flags.vaultAddr == "" {
+			log.Fatal("-vault-path needs -vault-addr")
+		}
+		if flags.consumerKey != "" || flags.accessToken != "" || len(flags.credentials) > 0 {
+			log.Fatal("Twitter credentials can't be given both as flags and with -vault-path")
+		}
+		vault := newVaultCredentials(flags.vaultAddr, flags.vaultRole, flags.vaultAuthMount, flags.vaultPath)
+		if err := vault.Refresh(); err != nil {
+			log.Fatal(err)
+		}
+		go vault.Run()
+		sources.vault = vault
+	}
+	for _, name := range strings.Split(flags.sources, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			sources.order = append(sources.order, name)
+		}
+	}
+	if mockServer {
+		if flags.mockItems < 1 || flags.mockInterval < time.Second {
+			log.Fatal("mockserver needs -mock-items of at least 1 and -mock-interval of at least 1s")
+		}
+		// every username is served, so readers can use whichever they like
+		flags.allowAnyUsername = true
+	} else if sources.needsTwitterCredentials() && !sources.hasAppCredentials() {
 		log.Fatal("Application Access Token required")
 	}
+	if (flags.accessToken == "") != (flags.accessSecret == "") {
+		log.Fatal("-access-token and -access-secret must be given together")
+	}
+
+	if flags.public {
+		flags.allowAnyUsername = true
+		if flags.cacheTTL < publicCacheTTL {
+			flags.cacheTTL = publicCacheTTL
+		}
+	}
 
 	if os.Getenv("PORT") != "" {
 		port, err := strconv.Atoi(os.Getenv("PORT"))
@@ -60,18 +375,547 @@ func main() {
 		flags.port = port
 	}
 
+	cfg, err := loadConfig(flags.configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.addUsernames(flags.usernames)
+	var denied []string
+	for _, username := range strings.Split(flags.denyUsernames, ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			denied = append(denied, username)
+		}
+	}
+	cfg.denyUsernames(denied)
+
+	status := newFeedStatus(cfg.usernames())
+	if flags.opmlPath != "" {
+		if err := importOPMLFile(cfg, status, flags.opmlPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if flags.server.maxConcurrent < 0 {
+		log.Fatal("-max-concurrent-requests can't be negative")
+	}
+	if err := flags.server.tls.validate(); err != nil {
+		log.Fatal(err)
+	}
+	admin, err := newAdminAuth(cfg.AdminAuth, flags.adminToken)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := admin.(*mtlsAuth); ok {
+		if !flags.server.tls.enabled() {
+			log.Fatal("admin_auth mtls needs -tls-cert or -autocert-domain")
+		}
+		flags.server.tls.requestClientCerts = true
+	}
+	switch flags.feedSlugs {
+	case "":
+	case slugsHMAC:
+		if flags.slugSecret == "" {
+			log.Fatal("-feed-slugs=hmac requires -slug-secret")
+		}
+		if flags.allowAnyUsername || flags.public {
+			log.Fatal("-feed-slugs can't be used with -allow-any-username or -public, which serve feeds by username")
+		}
+		slugs = hmacSlugs{secret: []byte(flags.slugSecret)}
+	default:
+		log.Fatalf("unknown -feed-slugs scheme %q", flags.feedSlugs)
+	}
+	if err := logging.SetLevel(flags.logLevel); err != nil {
+		log.Fatal(err)
+	}
+	if flags.upstreamSampleRate < 0 || flags.upstreamSampleRate > 1 {
+		log.Fatal("-upstream-sample-rate must be between 0 and 1")
+	}
+	if tracing, err = newTracerFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if tracing != nil {
+		log.Printf("Exporting traces to %s", tracing.endpoint)
+		go tracing.Run()
+	}
+	upstreamLog.Configure(flags.upstreamLogSize, flags.upstreamSampleRate, flags.upstreamSampleBytes)
+	st := newStore(flags.cacheTTL)
+	var twitterSource timelineSource
+	if mockServer {
+		twitterSource = newMockSource(flags.mockItems, flags.mockInterval)
+	} else if twitterSource, err = newTimelineSource(sources); err != nil {
+		log.Fatal(err)
+	}
+	bus := newEventBus(500)
+	audit := newAuditLog(flags.auditLogPath, 500)
+	routed := newRoutedSource(twitterSource)
+	if mockServer {
+		for _, network := range []string{networkMastodon, networkBluesky, networkSearch} {
+			routed.Add(network, twitterSource)
+		}
+	} else if fixtures != nil {
+		routed.Add(networkSearch, newTwitterSearchSource(fixtures))
+	} else if sources.hasAppCredentials() {
+		routed.Add(networkSearch, newTwitterSearchSource(feedgen.NewGoTwitterClient(sources.twitterHTTPClient())))
+	}
+	var trendKeys []string
+	for _, woeid := range strings.Split(flags.trendLocations, ",") {
+		if woeid = strings.TrimSpace(woeid); woeid == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(woeid, 10, 64); err != nil {
+			log.Fatalf("-trend-locations: %q isn't a WOEID", woeid)
+		}
+		key := trendsFeedKey(woeid)
+		if cfg.Add(feedConfig{Username: key, Title: fmt.Sprintf("Trends (%s)", woeid)}) {
+			status.Add(key)
+		}
+		trendKeys = append(trendKeys, key)
+	}
+	if len(trendKeys) > 0 {
+		if fixtures != nil || !sources.hasAppCredentials() {
+			log.Fatal("-trend-locations needs Twitter API credentials")
+		}
+		routed.Add(networkTrends, newTwitterTrendsSource(sources.twitterHTTPClient()))
+	}
+	var account *accountTimelineSource
+	if (flags.accessToken != "" || flags.oauth2TokenFile != "") && !mockServer {
+		account = &accountTimelineSource{}
+		if flags.accessToken != "" {
+			account.client = newUserContextHTTPClient(flags.consumerKey, flags.consumerSecret, flags.accessToken, flags.accessSecret)
+		}
+		if flags.oauth2TokenFile != "" {
+			if flags.oauth2ClientID == "" {
+				log.Fatal("-twitter-oauth2-token-file needs -twitter-oauth2-client-id")
+			}
+			client, err := newOAuth2UserHTTPClient(flags.oauth2ClientID, flags.oauth2ClientSecret, flags.oauth2TokenFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			account.bookmarks = feedgen.NewTwitterV2Source(client)
+			account.bookmarks.OnResponse = recordUpstream
+		}
+		addAccountFeeds(cfg, status, routed, account)
+	}
+	f := newFetcher(routed, cfg, st, status, bus)
+	f.maxStale = flags.maxStale
+	if generate || publish {
+		var profiles *profileCache
+		if flags.profileTTL > 0 {
+			profiles = newProfileCache(routed, flags.profileTTL)
+		}
+		base := flags.baseURL
+		if base == "" {
+			base = fmt.Sprintf("http://localhost:%d", flags.port)
+		}
+		if publish {
+			if flags.publishTo == "" {
+				log.Fatal("publish requires -publish-to")
+			}
+			target, prefix := newPublishTarget(flags.publishTo, flags.s3Endpoint, flags.s3Region)
+			p, err := newStaticPublisher(cfg, f, profiles, target, prefix, base, flags.publishFormats)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if flags.publishInterval > 0 {
+				p.Run(flags.publishInterval)
+			}
+			if err := p.Publish(context.Background()); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if flags.generateUsername == "" {
+			log.Fatal("generate requires -username")
+		}
+		feed, err := generateFeed(context.Background(), cfg, f, profiles, flags.generateUsername, flags.generateFormat, base)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeGenerated(flags.generateOutput, feed); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	notifications := newNotifyQueue(st, flags.notifyRetry)
+	notifier := newWebhookNotifier(cfg, notifications)
+	bus.OnNewItems(notifier.Notify)
+	sla := newSLATracker()
+	bus.Handle(sla.Record)
+	bus.Handle((&alerter{cfg: cfg, store: st, bus: bus, notifier: notifier}).Check)
+	if flags.telegramBotToken != "" {
+		bus.OnNewItems(newTelegramPublisher(cfg, flags.telegramBotToken, notifications).Publish)
+	}
+
+	var hub *websubPublisher
+	if flags.websubHub != "" {
+		if flags.baseURL == "" {
+			log.Fatal("-websub-hub requires -base-url")
+		}
+		if flags.pollInterval == 0 && !flags.warmCache {
+			log.Print("-websub-hub without -poll-interval or -warm-cache only publishes when a reader triggers a fetch")
+		}
+		hub = newWebsubPublisher(flags.websubHub, flags.baseURL)
+		bus.OnNewItems(hub.Publish)
+	}
+
+	var cdn *cdnPurger
+	if flags.cdn != "" {
+		cdn, err = newCDNPurger(cfg, flags.cdn, flags.cdnPurgeEndpoint, flags.cdnPurgeToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bus.OnNewItems(cdn.Publish)
+	}
+
+	var persist *persister
+	if flags.storePath != "" {
+		persist = &persister{path: flags.storePath, store: st, s3Key: flags.backupKey}
+		if flags.s3Bucket != "" {
+			persist.s3 = newS3Client(flags.s3Endpoint, flags.s3Region, flags.s3Bucket)
+		}
+		if flags.restoreOnBoot {
+			if err := persist.RestoreIfMissing(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := persist.Load(); err != nil {
+			log.Fatal(err)
+		}
+		for username, entry := range st.Snapshot().Feeds {
+			status.Update(username, entry.Items)
+		}
+		go persist.Run(flags.storeInterval)
+	}
+
+	if flags.replicateFrom != "" {
+		log.Printf("Replicating from %s", flags.replicateFrom)
+		rep := &replicator{
+			primary:  flags.replicateFrom,
+			token:    flags.replicateToken,
+			interval: flags.replicateInterval,
+			store:    st,
+			status:   status,
+			client:   &http.Client{Timeout: time.Minute},
+		}
+		go rep.Run()
+	}
+
+	if len(cfg.Digests) > 0 {
+		if flags.smtpAddr == "" || flags.smtpFrom == "" {
+			log.Fatal("email digests require -smtp-addr and -smtp-from")
+		}
+		if flags.pollInterval == 0 && !flags.warmCache {
+			log.Print("email digests without -poll-interval or -warm-cache only include tweets fetched by readers")
+		}
+		sender := newDigestSender(st, flags.smtpAddr, flags.smtpUsername, flags.smtpPassword, flags.smtpFrom, notifications)
+		for _, digest := range cfg.Digests {
+			go sender.Run(digest)
+		}
+	}
+	// every sender is registered and the store loaded before deliveries start
+	go notifications.Run()
+
+	if flags.warmCache {
+		interval := flags.pollInterval
+		if interval <= 0 {
+			// refresh a little before the cache would expire
+			interval = flags.cacheTTL * 4 / 5
+		}
+		w := &cacheWarmer{cfg: cfg, fetcher: f, interval: interval}
+		go w.Run()
+	} else if flags.pollInterval > 0 {
+		p := &poller{cfg: cfg, fetcher: f, interval: flags.pollInterval}
+		go p.Run()
+	}
+
+	if flags.deletedCheckInterval > 0 {
+		if flags.pollInterval <= 0 && !flags.warmCache {
+			log.Fatal("-deleted-check-interval requires -poll-interval or -warm-cache")
+		}
+		deletions := &deletionChecker{cfg: cfg, fetcher: f, window: flags.deletedCheckWindow, interval: flags.deletedCheckInterval}
+		go deletions.Run()
+	}
+
+	if len(trendKeys) > 0 {
+		if flags.trendsInterval <= 0 {
+			log.Fatal("-trends-interval must be positive")
+		}
+		trends := &trendsRefresher{fetcher: f, keys: trendKeys, interval: flags.trendsInterval}
+		go trends.Run()
+	}
+
+	if flags.savedSearchInterval > 0 {
+		if flags.accessToken == "" || flags.accessSecret == "" {
+			log.Fatal("-saved-search-interval requires -access-token and -access-secret")
+		}
+		searches := &savedSearchSync{
+			client:   newUserContextHTTPClient(flags.consumerKey, flags.consumerSecret, flags.accessToken, flags.accessSecret),
+			cfg:      cfg,
+			status:   status,
+			interval: flags.savedSearchInterval,
+		}
+		go searches.Run()
+	}
+
+	if flags.configPath != "" {
+		go reloadOnHangup(flags.configPath, cfg, status, bus)
+	}
+
+	var media mediaProxy
+	if flags.mediaDir != "" {
+		if newMediaProxy == nil {
+			log.Fatal("-media-dir isn't available in this build, which was built with the minimal tag")
+		}
+		var hosts []string
+		for _, host := range strings.Split(flags.mediaHosts, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		media, err = newMediaProxy(flags.mediaDir, flags.mediaRevalidate, hosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var dynamic *dynamicFeeds
+	if flags.allowAnyUsername {
+		dynamic = &dynamicFeeds{cfg: cfg, status: status, failureTTL: time.Hour}
+		if flags.ipNewFeeds > 0 {
+			dynamic.newPerIP = newWindowLimiter(flags.ipNewFeeds, 24*time.Hour)
+		}
+		if flags.public {
+			dynamic.newPerIP = newWindowLimiter(flags.publicIPNewFeeds, 24*time.Hour)
+			dynamic.maxFeeds = flags.publicMaxFeeds
+			dynamic.failureTTL = flags.publicFailureCache
+		}
+		if flags.requireApproval {
+			dynamic.queue, err = newApprovalQueue(flags.approvalsPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dynamic.restoreApproved()
+		}
+	}
+
+	var profiles *profileCache
+	if flags.profileTTL > 0 {
+		profiles = newProfileCache(routed, flags.profileTTL)
+	}
+
+	var clicks *clickCounter
+	if flags.redirectLinks {
+		clicks, err = newClickCounter(st, flags.clicksPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flags.clicksPath != "" {
+			go clicks.Run(flags.storeInterval)
+		}
+	}
+
+	readers := newReaderStats()
+	purge := newPurger(persist)
+	purge.Register("config", cfg)
+	purge.Register("status", status)
+	purge.Register("store", st)
+	purge.Register("events", bus)
+	purge.Register("sla", sla)
+	purge.Register("readers", readers)
+	if media != nil {
+		purge.Register("media", media)
+	}
+	if dynamic != nil && dynamic.queue != nil {
+		purge.Register("approvals", dynamic.queue)
+	}
+	if clicks != nil {
+		purge.Register("clicks", clicks)
+	}
+	if profiles != nil {
+		purge.Register("profiles", profiles)
+	}
+
+	var signer *feedSigner
+	if flags.signingKey != "" {
+		signer, err = loadSigningKey(flags.signingKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	r := mux.NewRouter()
+	r.Use(traceRoute)
+	switch {
+	case flags.public:
+		r.HandleFunc("/", LandingHandler)
+	case slugs != nil:
+		// the feed list would give away what the slugs hide
+		r.HandleFunc("/", RequireAdmin(admin, IndexHandler(status)))
+	default:
+		r.HandleFunc("/", IndexHandler(status))
+	}
 	r.HandleFunc("/healthcheck", HealthCheckHandler)
+	r.HandleFunc("/livez", LivezHandler).Methods("GET")
+	r.HandleFunc("/version", VersionHandler).Methods("GET")
+	r.HandleFunc("/readyz", ReadyzHandler(status, sla)).Methods("GET")
+	if slugs != nil {
+		r.HandleFunc("/opml.xml", RequireAdmin(admin, OPMLHandler(cfg)))
+	} else {
+		r.HandleFunc("/opml.xml", OPMLHandler(cfg))
+	}
+	r.HandleFunc("/metrics", MetricsHandler)
 
-	for i := 0; i < len(flags.usernames); i++ {
-		url := fmt.Sprintf("/feed/%s.xml", flags.usernames[i])
-		log.Print(url)
-		r.HandleFunc(url, UsernameHandler(flags.usernames[i], flags.consumerKey, flags.consumerSecret))
+	info := serviceInfo{
+		Software:     "twitterrss",
+		Version:      currentBuild().Version,
+		Networks:     []string{"twitter", networkMastodon, networkBluesky},
+		Formats:      []string{"rss"},
+		Capabilities: []string{"archive_search", "as_of", "filters", "groups", "opml"},
+	}
+	if slugs == nil {
+		info.Capabilities = append(info.Capabilities, "webfinger")
+	}
+	if sources.hasAppCredentials() {
+		info.Networks = append(info.Networks, networkSearch)
+	}
+	if hub != nil {
+		info.Capabilities = append(info.Capabilities, "websub")
+	}
+	if media != nil {
+		info.Capabilities = append(info.Capabilities, "media_proxy")
+	}
+	if dynamic != nil {
+		info.Capabilities = append(info.Capabilities, "dynamic_feeds")
+	}
+	if clicks != nil {
+		info.Capabilities = append(info.Capabilities, "click_counts")
+	}
+	if signer != nil {
+		info.Capabilities = append(info.Capabilities, "signed_feeds")
+		r.HandleFunc("/.well-known/jwks.json", JWKSHandler(signer))
+	}
+	r.HandleFunc("/.well-known/twitterrss.json", ServiceInfoHandler(info))
+	r.HandleFunc("/.well-known/nodeinfo", NodeInfoDiscoveryHandler)
+	r.HandleFunc("/nodeinfo/2.0", NodeInfoHandler(cfg, info))
+	r.HandleFunc("/.well-known/host-meta", HostMetaHandler)
+	if slugs == nil {
+		r.HandleFunc("/.well-known/webfinger", WebFingerHandler(cfg))
+	}
+	if media != nil {
+		r.HandleFunc("/media/{name}", media.Handler())
+	}
+	if clicks != nil {
+		r.HandleFunc("/r/{id}", RedirectHandler(cfg, clicks))
+		r.HandleFunc("/admin/clicks", RequireAdmin(admin, ClicksHandler(clicks))).Methods("GET")
+	}
+	r.HandleFunc("/admin/replication/snapshot", RequireAdmin(admin, SnapshotHandler(st)))
+	r.HandleFunc("/api/status", RequireAdmin(admin, StatusHandler(status, sla))).Methods("GET")
+	r.HandleFunc("/api/filters/test", RequireAdmin(admin, FilterTestHandler(cfg, f))).Methods("POST")
+	r.HandleFunc("/api/events", RequireAdmin(admin, EventsHandler(bus)))
+	r.HandleFunc("/api/events/stream", RequireAdmin(admin, EventStreamHandler(bus, flags.server.streamLifetime())))
+	legacy := newLegacyHits()
+	r.HandleFunc("/admin/readers", RequireAdmin(admin, ReadersHandler(readers))).Methods("GET")
+	r.HandleFunc("/admin/legacy-routes", RequireAdmin(admin, LegacyClientsHandler(legacy))).Methods("GET")
+	if cdn != nil {
+		r.HandleFunc("/admin/cdn/purge/{username:.+}", RequireAdmin(admin, CDNPurgeHandler(cdn, audit))).Methods("POST")
+	}
+	r.HandleFunc("/admin/logging", RequireAdmin(admin, LogLevelHandler(audit))).Methods("GET", "POST")
+	if flags.debugEndpoints {
+		if registerDebugging == nil {
+			log.Fatal("-debug-endpoints isn't available in this build, which was built with the minimal tag")
+		}
+		registerDebugging(r, admin)
+	}
+	r.HandleFunc("/admin/audit", RequireAdmin(admin, AuditHandler(audit)))
+	r.HandleFunc("/admin/upstream", RequireAdmin(admin, OutboundHandler(upstreamLog))).Methods("GET")
+	r.HandleFunc("/admin/redactions", RequireAdmin(admin, RedactionsHandler(st))).Methods("GET")
+	r.HandleFunc("/admin/redactions", RequireAdmin(admin, RedactHandler(st, audit, bus))).Methods("POST")
+	r.HandleFunc("/admin/feeds/{username:.+}", RequireAdmin(admin, PurgeHandler(purge, cfg, audit))).Methods("DELETE")
+	r.HandleFunc("/admin/opml", RequireAdmin(admin, OPMLImportHandler(cfg, status, audit))).Methods("POST")
+	r.HandleFunc("/admin/export.zip", RequireAdmin(admin, ExportHandler(cfg, st))).Methods("GET")
+	r.HandleFunc("/admin/archive/{username:.+}", RequireAdmin(admin, ArchiveExportHandler(cfg, st))).Methods("GET")
+	r.HandleFunc("/admin/selfcheck", RequireAdmin(admin, SelfCheckHandler(cfg, st, media))).Methods("POST")
+	var queue *approvalQueue
+	if dynamic != nil {
+		queue = dynamic.queue
+	}
+	if newDashboard != nil {
+		r.HandleFunc("/admin", RequireAdmin(admin, newDashboard(status, queue, sla, notifications))).Methods("GET")
+	}
+	r.HandleFunc("/admin/notifications", RequireAdmin(admin, NotificationsHandler(notifications))).Methods("GET")
+	r.HandleFunc("/admin/notifications/{id}/retry", RequireAdmin(admin, DeadLetterHandler(notifications, audit, true))).Methods("POST")
+	r.HandleFunc("/admin/notifications/{id}/discard", RequireAdmin(admin, DeadLetterHandler(notifications, audit, false))).Methods("POST")
+	if queue != nil {
+		r.HandleFunc("/admin/pending", RequireAdmin(admin, PendingHandler(queue))).Methods("GET")
+		r.HandleFunc("/admin/pending/{username:.+}/approve", RequireAdmin(admin, DecideHandler(dynamic, audit, true))).Methods("POST")
+		r.HandleFunc("/admin/pending/{username:.+}/reject", RequireAdmin(admin, DecideHandler(dynamic, audit, false))).Methods("POST")
+	}
+	if persist != nil {
+		r.HandleFunc("/admin/checkpoint", RequireAdmin(admin, CheckpointHandler(persist))).Methods("POST")
 	}
 
-	loggedRouter := handlers.LoggingHandler(os.Stdout, r)
-	log.Printf("Listening on :%d\n", flags.port)
-	http.ListenAndServe(fmt.Sprintf(":%d", flags.port), Recovery(handlers.ProxyHeaders(loggedRouter)))
+	for _, username := range cfg.usernames() {
+		log.Print(feedPath(username))
+	}
+	auth := &feedAuth{cfg: cfg, tokens: flags.feedTokens}
+	feedHandler := readers.Track(auth.Require(UsernameHandler(cfg, f, hub, media, dynamic, clicks, profiles, flags.storePath != "", flags.tombstones)))
+	if flags.public {
+		quotas := &publicQuotas{
+			perIP:  newWindowLimiter(flags.publicIPRequests, time.Minute),
+			global: newWindowLimiter(flags.publicRequests, time.Minute),
+		}
+		feedHandler = quotas.Limit(feedHandler)
+	}
+	if slugs != nil {
+		r.HandleFunc("/feed/{slug}.xml", SlugRoutes(cfg, feedHandler))
+	} else {
+		if account != nil {
+			r.HandleFunc(account.Route(), feedHandler)
+		}
+		r.HandleFunc("/feed/{username}.xml", feedHandler)
+		r.HandleFunc("/feed/mastodon/{instance}/{user}.xml", feedHandler)
+		r.HandleFunc("/feed/bsky/{handle}.xml", feedHandler)
+		r.HandleFunc("/feed/search/{query}.xml", feedHandler)
+		r.HandleFunc("/feed/trends/{woeid:[0-9]+}.xml", feedHandler)
+	}
+	r.HandleFunc("/feed/group/{group}.xml", auth.Require(GroupHandler(cfg, f, media, clicks)))
+	if slugs != nil {
+		// briefings and search results would give away which accounts the
+		// slugs hide
+		r.HandleFunc("/briefing/{digest}.html", RequireAdmin(admin, BriefingHandler(cfg, st)))
+		r.HandleFunc("/search", RequireAdmin(admin, ArchiveSearchHandler(cfg, st, auth))).Methods("GET")
+	} else {
+		r.HandleFunc("/briefing/{digest}.html", auth.Require(BriefingHandler(cfg, st)))
+		r.HandleFunc("/search", auth.Require(ArchiveSearchHandler(cfg, st, auth))).Methods("GET")
+	}
+
+	var handler http.Handler = r
+	if signer != nil {
+		handler = signer.Signed(handler)
+	}
+	if flags.allowIPs != "" || flags.denyIPs != "" {
+		ips, err := newIPFilter(flags.allowIPs, flags.denyIPs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler = ips.Restrict(handler)
+	}
+	if flags.ipRate > 0 {
+		if flags.ipBurst < 1 {
+			log.Fatal("-ip-burst must be at least 1")
+		}
+		handler = newBucketLimiter(flags.ipRate, flags.ipBurst).Limit(handler)
+	}
+	proxies, err := newTrustedProxies(flags.trustedProxies)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "invalid -trusted-proxies"))
+	}
+	loggedRouter := handlers.CustomLoggingHandler(levelWriter{level: logInfo, next: os.Stdout}, ResponseHeaders(cfg, LegacyRoutes(cfg, legacy, handler)), writeRequestLog)
+	if err := listen(flags.port, RequestID(Tracing(Recovery(proxies.Handler(loggedRouter)))), flags.server); err != nil {
+		log.Fatal(err)
+	}
+	if tracing != nil {
+		tracing.Flush()
+	}
 }
 
 func Recovery(next http.Handler) http.Handler {
@@ -81,15 +925,17 @@ func Recovery(next http.Handler) http.Handler {
 			err := recover()
 			if err != nil {
 
+				id := requestIDFrom(r.Context())
 				jsonBody, _ := json.Marshal(map[string]string{
-					"error": "There was an internal server error",
+					"error":      "There was an internal server error",
+					"request_id": id,
 				})
 
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write(jsonBody)
 
-				log.Fatal(err)
+				log.Fatalf("request %s: %v", id, err)
 			}
 
 		}()
@@ -110,61 +956,20 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonBody)
 }
 
-func UsernameHandler(username string, consumerKey string, consumerSecret string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// oauth2 configures a client that uses app credentials to keep a fresh token
-		config := &clientcredentials.Config{
-			ClientID:     consumerKey,
-			ClientSecret: consumerSecret,
-			TokenURL:     "https://api.twitter.com/oauth2/token",
-		}
-		// http.Client will automatically authorize Requests
-		httpClient := config.Client(oauth2.NoContext)
-
-		// Twitter client
-		client := twitter.NewClient(httpClient)
-
-		// Status Show
-		tweets, _, err := client.Timelines.UserTimeline(&twitter.UserTimelineParams{
-			ScreenName:     username,
-			ExcludeReplies: twitter.Bool(true),
-		})
-
+// reloadOnHangup re-reads the config file whenever the process gets SIGHUP.
+func reloadOnHangup(path string, cfg *config, status *feedStatus, bus *eventBus) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		next, err := loadConfig(path)
 		if err != nil {
-			panic(errors.Wrap(err, "Unable to get tweets"))
-		}
-
-		feed := &feeds.Feed{
-			Title:       fmt.Sprintf("%s tweets", username),
-			Link:        &feeds.Link{Href: r.URL.Path},
-			Description: fmt.Sprintf("%s tweets", username),
-			Author:      &feeds.Author{Name: "https://github.com/halkeye/twitterrss"},
-			Created:     time.Now(),
-		}
-
-		var feedItems []*feeds.Item
-		for i := 0; i < len(tweets); i++ {
-			tweet := tweets[i]
-			createdAt, _ := tweet.CreatedAtTime()
-			feedItems = append(feedItems,
-				&feeds.Item{
-					Id:          tweet.IDStr,
-					Title:       tweet.IDStr,
-					Link:        &feeds.Link{Href: tweet.Source},
-					Description: tweet.Text,
-					Created:     createdAt,
-				})
+			log.Print(errors.Wrap(err, "config reload failed"))
+			continue
 		}
-
-		feed.Items = feedItems
-
-		rss, err := feed.ToRss()
-		if err != nil {
-			panic(errors.Wrap(err, "unable to create rss feed"))
+		for _, username := range cfg.Reload(next) {
+			status.Add(username)
+			log.Print(feedPath(username))
 		}
-
-		w.Header().Set("Content-Type", "application/rss+xml")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(rss))
+		bus.Publish(event{Type: eventConfigReloaded})
 	}
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+type mastodonAccount struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Acct        string `json:"acct"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+	URL         string `json:"url"`
+}
+
+type mastodonStatus struct {
+	ID               string          `json:"id"`
+	URL              string          `json:"url"`
+	Content          string          `json:"content"`
+	SpoilerText      string          `json:"spoiler_text"`
+	Language         string          `json:"language"`
+	CreatedAt        time.Time       `json:"created_at"`
+	Account          mastodonAccount `json:"account"`
+	MediaAttachments []struct {
+		Type        string `json:"type"`
+		URL         string `json:"url"`
+		PreviewURL  string `json:"preview_url"`
+		Description string `json:"description"`
+		Meta        struct {
+			Small struct {
+				Width  int `json:"width"`
+				Height int `json:"height"`
+			} `json:"small"`
+		} `json:"meta"`
+	} `json:"media_attachments"`
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	Poll *struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		Expired   bool       `json:"expired"`
+		Options   []struct {
+			Title      string `json:"title"`
+			VotesCount int    `json:"votes_count"`
+		} `json:"options"`
+	} `json:"poll"`
+	Reblog             *mastodonStatus `json:"reblog"`
+	InReplyToID        string          `json:"in_reply_to_id"`
+	InReplyToAccountID string          `json:"in_reply_to_account_id"`
+	ReblogsCount       int             `json:"reblogs_count"`
+	FavouritesCount    int             `json:"favourites_count"`
+	RepliesCount       int             `json:"replies_count"`
+}
+
+// mastodonSource reads an account's public posts from its home instance.
+// Accounts are "instance/username", e.g. "mastodon.social/Gargron".
+type mastodonSource struct {
+	client *http.Client
+
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newMastodonSource() *mastodonSource {
+	return &mastodonSource{
+		// instances can be named by anyone in dynamic mode
+		client: audited(&http.Client{Timeout: 30 * time.Second, Transport: publicTransport()}),
+		ids:    map[string]string{},
+	}
+}
+
+func (s *mastodonSource) get(ctx context.Context, endpoint string, rawURL string, v interface{}) error {
+	resp, err := feedgen.WithContext(s.client, ctx).Get(rawURL)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("mastodon: %s", resp.Status)
+	}
+	recordUpstream(endpoint, resp, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// accountID resolves and caches the instance-local ID for an account.
+func (s *mastodonSource) accountID(ctx context.Context, instance string, username string) (string, error) {
+	key := instance + "/" + strings.ToLower(username)
+	s.mu.Lock()
+	id, ok := s.ids[key]
+	s.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	account := mastodonAccount{}
+	lookup := fmt.Sprintf("https://%s/api/v1/accounts/lookup?acct=%s", instance, url.QueryEscape(username))
+	if err := s.get(ctx, "mastodon_account_lookup", lookup, &account); err != nil {
+		return "", errors.Wrap(err, "look up mastodon account")
+	}
+
+	s.mu.Lock()
+	s.ids[key] = account.ID
+	s.mu.Unlock()
+	return account.ID, nil
+}
+
+func (s *mastodonSource) FetchTimeline(account string, opts fetchOptions) ([]item, error) {
+	parts := strings.SplitN(account, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("mastodon account %q must be instance/username", account)
+	}
+	instance, username := parts[0], parts[1]
+
+	id, err := s.accountID(opts.Context, instance, username)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"limit": {fmt.Sprint(opts.Count)}}
+	if opts.ExcludeReplies {
+		query.Set("exclude_replies", "true")
+	}
+	statuses := []mastodonStatus{}
+	statusesURL := fmt.Sprintf("https://%s/api/v1/accounts/%s/statuses?%s", instance, url.PathEscape(id), query.Encode())
+	if err := s.get(opts.Context, "mastodon_account_statuses", statusesURL, &statuses); err != nil {
+		return nil, errors.Wrap(err, "fetch mastodon statuses")
+	}
+
+	items := make([]item, 0, len(statuses))
+	for _, status := range statuses {
+		items = append(items, itemFromMastodon(status))
+	}
+	return items, nil
+}
+
+func itemFromMastodon(status mastodonStatus) item {
+	// boosts carry the original post in reblog
+	post := status
+	if status.Reblog != nil {
+		post = *status.Reblog
+	}
+	it := item{
+		ID:        status.ID,
+		URL:       post.URL,
+		Text:      htmlText(post.Content),
+		Lang:      post.Language,
+		CreatedAt: status.CreatedAt,
+		Author: itemAuthor{
+			Username:  post.Account.Acct,
+			Name:      post.Account.DisplayName,
+			AvatarURL: post.Account.Avatar,
+			URL:       post.Account.URL,
+		},
+	}
+	for _, tag := range post.Tags {
+		it.Hashtags = append(it.Hashtags, tag.Name)
+	}
+	it.Metrics = &itemMetrics{Retweets: post.ReblogsCount, Likes: post.FavouritesCount, Replies: post.RepliesCount}
+	if status.Reblog != nil {
+		it.RetweetOf = post.ID
+	}
+	if post.InReplyToID != "" {
+		it.InReplyToID = post.InReplyToID
+		// only self-replies are told apart; other accounts stay ids
+		it.InReplyToUser = post.InReplyToAccountID
+		if post.InReplyToAccountID == post.Account.ID {
+			it.InReplyToUser = post.Account.Acct
+		}
+	}
+	if post.SpoilerText != "" {
+		it.Text = post.SpoilerText + "\n\n" + it.Text
+	}
+	for _, media := range post.MediaAttachments {
+		it.Media = append(it.Media, itemMedia{
+			Type:         media.Type,
+			URL:          media.URL,
+			ThumbnailURL: media.PreviewURL,
+			Width:        media.Meta.Small.Width,
+			Height:       media.Meta.Small.Height,
+			AltText:      media.Description,
+		})
+	}
+	if poll := post.Poll; poll != nil {
+		it.Poll = &itemPoll{Closed: poll.Expired}
+		if poll.ExpiresAt != nil {
+			it.Poll.EndsAt = *poll.ExpiresAt
+		}
+		for _, option := range poll.Options {
+			it.Poll.Options = append(it.Poll.Options, pollOption{Label: option.Title, Votes: option.VotesCount})
+		}
+	}
+	return it
+}
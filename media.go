@@ -0,0 +1,366 @@
+//go:build !minimal
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// maxMediaBytes caps the size of a single proxied media file.
+const maxMediaBytes = 50 << 20
+
+var mediaHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// defaultMediaHosts are the CDNs Twitter and Bluesky serve media from.
+var defaultMediaHosts = []string{"twimg.com", "cdn.bsky.app", "video.bsky.app"}
+
+// mediaObject is a proxied media file, addressed by the hash of its content.
+type mediaObject struct {
+	Hash        string          `json:"hash"`
+	ContentType string          `json:"content_type"`
+	Length      int             `json:"length"`
+	FetchedAt   time.Time       `json:"fetched_at"`
+	Feeds       map[string]bool `json:"feeds"`
+}
+
+// mediaCache downloads tweet media into a local directory and serves it
+// under content-hash URLs. Because a URL names exact bytes, responses are
+// marked immutable and never need revalidating; the upstream is rechecked
+// periodically and the URL only rotates when the content really changes.
+type mediaCache struct {
+	dir        string
+	revalidate time.Duration
+	client     *http.Client
+	// hosts are the hosts, with their subdomains, media is fetched from;
+	// items can link anything, and anything fetched is served back.
+	hosts []string
+
+	mu       sync.Mutex
+	sources  map[string]*mediaObject
+	inflight map[string]bool
+}
+
+func newMediaCache(dir string, revalidate time.Duration, hosts []string) (*mediaCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create media directory")
+	}
+	m := &mediaCache{
+		dir:        dir,
+		revalidate: revalidate,
+		client:     &http.Client{Timeout: 2 * time.Minute, Transport: publicTransport()},
+		hosts:      append(append([]string(nil), defaultMediaHosts...), hosts...),
+		sources:    map[string]*mediaObject{},
+		inflight:   map[string]bool{},
+	}
+
+	data, err := os.ReadFile(m.indexPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "unable to read media index")
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &m.sources); err != nil {
+			return nil, errors.Wrap(err, "unable to parse media index")
+		}
+	}
+	return m, nil
+}
+
+func (m *mediaCache) indexPath() string {
+	return filepath.Join(m.dir, "index.json")
+}
+
+func (m *mediaCache) blobPath(hash string) string {
+	return filepath.Join(m.dir, hash[:2], hash)
+}
+
+func (m *mediaCache) saveIndexLocked() error {
+	data, err := json.Marshal(m.sources)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode media index")
+	}
+	return writeFileAtomic(m.indexPath(), data)
+}
+
+// mediaExtensions are the conventional extensions for common media types;
+// mime.ExtensionsByType sorts alphabetically and picks odd ones like .jfif.
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"video/mp4":  ".mp4",
+}
+
+// mediaPath is the proxy path for an object.
+func mediaPath(obj *mediaObject) string {
+	mediaType, _, _ := mime.ParseMediaType(obj.ContentType)
+	ext, ok := mediaExtensions[mediaType]
+	if !ok {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return fmt.Sprintf("/media/%s%s", obj.Hash, ext)
+}
+
+// allowed reports whether source is https media from one of m's hosts.
+func (m *mediaCache) allowed(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme != "https" || u.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range m.hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the cached object for source, noting that feed uses it.
+// Missing or stale sources
+// are fetched in the background so a later rendering can use them; those
+// from hosts that aren't allowed are never fetched.
+func (m *mediaCache) Lookup(feed string, source string) *mediaObject {
+	if !m.allowed(source) {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.sources[source]
+	if ok {
+		if obj.Feeds == nil {
+			obj.Feeds = map[string]bool{}
+		}
+		obj.Feeds[feed] = true
+	}
+	if (!ok || time.Since(obj.FetchedAt) > m.revalidate) && !m.inflight[source] {
+		m.inflight[source] = true
+		go m.fetch(feed, source)
+	}
+	if !ok {
+		return nil
+	}
+	copied := *obj
+	return &copied
+}
+
+func (m *mediaCache) fetch(feed string, source string) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.inflight, source)
+		m.mu.Unlock()
+	}()
+	if err := m.download(feed, source); err != nil {
+		log.Print(errors.Wrapf(err, "unable to cache media %s", source))
+	}
+}
+
+func (m *mediaCache) download(feed string, source string) error {
+	resp, err := m.client.Get(source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMediaBytes {
+		return fmt.Errorf("media larger than %d bytes", maxMediaBytes)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	path := m.blobPath(hash)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.sources[source]
+	if !ok {
+		obj = &mediaObject{Feeds: map[string]bool{}}
+		m.sources[source] = obj
+	}
+	previous := obj.Hash
+	obj.Hash = hash
+	obj.ContentType = contentType
+	obj.Length = len(data)
+	obj.FetchedAt = time.Now()
+	obj.Feeds[feed] = true
+	if previous != "" && previous != hash {
+		m.removeUnreferencedLocked(previous)
+	}
+	return m.saveIndexLocked()
+}
+
+func (m *mediaCache) removeUnreferencedLocked(hash string) {
+	for _, obj := range m.sources {
+		if obj.Hash == hash {
+			return
+		}
+	}
+	os.Remove(m.blobPath(hash))
+}
+
+// Enclosure is the proxied enclosure for the first media of an item in
+// feed, using base as the public URL of this instance.
+func (m *mediaCache) Enclosure(base string, feed string, it item) *feeds.Enclosure {
+	if len(it.Media) == 0 {
+		return nil
+	}
+	obj := m.Lookup(feed, it.Media[0].URL)
+	if obj == nil {
+		return nil
+	}
+	return &feeds.Enclosure{
+		Url:    base + mediaPath(obj),
+		Type:   obj.ContentType,
+		Length: fmt.Sprint(obj.Length),
+	}
+}
+
+// Proxied is it with its media URLs, and those of the post it quotes,
+// pointing at the cached copies, so readers never fetch from Twitter and
+// reveal themselves to it. Media not cached yet keeps its URL until a
+// later rendering. The media is filed under feed, so purging the feed
+// removes it.
+func (m *mediaCache) Proxied(base string, feed string, it item) item {
+	proxied := func(source string) string {
+		if source == "" {
+			return ""
+		}
+		if obj := m.Lookup(feed, source); obj != nil {
+			return base + mediaPath(obj)
+		}
+		return source
+	}
+	if len(it.Media) > 0 {
+		media := make([]itemMedia, len(it.Media))
+		for i, med := range it.Media {
+			med.URL, med.ThumbnailURL = proxied(med.URL), proxied(med.ThumbnailURL)
+			media[i] = med
+		}
+		it.Media = media
+	}
+	if it.Quoted != nil {
+		quoted := m.Proxied(base, feed, *it.Quoted)
+		it.Quoted = &quoted
+	}
+	return it
+}
+
+func init() {
+	newMediaProxy = func(dir string, revalidate time.Duration, hosts []string) (mediaProxy, error) {
+		return newMediaCache(dir, revalidate, hosts)
+	}
+}
+
+func (m *mediaCache) Handler() http.HandlerFunc {
+	return MediaHandler(m)
+}
+
+// MediaHandler serves cached media by content hash.
+func MediaHandler(m *mediaCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		hash := strings.SplitN(name, ".", 2)[0]
+		if !mediaHashPattern.MatchString(hash) {
+			http.NotFound(w, r)
+			return
+		}
+
+		m.mu.Lock()
+		var obj *mediaObject
+		for _, candidate := range m.sources {
+			if candidate.Hash == hash {
+				obj = candidate
+				break
+			}
+		}
+		m.mu.Unlock()
+		if obj == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		file, err := os.Open(m.blobPath(hash))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", obj.ContentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+hash+`"`)
+		http.ServeContent(w, r, "", time.Time{}, file)
+	}
+}
+
+// PurgeFeed forgets media referenced by username and deletes files no
+// other feed uses.
+func (m *mediaCache) PurgeFeed(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for source, obj := range m.sources {
+		if !obj.Feeds[username] {
+			continue
+		}
+		delete(obj.Feeds, username)
+		if len(obj.Feeds) == 0 {
+			delete(m.sources, source)
+			m.removeUnreferencedLocked(obj.Hash)
+		}
+	}
+	return m.saveIndexLocked()
+}
+
+func (m *mediaCache) HasFeed(username string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, obj := range m.sources {
+		if obj.Feeds[username] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricVec is a labelled counter or gauge exposed in the Prometheus text
+// format on /metrics.
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+var registry []*metricVec
+
+func newMetricVec(kind string, name string, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	registry = append(registry, m)
+	return m
+}
+
+func newCounterVec(name string, help string, labels ...string) *metricVec {
+	return newMetricVec("counter", name, help, labels...)
+}
+
+func newGaugeVec(name string, help string, labels ...string) *metricVec {
+	return newMetricVec("gauge", name, help, labels...)
+}
+
+func (m *metricVec) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// Inc adds one to the series identified by labelValues.
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Add adds delta to the series identified by labelValues.
+func (m *metricVec) Add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[m.key(labelValues)] += delta
+}
+
+// Set replaces the value of the series identified by labelValues.
+func (m *metricVec) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[m.key(labelValues)] = value
+}
+
+func (m *metricVec) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var pairs []string
+		if len(m.labels) > 0 {
+			for i, value := range strings.Split(key, "\xff") {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", m.labels[i], value))
+			}
+		}
+		if len(pairs) > 0 {
+			fmt.Fprintf(b, "%s{%s} %g\n", m.name, strings.Join(pairs, ","), m.values[key])
+		} else {
+			fmt.Fprintf(b, "%s %g\n", m.name, m.values[key])
+		}
+	}
+}
+
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, m := range registry {
+		m.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
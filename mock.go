@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// mockSource makes up deterministic timelines, for the mockserver mode that
+// lets readers and automations be tested against real feed output without
+// credentials. Every account gets a new post each interval, and the posts
+// in a timeline depend only on the account and the time.
+type mockSource struct {
+	items    int
+	interval time.Duration
+	now      func() time.Time
+}
+
+func newMockSource(items int, interval time.Duration) *mockSource {
+	return &mockSource{items: items, interval: interval, now: time.Now}
+}
+
+func (s *mockSource) FetchTimeline(username string, opts fetchOptions) ([]item, error) {
+	count := s.items
+	if opts.Count > 0 && opts.Count < count {
+		count = opts.Count
+	}
+	latest := s.now().Unix() / int64(s.interval/time.Second)
+	items := make([]item, 0, count)
+	for n := latest; n > latest-int64(count) && n >= 0; n-- {
+		items = append(items, s.post(username, n))
+	}
+	return items, nil
+}
+
+// post is the nth post of username. Some posts have hashtags, media or a
+// location, so every part of the output formats is exercised.
+func (s *mockSource) post(username string, n int64) item {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	id := fmt.Sprintf("%d%05d", n, h.Sum32()%100000)
+
+	it := item{
+		ID:        id,
+		URL:       fmt.Sprintf("https://twitter.com/%s/status/%s", username, id),
+		Text:      fmt.Sprintf("Synthetic post %d from @%s", n, username),
+		CreatedAt: time.Unix(n*int64(s.interval/time.Second), 0).UTC(),
+		Author:    itemAuthor{Username: username, Name: "Mock " + username, URL: "https://twitter.com/" + username},
+		Lang:      "en",
+		Metrics:   &itemMetrics{Retweets: int(n % 13), Likes: int(n % 97), Replies: int(n % 7), Quotes: int(n % 3)},
+	}
+	if n%3 == 0 {
+		it.Text += " #mock"
+		it.Hashtags = []string{"mock"}
+	}
+	if n%5 == 0 {
+		it.Media = []itemMedia{{
+			Type:         "photo",
+			URL:          fmt.Sprintf("https://example.com/mock/%s/%d.jpg", username, n),
+			ThumbnailURL: fmt.Sprintf("https://example.com/mock/%s/%d_small.jpg", username, n),
+			Width:        680,
+			Height:       383,
+		}}
+	}
+	if n%7 == 0 {
+		it.Geo = &itemGeo{Lat: 51.5072, Long: -0.1276, Place: "London, England"}
+	}
+	return it
+}
+
+// DeletedPosts reports every seventh post as deleted once it has left the
+// timeline, so deletion checks have something to find.
+func (s *mockSource) DeletedPosts(ids []string) ([]string, error) {
+	oldest := s.now().Unix()/int64(s.interval/time.Second) - int64(s.items)
+	var deleted []string
+	for _, id := range ids {
+		if len(id) <= 5 {
+			continue
+		}
+		n, err := strconv.ParseInt(id[:len(id)-5], 10, 64)
+		if err == nil && n <= oldest && n%7 == 0 {
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+func (s *mockSource) FetchProfile(username string) (profile, error) {
+	return profile{
+		Username:    username,
+		Name:        "Mock " + username,
+		Description: "A synthetic account served by twitterrss mockserver",
+		URL:         "https://twitter.com/" + username,
+	}, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/gorilla/mux"
+)
+
+// Optional subsystems live in files the minimal build profile leaves out
+// (go build -tags minimal), for small static binaries on embedded hosts.
+// Each registers itself here from an init func; a nil hook means this
+// binary was built without it.
+var (
+	newMediaProxy     func(dir string, revalidate time.Duration, hosts []string) (mediaProxy, error)
+	newDashboard      func(status *feedStatus, queue *approvalQueue, sla *slaTracker, notifications *notifyQueue) http.HandlerFunc
+	registerDebugging func(r *mux.Router, admin adminAuth)
+)
+
+// mediaProxy caches tweet media and serves it from this instance.
+type mediaProxy interface {
+	purgeable
+	// Enclosure is the proxied enclosure for its first media, if cached.
+	Enclosure(base string, feed string, it item) *feeds.Enclosure
+	// Proxied is it with the media it links to, and that of the post it
+	// quotes, pointing at their cached copies, which feed is recorded as
+	// using.
+	Proxied(base string, feed string, it item) item
+	// Handler serves /media/{name}.
+	Handler() http.HandlerFunc
+}
+
+// buildProfile names the subsystems compiled in, for /version.
+func buildProfile() string {
+	if newMediaProxy == nil || newDashboard == nil || registerDebugging == nil {
+		return "minimal"
+	}
+	return "full"
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newsletterSection is one account's posts in a newsletter.
+type newsletterSection struct {
+	Feed  string
+	URL   string
+	Items []item
+}
+
+// accountURL is the profile page of the account behind a feed key, or
+// empty for trends and the authenticated account's timelines, which have
+// no one page.
+func accountURL(key string) string {
+	network, account := splitFeedKey(key)
+	switch network {
+	case networkTrends, networkAccount:
+		return ""
+	case networkMastodon:
+		parts := strings.SplitN(account, "/", 2)
+		if len(parts) == 2 {
+			return fmt.Sprintf("https://%s/@%s", parts[0], parts[1])
+		}
+	case networkBluesky:
+		return "https://bsky.app/profile/" + account
+	case networkSearch:
+		return "https://twitter.com/search?q=" + url.QueryEscape(account)
+	}
+	return "https://twitter.com/" + account
+}
+
+// newsletterSections collects each feed's archived posts from since up to
+// until, oldest first, leaving out feeds with none.
+func newsletterSections(st *store, feeds []string, since time.Time, until time.Time) []newsletterSection {
+	var sections []newsletterSection
+	for _, username := range feeds {
+		section := newsletterSection{Feed: username, URL: accountURL(username)}
+		archived := st.Archived(username)
+		for i := len(archived) - 1; i >= 0; i-- {
+			it := archived[i]
+			if it.CreatedAt.Before(since) || it.CreatedAt.After(until) {
+				continue
+			}
+			section.Items = append(section.Items, it)
+		}
+		if len(section.Items) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+var newsletterTemplate = template.Must(template.New("newsletter").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body style="margin: 0; padding: 1em; background: #f4f5f7; font-family: -apple-system, 'Segoe UI', Helvetica, Arial, sans-serif; color: #1c1e21">
+<div style="max-width: 640px; margin: 0 auto">
+<h1 style="font-size: 1.5em">{{.Title}}</h1>
+{{- range .Sections}}
+<div style="background: #fff; border-radius: 8px; padding: 1em; margin-bottom: 1.5em">
+<h2 style="font-size: 1.2em; margin-top: 0">{{if .URL}}<a href="{{.URL}}" style="color: #1d9bf0; text-decoration: none">{{.Feed}}</a>{{else}}{{.Feed}}{{end}}</h2>
+{{- range .Items}}
+<div style="border-top: 1px solid #e6e8eb; padding: 0.75em 0">
+<p style="margin: 0 0 0.5em; white-space: pre-wrap">{{.Text}}</p>
+{{- range .Media}}
+<img src="{{.URL}}" alt="" style="display: block; max-width: 100%; border-radius: 6px; margin-bottom: 0.5em">
+{{- end}}
+{{- with .Quoted}}
+<blockquote style="margin: 0 0 0.5em; padding: 0.5em 0.75em; border-left: 3px solid #cfd9de; color: #536471">{{.Text}}<br>&mdash; <a href="{{.URL}}" style="color: #536471">@{{.Author.Username}}</a></blockquote>
+{{- end}}
+<small><a href="{{.URL}}" style="color: #536471">{{.CreatedAt.Format "Jan 2 15:04 MST"}}</a></small>
+</div>
+{{- end}}
+</div>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+// renderNewsletter renders sections as a single styled HTML page, suitable
+// for email as well as the browser.
+func renderNewsletter(title string, sections []newsletterSection) ([]byte, error) {
+	var body bytes.Buffer
+	err := newsletterTemplate.Execute(&body, map[string]interface{}{
+		"Title":    title,
+		"Sections": sections,
+	})
+	return body.Bytes(), err
+}
+
+// briefingRange is the period a briefing request covers: ?date= for a
+// single day in loc (today by default), or ?from= and ?to= (today by
+// default) for a longer range.
+func briefingRange(query url.Values, loc *time.Location, now time.Time) (time.Time, time.Time, error) {
+	parse := func(name string, fallback time.Time) (time.Time, error) {
+		value := query.Get(name)
+		if value == "" {
+			return fallback, nil
+		}
+		day, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s must be a date (2006-01-02)", name)
+		}
+		return day, nil
+	}
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	from, err := parse("date", today)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to := from
+	if query.Get("date") == "" {
+		if from, err = parse("from", today); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if to, err = parse("to", today); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+	return from, to.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// BriefingHandler serves a digest's feeds as a newsletter page, for
+// sharing as a daily briefing.
+func BriefingHandler(cfg *config, st *store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		digest, ok := cfg.digest(mux.Vars(r)["digest"])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		loc, _ := time.LoadLocation(digest.Timezone)
+		from, to, err := briefingRange(r.URL.Query(), loc, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		title := fmt.Sprintf("%s briefing for %s", digest.displayName(), from.Format("Jan 2, 2006"))
+		if !sameDay(from, to) {
+			title = fmt.Sprintf("%s briefing for %s to %s", digest.displayName(), from.Format("Jan 2, 2006"), to.Format("Jan 2, 2006"))
+		}
+		body, err := renderNewsletter(title, newsletterSections(st, digest.Feeds, from, to))
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// nitterSource scrapes timelines from a Nitter instance's RSS feeds, so
+// feeds keep working without API credentials or while the API is
+// rate-limiting us.
+type nitterSource struct {
+	instance string
+	client   *http.Client
+}
+
+func newNitterSource(instance string) *nitterSource {
+	return &nitterSource{
+		instance: strings.TrimRight(instance, "/"),
+		client:   audited(&http.Client{Timeout: 30 * time.Second}),
+	}
+}
+
+type nitterRSS struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Image struct {
+			URL string `xml:"url"`
+		} `xml:"image"`
+		Items []nitterItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type nitterItem struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Link        string `xml:"link"`
+	PubDate     string `xml:"pubDate"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+}
+
+var (
+	nitterStatusPath = regexp.MustCompile(`/([^/]+)/status/(\d+)`)
+	nitterImage      = regexp.MustCompile(`<img[^>]+src="([^"]+)"`)
+)
+
+func (s *nitterSource) FetchTimeline(username string, opts fetchOptions) ([]item, error) {
+	path := "/" + url.PathEscape(username) + "/rss"
+	if !opts.ExcludeReplies {
+		path = "/" + url.PathEscape(username) + "/with_replies/rss"
+	}
+	resp, err := feedgen.WithContext(s.client, opts.Context).Get(s.instance + path)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		err = &feedgen.AccountError{Username: username, Reason: feedgen.AccountNotFound}
+	} else if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("nitter: %s", resp.Status)
+	}
+	recordUpstream("nitter_rss", resp, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch nitter timeline")
+	}
+	defer resp.Body.Close()
+
+	body := nitterRSS{}
+	if err := xml.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decode nitter timeline")
+	}
+
+	author := itemAuthor{Username: username, AvatarURL: s.upstreamURL(body.Channel.Image.URL)}
+	if i := strings.Index(body.Channel.Title, " / @"); i >= 0 {
+		author.Name = body.Channel.Title[:i]
+	}
+
+	items := make([]item, 0, len(body.Channel.Items))
+	for _, entry := range body.Channel.Items {
+		it, ok := s.itemFromRSS(author, entry)
+		if !ok {
+			continue
+		}
+		items = append(items, it)
+		if opts.Count > 0 && len(items) == opts.Count {
+			break
+		}
+	}
+	return items, nil
+}
+
+func (s *nitterSource) itemFromRSS(author itemAuthor, entry nitterItem) (item, bool) {
+	match := nitterStatusPath.FindStringSubmatch(entry.Link)
+	if match == nil {
+		return item{}, false
+	}
+	createdAt, err := time.Parse(time.RFC1123, entry.PubDate)
+	if err != nil {
+		createdAt, _ = feedgen.SnowflakeTime(match[2])
+	}
+	// retweets show up under the retweeted account's status URL
+	if !strings.EqualFold(match[1], author.Username) {
+		author = itemAuthor{Username: match[1]}
+	}
+	author.URL = "https://twitter.com/" + author.Username
+	it := item{
+		ID:        match[2],
+		URL:       fmt.Sprintf("https://twitter.com/%s/status/%s", match[1], match[2]),
+		Text:      htmlText(entry.Description),
+		CreatedAt: createdAt.UTC(),
+		Author:    author,
+	}
+	if it.Text == "" {
+		it.Text = entry.Title
+	}
+	for _, img := range nitterImage.FindAllStringSubmatch(entry.Description, -1) {
+		it.Media = append(it.Media, itemMedia{Type: "photo", URL: s.upstreamURL(html.UnescapeString(img[1]))})
+	}
+	return it, true
+}
+
+// upstreamURL maps an instance's /pic/ proxy URL back to the original
+// pbs.twimg.com URL so media doesn't depend on the instance staying up.
+func (s *nitterSource) upstreamURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(u.Path, "/pic/") {
+		return link
+	}
+	original, err := url.PathUnescape(strings.TrimPrefix(u.EscapedPath(), "/pic/"))
+	if err != nil {
+		return link
+	}
+	if strings.HasPrefix(original, "pbs.twimg.com/") {
+		return "https://" + original
+	}
+	return "https://pbs.twimg.com/" + original
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Notification kinds, each delivered by the sender registered for it.
+const (
+	notifyWebhook  = "webhook"
+	notifyTelegram = "telegram"
+	notifyEmail    = "email"
+)
+
+// notification is an outbound delivery waiting to be made. It is kept in
+// the store, so pending and dead-lettered deliveries survive a restart.
+type notification struct {
+	ID string `json:"id"`
+	// Seq orders notifications queued at the same instant.
+	Seq    uint64 `json:"seq"`
+	Kind   string `json:"kind"`
+	Feed   string `json:"feed,omitempty"`
+	Target string `json:"target"`
+	// Payload is what the kind's sender needs to deliver it. It can carry
+	// secrets, so it's left out of whatever is shown to admins.
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	// Dead is set once the delivery has been given up on.
+	Dead bool `json:"dead,omitempty"`
+}
+
+// retryAfterError asks for the next attempt to wait for a time the
+// endpoint gave, such as Telegram's flood control hint.
+type retryAfterError struct {
+	after time.Duration
+	err   error
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+
+var notificationsTotal = newCounterVec("twitterrss_notifications_total",
+	"Notification delivery attempts, by kind and outcome.", "kind", "outcome")
+
+const (
+	notifyRetryBase = 30 * time.Second
+	notifyRetryMax  = 30 * time.Minute
+)
+
+// notifyDelay is how long to wait before retrying after attempts failures.
+func notifyDelay(attempts int) time.Duration {
+	delay := notifyRetryBase
+	for i := 1; i < attempts && delay < notifyRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > notifyRetryMax {
+		delay = notifyRetryMax
+	}
+	return delay
+}
+
+// notifyQueue delivers the notifications kept in the store, retrying
+// failures with backoff until retryFor has passed since they were queued,
+// after which they are dead-lettered for an admin to retry or discard.
+type notifyQueue struct {
+	store    *store
+	retryFor time.Duration
+
+	mu       sync.Mutex
+	senders  map[string]func(n notification) error
+	seq      uint64
+	inFlight map[string]bool
+}
+
+func newNotifyQueue(st *store, retryFor time.Duration) *notifyQueue {
+	return &notifyQueue{
+		store:    st,
+		retryFor: retryFor,
+		senders:  map[string]func(n notification) error{},
+		inFlight: map[string]bool{},
+	}
+}
+
+// Register sets how notifications of kind are delivered. A send error
+// wrapped in backoff.Permanent dead-letters the notification straight away.
+func (q *notifyQueue) Register(kind string, send func(n notification) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.senders[kind] = send
+}
+
+// Enqueue queues payload for delivery to target as soon as possible.
+func (q *notifyQueue) Enqueue(kind string, feed string, target string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrapf(err, "unable to queue %s notification", kind)
+	}
+	now := time.Now()
+	q.mu.Lock()
+	q.seq++
+	seq := q.seq
+	q.mu.Unlock()
+	q.store.PutNotification(notification{
+		ID:          newRequestID(),
+		Seq:         seq,
+		Kind:        kind,
+		Feed:        feed,
+		Target:      target,
+		Payload:     data,
+		CreatedAt:   now,
+		NextAttempt: now,
+	})
+	return nil
+}
+
+// notifyTarget is what notifications are delivered in order to.
+func notifyTarget(n notification) string {
+	return n.Kind + " " + n.Target
+}
+
+// Run attempts due notifications forever. Each target's are delivered one
+// at a time, oldest first, so a chat gets its tweets in order.
+func (q *notifyQueue) Run() {
+	for range time.Tick(time.Second) {
+		byTarget := map[string][]notification{}
+		for _, n := range q.store.DueNotifications(time.Now()) {
+			byTarget[notifyTarget(n)] = append(byTarget[notifyTarget(n)], n)
+		}
+		for target, due := range byTarget {
+			q.mu.Lock()
+			busy := q.inFlight[target]
+			q.inFlight[target] = true
+			q.mu.Unlock()
+			if !busy {
+				go q.deliver(target, due)
+			}
+		}
+	}
+}
+
+// deliver attempts due in order, stopping at the first that fails.
+func (q *notifyQueue) deliver(target string, due []notification) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.inFlight, target)
+		q.mu.Unlock()
+	}()
+	for _, n := range due {
+		if !q.attempt(n) {
+			return
+		}
+	}
+}
+
+// attempt makes one delivery and reports whether it went through.
+func (q *notifyQueue) attempt(n notification) bool {
+	q.mu.Lock()
+	send, ok := q.senders[n.Kind]
+	q.mu.Unlock()
+	var err error
+	if ok {
+		err = send(n)
+	} else {
+		err = backoff.Permanent(fmt.Errorf("no sender is configured for %s notifications", n.Kind))
+	}
+	if err == nil {
+		notificationsTotal.Inc(n.Kind, "delivered")
+		q.store.DeleteNotification(n.ID)
+		return true
+	}
+
+	now := time.Now()
+	n.Attempts++
+	n.LastError = err.Error()
+	n.NextAttempt = now.Add(notifyDelay(n.Attempts))
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.after > 0 {
+		n.NextAttempt = now.Add(retryAfter.after)
+	}
+	var permanent *backoff.PermanentError
+	if errors.As(err, &permanent) || n.NextAttempt.After(n.CreatedAt.Add(q.retryFor)) {
+		n.Dead = true
+		notificationsTotal.Inc(n.Kind, "dead")
+		log.Print(errors.Wrapf(err, "giving up on %s notification to %s", n.Kind, n.Target))
+	} else {
+		notificationsTotal.Inc(n.Kind, "retry")
+	}
+	q.store.ReplaceNotification(n)
+	return false
+}
+
+// Retry gives a dead notification a fresh retry window, starting now.
+func (q *notifyQueue) Retry(id string) bool {
+	n, ok := q.store.Notification(id)
+	if !ok || !n.Dead {
+		return false
+	}
+	now := time.Now()
+	n.Dead = false
+	n.CreatedAt = now
+	n.NextAttempt = now
+	q.store.PutNotification(n)
+	return true
+}
+
+// Discard drops a dead notification.
+func (q *notifyQueue) Discard(id string) bool {
+	n, ok := q.store.Notification(id)
+	if !ok || !n.Dead {
+		return false
+	}
+	q.store.DeleteNotification(id)
+	return true
+}
+
+// Notifications lists the queued notifications, oldest first, without
+// their payloads.
+func (q *notifyQueue) Notifications(dead bool) []notification {
+	list := []notification{}
+	for _, n := range q.store.Notifications() {
+		if n.Dead == dead {
+			n.Payload = nil
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// PutNotification adds or replaces a queued notification.
+func (s *store) PutNotification(n notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications[n.ID] = n
+	s.changed = time.Now()
+}
+
+// ReplaceNotification updates a queued notification, unless it was
+// removed (say by a purge) while it was being delivered.
+func (s *store) ReplaceNotification(n notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.notifications[n.ID]; ok {
+		s.notifications[n.ID] = n
+		s.changed = time.Now()
+	}
+}
+
+func (s *store) DeleteNotification(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.notifications, id)
+	s.changed = time.Now()
+}
+
+func (s *store) Notification(id string) (notification, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n, ok := s.notifications[id]
+	return n, ok
+}
+
+// DueNotifications are the live notifications whose next attempt is due,
+// oldest first. A target's are held back while an older one of its waits
+// for a retry.
+func (s *store) DueNotifications(now time.Time) []notification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var due []notification
+	waiting := map[string]bool{}
+	for _, n := range s.notificationsLocked() {
+		if n.Dead {
+			continue
+		}
+		if waiting[notifyTarget(n)] || n.NextAttempt.After(now) {
+			waiting[notifyTarget(n)] = true
+			continue
+		}
+		due = append(due, n)
+	}
+	return due
+}
+
+// Notifications returns every queued notification, oldest first.
+func (s *store) Notifications() []notification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.notificationsLocked()
+}
+
+func (s *store) notificationsLocked() []notification {
+	list := make([]notification, 0, len(s.notifications))
+	for _, n := range s.notifications {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].Seq < list[j].Seq
+	})
+	return list
+}
+
+// NotificationsHandler lists queued notifications, or with ?dead=true the
+// dead-lettered ones.
+func NotificationsHandler(q *notifyQueue) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(q.Notifications(r.URL.Query().Get("dead") == "true"))
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
+
+// DeadLetterHandler retries or discards a dead notification. Form posts
+// from the dashboard are sent back to it.
+func DeadLetterHandler(q *notifyQueue, audit *auditLog, retry bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		action, ok := "notification.discard", false
+		if retry {
+			action, ok = "notification.retry", q.Retry(id)
+		} else {
+			ok = q.Discard(id)
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := audit.Record(r, action, map[string]string{"id": id}); err != nil {
+			log.Print(errors.Wrapf(err, "unable to audit %s of %s", action, id))
+			http.Error(w, "the notification was updated but couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+			return
+		}
+		jsonBody, err := json.Marshal(map[string]interface{}{"id": id, "retried": retry})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
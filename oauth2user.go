@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// twitterOAuth2TokenURL is where OAuth 2.0 user tokens are refreshed.
+const twitterOAuth2TokenURL = "https://api.twitter.com/2/oauth2/token"
+
+// savedTokenSource refreshes an OAuth 2.0 user token, writing it back to
+// path whenever it changes. Twitter rotates the refresh token on every
+// refresh, so the old one is useless after a restart.
+type savedTokenSource struct {
+	path string
+	next oauth2.TokenSource
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *savedTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.next.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != s.last.RefreshToken || token.AccessToken != s.last.AccessToken {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(s.path, data); err != nil {
+			return nil, errors.Wrap(err, "unable to save refreshed OAuth 2.0 token")
+		}
+		s.last = token
+	}
+	return token, nil
+}
+
+// newOAuth2UserHTTPClient authorizes requests as the account whose OAuth
+// 2.0 user token, with its refresh token, is saved at path. The token is
+// obtained once through Twitter's authorization code flow; clientSecret is
+// only needed for confidential clients.
+func newOAuth2UserHTTPClient(clientID string, clientSecret string, path string) (*http.Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read OAuth 2.0 token")
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse OAuth 2.0 token %s", path)
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.Errorf("OAuth 2.0 token %s has no refresh_token (ask for the offline.access scope)", path)
+	}
+
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: twitterOAuth2TokenURL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+	if clientSecret != "" {
+		config.Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, tokenClient)
+	source := &savedTokenSource{path: path, next: config.TokenSource(ctx, token), last: token}
+	return audited(&http.Client{Transport: &oauth2.Transport{Source: source, Base: twitterEgress}}), nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// oidcKeyRefresh is the least time between JWKS fetches, so tokens with
+// unknown key ids can't make us hammer the issuer.
+const oidcKeyRefresh = time.Minute
+
+// oidcAuth accepts OIDC ID tokens or JWT access tokens from the issuer as
+// bearer tokens. There is no browser login flow: tokens come from the
+// operator's existing tooling, such as a CLI login or an auth proxy.
+type oidcAuth struct {
+	issuer   string
+	audience string
+	subjects map[string]bool
+	emails   map[string]bool
+	client   *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newOIDCAuth(issuer string, audience string, subjects []string, emails []string) (*oidcAuth, error) {
+	if issuer == "" || audience == "" {
+		return nil, fmt.Errorf("admin_auth oidc needs an issuer and audience")
+	}
+	a := &oidcAuth{
+		issuer:   strings.TrimRight(issuer, "/"),
+		audience: audience,
+		subjects: map[string]bool{},
+		emails:   map[string]bool{},
+		client:   audited(&http.Client{Timeout: 30 * time.Second}),
+	}
+	for _, subject := range subjects {
+		a.subjects[subject] = true
+	}
+	for _, email := range emails {
+		a.emails[strings.ToLower(email)] = true
+	}
+	return a, nil
+}
+
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expires       int64           `json:"exp"`
+	NotBefore     int64           `json:"nbf"`
+	Email         string          `json:"email"`
+	EmailVerified *bool           `json:"email_verified"`
+}
+
+func (c oidcClaims) hasAudience(audience string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	json.Unmarshal(c.Audience, &many)
+	for _, aud := range many {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *oidcAuth) Authenticate(r *http.Request) (string, bool) {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if bearer == r.Header.Get("Authorization") {
+		return "", false
+	}
+	claims, err := a.verify(bearer, time.Now())
+	if err != nil {
+		debugf("admin", "token refused: %s", err)
+		return "", false
+	}
+	if len(a.subjects) > 0 || len(a.emails) > 0 {
+		verified := claims.EmailVerified == nil || *claims.EmailVerified
+		if !a.subjects[claims.Subject] && !(verified && a.emails[strings.ToLower(claims.Email)]) {
+			return "", false
+		}
+	}
+	if claims.Email != "" {
+		return claims.Email, true
+	}
+	return claims.Subject, true
+}
+
+func (a *oidcAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="twitterrss"`)
+}
+
+// verify checks a compact JWT's signature and registered claims.
+func (a *oidcAuth) verify(token string, now time.Time) (oidcClaims, error) {
+	claims := oidcClaims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.Wrap(err, "bad signature encoding")
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	leeway := int64(time.Minute / time.Second)
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != a.issuer:
+		return claims, fmt.Errorf("issuer %q not accepted", claims.Issuer)
+	case !claims.hasAudience(a.audience):
+		return claims, errors.New("token not issued for this audience")
+	case claims.Expires == 0 || now.Unix() > claims.Expires+leeway:
+		return claims, errors.New("token expired")
+	case claims.NotBefore != 0 && now.Unix() < claims.NotBefore-leeway:
+		return claims, errors.New("token not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.Wrap(err, "bad JWT encoding")
+	}
+	return errors.Wrap(json.Unmarshal(data, v), "bad JWT")
+}
+
+func verifyJWS(alg string, key crypto.PublicKey, input []byte, signature []byte) error {
+	digest := sha256.Sum256(input)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			break
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+		if !ed25519.Verify(k, input, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q for key", alg)
+}
+
+// key finds the issuer's signing key by id, refetching the JWKS when the
+// id is new, as it is after the issuer rotates keys.
+func (a *oidcAuth) key(kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(a.fetchedAt) < oidcKeyRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	a.fetchedAt = time.Now()
+	if err := a.fetchKeysLocked(); err != nil {
+		return nil, err
+	}
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (a *oidcAuth) getJSON(url string, v interface{}) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *oidcAuth) fetchKeysLocked() error {
+	if a.jwksURL == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return errors.Wrap(err, "unable to discover OIDC issuer")
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("OIDC issuer has no jwks_uri")
+		}
+		a.jwksURL = discovery.JWKSURI
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := a.getJSON(a.jwksURL, &set); err != nil {
+		return errors.Wrap(err, "unable to fetch OIDC keys")
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	a.keys = keys
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			break
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			break
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s %s", k.Kty, k.Crv)
+}
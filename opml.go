@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type opmlOutline struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:"text,attr"`
+	Title    string `xml:"title,attr"`
+	XMLURL   string `xml:"xmlUrl,attr"`
+	HTMLURL  string `xml:"htmlUrl,attr,omitempty"`
+	Category string `xml:"category,attr,omitempty"`
+
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	XMLName     xml.Name      `xml:"opml"`
+	Version     string        `xml:"version,attr"`
+	Title       string        `xml:"head>title"`
+	DateCreated string        `xml:"head>dateCreated"`
+	Outlines    []opmlOutline `xml:"body>outline"`
+}
+
+// baseURL reconstructs the public scheme and host the request was made to.
+func baseURL(r *http.Request) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// renderOPML lists every configured feed, with feed URLs under base.
+func renderOPML(cfg *config, base string) ([]byte, error) {
+	doc := opmlDocument{
+		Version:     "2.0",
+		Title:       "twitterrss feeds",
+		DateCreated: time.Now().Format(time.RFC1123Z),
+	}
+	for _, feed := range cfg.List() {
+		doc.Outlines = append(doc.Outlines, opmlOutline{
+			Type:     "rss",
+			Text:     feed.FeedTitle(),
+			Title:    feed.FeedTitle(),
+			XMLURL:   base + feedPath(feed.Username),
+			HTMLURL:  accountURL(feed.Username),
+			Category: strings.Join(feed.Categories, ","),
+		})
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func OPMLHandler(cfg *config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := renderOPML(cfg, baseURL(r))
+		if err != nil {
+			panic(errors.Wrap(err, "unable to create opml document"))
+		}
+
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+var twitterHosts = map[string]bool{
+	"twitter.com":        true,
+	"www.twitter.com":    true,
+	"mobile.twitter.com": true,
+	"x.com":              true,
+}
+
+// reservedTwitterPaths are top level twitter.com paths that are not accounts.
+var reservedTwitterPaths = map[string]bool{
+	"i": true, "search": true, "hashtag": true, "home": true, "explore": true,
+	"intent": true, "share": true, "settings": true, "notifications": true,
+}
+
+// twitterUsername extracts the account name from a twitter.com URL.
+func twitterUsername(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || !twitterHosts[strings.ToLower(u.Host)] {
+		return "", false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	username := strings.TrimPrefix(segments[0], "@")
+	if username == "" || reservedTwitterPaths[strings.ToLower(username)] {
+		return "", false
+	}
+	return username, true
+}
+
+// outlineFeedKey is the feed key for the account or search an outline
+// follows, read from the page URLs accountURL writes:
+//   - twitter.com accounts and searches;
+//   - Bluesky profiles;
+//   - Mastodon accounts at https://{instance}/@{user}.
+//
+// Other sites use /@user pages too, so a Mastodon page only counts when the
+// outline's feed is that account's own RSS or this service's feed of it.
+func outlineFeedKey(outline opmlOutline) (string, bool) {
+	if u, err := url.Parse(strings.TrimSpace(outline.HTMLURL)); err == nil && u.Host != "" {
+		host := strings.ToLower(u.Host)
+		path := strings.Trim(u.Path, "/")
+		switch {
+		case twitterHosts[host] && path == "search":
+			if query := u.Query().Get("q"); query != "" {
+				return searchFeedKey(query), true
+			}
+		case twitterHosts[host]:
+			if username, ok := twitterUsername(outline.HTMLURL); ok {
+				return username, true
+			}
+		case host == "bsky.app":
+			handle := strings.TrimPrefix(path, "profile/")
+			if handle != path && handle != "" && !strings.Contains(handle, "/") {
+				return networkBluesky + "/" + handle, true
+			}
+		case len(path) > 1 && path[0] == '@' && !strings.Contains(path, "/"):
+			user := path[1:]
+			feed, err := url.Parse(strings.TrimSpace(outline.XMLURL))
+			if err == nil && ((strings.EqualFold(feed.Host, host) && feed.Path == "/@"+user+".rss") ||
+				strings.HasSuffix(feed.Path, fmt.Sprintf("/feed/mastodon/%s/%s.xml", host, user))) {
+				return networkMastodon + "/" + host + "/" + user, true
+			}
+		}
+	}
+	return twitterUsername(outline.XMLURL)
+}
+
+// feedsFromOPML derives feed configs from every outline pointing at an
+// account or search outlineFeedKey knows. Parent outline names become
+// categories.
+func feedsFromOPML(data []byte) ([]feedConfig, error) {
+	doc := opmlDocument{}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "unable to parse opml")
+	}
+
+	var found []feedConfig
+	var walk func(outlines []opmlOutline, categories []string)
+	walk = func(outlines []opmlOutline, categories []string) {
+		for _, outline := range outlines {
+			if len(outline.Outlines) > 0 {
+				walk(outline.Outlines, append(append([]string(nil), categories...), outline.Text))
+				continue
+			}
+			username, ok := outlineFeedKey(outline)
+			if !ok {
+				continue
+			}
+			title := outline.Title
+			if title == "" {
+				title = outline.Text
+			}
+			found = append(found, feedConfig{Username: username, Title: title, Categories: categories})
+		}
+	}
+	walk(doc.Outlines, nil)
+	return found, nil
+}
+
+// importOPML adds every account in the OPML document to the config
+// and returns the feeds that were new.
+func importOPML(cfg *config, status *feedStatus, data []byte) ([]feedConfig, error) {
+	found, err := feedsFromOPML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []feedConfig
+	for _, feed := range found {
+		if cfg.Add(feed) {
+			status.Add(feed.Username)
+			log.Printf("Added %s from OPML", feedPath(feed.Username))
+			added = append(added, feed)
+		}
+	}
+	return added, nil
+}
+
+func importOPMLFile(cfg *config, status *feedStatus, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to read opml")
+	}
+	_, err = importOPML(cfg, status, data)
+	return err
+}
+
+// OPMLImportHandler accepts an uploaded OPML document and starts serving
+// every account found in it.
+func OPMLImportHandler(cfg *config, status *feedStatus, audit *auditLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+		if err != nil {
+			http.Error(w, "unable to read request body", http.StatusBadRequest)
+			return
+		}
+
+		added, err := importOPML(cfg, status, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := audit.Record(r, "opml.import", map[string]string{"added": strconv.Itoa(len(added))}); err != nil {
+			log.Print(errors.Wrap(err, "unable to audit OPML import"))
+			http.Error(w, "the feeds were imported but the import couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+
+		jsonBody, err := json.Marshal(map[string][]feedConfig{"added": added})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOPMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+		want string
+	}{
+		{"twitter account", "alice", "alice"},
+		{"mastodon account", "mastodon/mastodon.social/bob", "mastodon/mastodon.social/bob"},
+		{"bluesky account", "bsky/carol.bsky.social", "bsky/carol.bsky.social"},
+		{"twitter search", "search/golang news", "search/golang news"},
+		{"trends aren't accounts", "trends/1", ""},
+		{"home timelines aren't accounts", "me/home", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config{}
+			cfg.addUsernames([]string{test.feed})
+			data, err := renderOPML(cfg, "https://rss.example")
+			if err != nil {
+				t.Fatal(err)
+			}
+			found, err := feedsFromOPML(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, feed := range found {
+				got = append(got, feed.Username)
+			}
+			var want []string
+			if test.want != "" {
+				want = []string{test.want}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("imported %q, want %q\n%s", got, want, data)
+			}
+		})
+	}
+}
+
+func TestOutlineFeedKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		outline opmlOutline
+		want    string
+		wantOK  bool
+	}{
+		{
+			"twitter profile",
+			opmlOutline{HTMLURL: "https://twitter.com/alice"},
+			"alice", true,
+		},
+		{
+			"twitter search",
+			opmlOutline{HTMLURL: "https://twitter.com/search?q=golang"},
+			"search/golang", true,
+		},
+		{
+			"bluesky profile",
+			opmlOutline{HTMLURL: "https://bsky.app/profile/carol.bsky.social"},
+			"bsky/carol.bsky.social", true,
+		},
+		{
+			"mastodon account rss",
+			opmlOutline{HTMLURL: "https://fosstodon.org/@bob", XMLURL: "https://fosstodon.org/@bob.rss"},
+			"mastodon/fosstodon.org/bob", true,
+		},
+		{
+			"medium writer",
+			opmlOutline{HTMLURL: "https://medium.com/@bob", XMLURL: "https://medium.com/feed/@bob"},
+			"", false,
+		},
+		{
+			"feed url only",
+			opmlOutline{XMLURL: "https://twitter.com/alice"},
+			"alice", true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := outlineFeedKey(test.outline)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("outlineFeedKey = %q, %v, want %q, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// outboundEntry records one call to an upstream API.
+type outboundEntry struct {
+	At         time.Time         `json:"at"`
+	Method     string            `json:"method"`
+	Host       string            `json:"host"`
+	Path       string            `json:"path"`
+	Params     map[string]string `json:"params,omitempty"`
+	Status     int               `json:"status,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	LatencyMS  int64             `json:"latency_ms"`
+	RateLimits map[string]string `json:"rate_limits,omitempty"`
+	// Payload is the start of the response body, on sampled calls.
+	Payload string `json:"payload,omitempty"`
+}
+
+// outboundLog keeps the most recent upstream calls in memory, capturing the
+// response payload of a sample of them.
+type outboundLog struct {
+	mu           sync.Mutex
+	size         int
+	sampleRate   float64
+	payloadBytes int
+	entries      []outboundEntry
+}
+
+// upstreamLog records the calls made by every timeline source.
+var upstreamLog = &outboundLog{size: 500, payloadBytes: 4096}
+
+// Configure sets how many calls are kept and what fraction of them have
+// their payload captured.
+func (l *outboundLog) Configure(size int, sampleRate float64, payloadBytes int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size = size
+	l.sampleRate = sampleRate
+	l.payloadBytes = payloadBytes
+}
+
+func (l *outboundLog) record(entry outboundEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+}
+
+func (l *outboundLog) sample() (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sampleRate > 0 && rand.Float64() < l.sampleRate, l.payloadBytes
+}
+
+// Entries returns the retained calls, oldest first.
+func (l *outboundLog) Entries() []outboundEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]outboundEntry{}, l.entries...)
+}
+
+// redactedParam reports whether a query parameter could hold a credential.
+func redactedParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "token") || strings.Contains(name, "secret") || strings.Contains(name, "key")
+}
+
+// auditTransport records each request it sends in upstreamLog. It wraps
+// outside any auth transport, so credentials never reach the log.
+type auditTransport struct {
+	next http.RoundTripper
+}
+
+// audited returns client with its requests recorded in upstreamLog and
+// traced.
+func audited(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &traceTransport{next: &auditTransport{next: next}}
+	return client
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := outboundEntry{At: time.Now(), Method: req.Method, Host: req.URL.Host, Path: req.URL.Path}
+	for name, values := range req.URL.Query() {
+		if entry.Params == nil {
+			entry.Params = map[string]string{}
+		}
+		value := strings.Join(values, ",")
+		if redactedParam(name) {
+			value = "REDACTED"
+		}
+		entry.Params[name] = value
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	entry.LatencyMS = time.Since(entry.At).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+		upstreamLog.record(entry)
+		return resp, err
+	}
+
+	entry.Status = resp.StatusCode
+	for name, values := range resp.Header {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "rate-limit") || strings.Contains(lower, "ratelimit") {
+			if entry.RateLimits == nil {
+				entry.RateLimits = map[string]string{}
+			}
+			entry.RateLimits[lower] = strings.Join(values, ",")
+		}
+	}
+	if sampled, limit := upstreamLog.sample(); sampled {
+		// read the start of the body and hand the caller all of it back
+		prefix, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)))
+		entry.Payload = string(prefix)
+		if readErr != nil {
+			entry.Error = readErr.Error()
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), resp.Body), resp.Body}
+	}
+	upstreamLog.record(entry)
+	return resp, nil
+}
+
+// OutboundHandler lists the recorded upstream calls, newest first. ?host=
+// narrows them to one API and ?limit= caps how many are returned.
+func OutboundHandler(l *outboundLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if query.Get("limit") != "" && (err != nil || limit < 1) {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+
+		entries := []outboundEntry{}
+		all := l.Entries()
+		for i := len(all) - 1; i >= 0; i-- {
+			if host := query.Get("host"); host != "" && !strings.EqualFold(all[i].Host, host) {
+				continue
+			}
+			entries = append(entries, all[i])
+			if len(entries) == limit {
+				break
+			}
+		}
+
+		jsonBody, err := json.Marshal(entries)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
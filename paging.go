@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// archivePageSize is how many items each archive page holds.
+const archivePageSize = timelineSize
+
+// archivePageLifetime is how long readers may cache an archive page.
+const archivePageLifetime = 24 * time.Hour
+
+// archivePage returns page of archived, newest first as stored, along with
+// how many complete pages there are. Pages are numbered from the oldest
+// items, so a page keeps its contents as newer tweets are archived.
+func archivePage(archived []item, page int) ([]item, int) {
+	pages := len(archived) / archivePageSize
+	if page < 1 || page > pages {
+		return nil, pages
+	}
+	// the oldest page ends the list
+	end := len(archived) - (page-1)*archivePageSize
+	return archived[end-archivePageSize : end], pages
+}
+
+func archivePageURL(r *http.Request, username string, page int) string {
+	return fmt.Sprintf("%s%s?page=%d", baseURL(r), feedPath(username), page)
+}
+
+// archiveLinks are the RFC 5005 links of page, where page 0 is the
+// subscription feed.
+func archiveLinks(r *http.Request, username string, page int, pages int) []atomLink {
+	feedType := "application/rss+xml"
+	if page == 0 {
+		if pages == 0 {
+			return nil
+		}
+		return []atomLink{{Rel: "prev-archive", Href: archivePageURL(r, username, pages), Type: feedType}}
+	}
+	links := []atomLink{{Rel: "current", Href: baseURL(r) + feedPath(username), Type: feedType}}
+	if page > 1 {
+		links = append(links, atomLink{Rel: "prev-archive", Href: archivePageURL(r, username, page-1), Type: feedType})
+	}
+	if page < pages {
+		links = append(links, atomLink{Rel: "next-archive", Href: archivePageURL(r, username, page+1), Type: feedType})
+	}
+	return links
+}
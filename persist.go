@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// persister writes the store to disk and optionally ships each checkpoint to
+// S3. Checkpoints are written to a temporary file and renamed into place, so
+// the file on disk is always a complete snapshot and is safe to copy or
+// replicate while the service keeps running.
+type persister struct {
+	mu       sync.Mutex
+	path     string
+	store    *store
+	s3       *s3Client
+	s3Key    string
+	lastSave time.Time
+}
+
+// checkpointResult describes a completed checkpoint.
+type checkpointResult struct {
+	Path     string    `json:"path"`
+	Bytes    int       `json:"bytes"`
+	Uploaded bool      `json:"uploaded"`
+	At       time.Time `json:"at"`
+}
+
+// RestoreIfMissing downloads the last backup from S3 when there is no local
+// store file, so a fresh host boots with the archive intact.
+func (p *persister) RestoreIfMissing() error {
+	if _, err := os.Stat(p.path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	if p.s3 == nil {
+		return nil
+	}
+
+	data, err := p.s3.Get(p.s3Key)
+	if os.IsNotExist(err) {
+		log.Printf("No backup found at s3://%s/%s, starting empty", p.s3.bucket, p.s3Key)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to download backup")
+	}
+	log.Printf("Restoring store from s3://%s/%s", p.s3.bucket, p.s3Key)
+	return writeFileAtomic(p.path, data)
+}
+
+// Load reads the store file into the store if it exists.
+func (p *persister) Load() error {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read store")
+	}
+
+	snapshot := &storeSnapshot{}
+	if isLegacySnapshot(data) {
+		legacy := &legacyStoreSnapshot{}
+		if err := json.Unmarshal(data, legacy); err != nil {
+			return errors.Wrap(err, "unable to parse store")
+		}
+		log.Printf("Converting %s from tweets to items", p.path)
+		snapshot = legacy.migrate()
+	} else if err := json.Unmarshal(data, snapshot); err != nil {
+		return errors.Wrap(err, "unable to parse store")
+	}
+	p.store.Restore(snapshot)
+	return nil
+}
+
+// Checkpoint writes the current store to disk and uploads it to S3.
+func (p *persister) Checkpoint() (*checkpointResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := json.Marshal(p.store.Snapshot())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to serialise store")
+	}
+	if err := writeFileAtomic(p.path, data); err != nil {
+		return nil, err
+	}
+
+	result := &checkpointResult{Path: p.path, Bytes: len(data), At: time.Now()}
+	if p.s3 != nil {
+		if err := p.s3.Put(p.s3Key, data, "application/json"); err != nil {
+			return result, errors.Wrap(err, "unable to upload backup")
+		}
+		result.Uploaded = true
+	}
+	p.lastSave = result.At
+	return result, nil
+}
+
+// Run checkpoints on an interval whenever the store has changed.
+func (p *persister) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		p.mu.Lock()
+		lastSave := p.lastSave
+		p.mu.Unlock()
+		if !p.store.ChangedSince(lastSave) {
+			continue
+		}
+		if _, err := p.Checkpoint(); err != nil {
+			log.Print(errors.Wrap(err, "checkpoint failed"))
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary store file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write store")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to sync store")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to write store")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "unable to replace store")
+}
+
+// CheckpointHandler flushes the store on demand, e.g. before a replication
+// tool takes a snapshot or before shutting a host down. A failed write or
+// upload is a 500 carrying the error and whatever of the checkpoint was
+// done, so a caller can tell a saved store that wasn't backed up from one
+// that wasn't saved at all.
+func CheckpointHandler(p *persister) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		var response interface{}
+		result, err := p.Checkpoint()
+		if err != nil {
+			log.Print(errors.Wrap(err, "checkpoint failed"))
+			status = http.StatusInternalServerError
+			response = struct {
+				*checkpointResult
+				Error string `json:"error"`
+			}{result, err.Error()}
+		} else {
+			response = result
+		}
+
+		jsonBody, err := json.Marshal(response)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,85 @@
+package feedgen
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// Why an account's timeline can't be read, as AccountError.Reason.
+const (
+	AccountNotFound  = "not_found"
+	AccountSuspended = "suspended"
+	AccountProtected = "protected"
+)
+
+// AccountError is returned by sources when the account itself, rather than
+// the API, stops its timeline being read. Trying another source won't help.
+type AccountError struct {
+	Username string
+	Reason   string
+}
+
+func (e *AccountError) Error() string {
+	switch e.Reason {
+	case AccountSuspended:
+		return fmt.Sprintf("@%s is suspended", e.Username)
+	case AccountProtected:
+		return fmt.Sprintf("@%s's posts are protected", e.Username)
+	}
+	return fmt.Sprintf("@%s doesn't exist", e.Username)
+}
+
+// v1.1 error codes that describe the account.
+const (
+	v1PageNotFound  = 34
+	v1UserNotFound  = 50
+	v1UserSuspended = 63
+)
+
+// v1AccountError is err as an AccountError, if the v1.1 API blamed the
+// account. Protected timelines are refused with a 401 carrying no error
+// codes, which go-twitter doesn't report as an error at all.
+func v1AccountError(username string, resp *http.Response, err error) error {
+	if apiErr, ok := err.(twitter.APIError); ok {
+		for _, detail := range apiErr.Errors {
+			switch detail.Code {
+			case v1PageNotFound, v1UserNotFound:
+				return &AccountError{Username: username, Reason: AccountNotFound}
+			case v1UserSuspended:
+				return &AccountError{Username: username, Reason: AccountSuspended}
+			}
+		}
+	}
+	if err == nil && resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return &AccountError{Username: username, Reason: AccountProtected}
+	}
+	return err
+}
+
+// v2 problem types that describe the account.
+const (
+	v2ResourceNotFound      = "https://api.twitter.com/2/problems/resource-not-found"
+	v2NotAuthorizedResource = "https://api.twitter.com/2/problems/not-authorized-for-resource"
+)
+
+// v2AccountError is err as an AccountError, if the v2 API blamed the
+// account. Suspended accounts are reported as missing, with a detail
+// saying why.
+func v2AccountError(username string, err error) error {
+	problem, ok := err.(*TwitterV2Error)
+	if !ok {
+		return err
+	}
+	switch {
+	case strings.Contains(problem.Detail, "suspended"):
+		return &AccountError{Username: username, Reason: AccountSuspended}
+	case problem.Type == v2ResourceNotFound:
+		return &AccountError{Username: username, Reason: AccountNotFound}
+	case problem.Type == v2NotAuthorizedResource:
+		return &AccountError{Username: username, Reason: AccountProtected}
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+// Package feedgen fetches timelines and renders them as RSS, Atom or JSON
+// feeds. It is the core of twitterrss, for Go programs that want feeds
+// without running the server:
+//
+//	gen, err := feedgen.New(feedgen.Config{ConsumerKey: key, ConsumerSecret: secret})
+//	rss, err := gen.Feed(ctx, "golang", feedgen.FeedOptions{})
+package feedgen
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// TwitterTokenURL is where app credentials are exchanged for a bearer
+// token.
+const TwitterTokenURL = "https://api.twitter.com/oauth2/token"
+
+// Twitter API versions Config.APIVersion can pick.
+const (
+	APIv1 = "1.1"
+	APIv2 = "2"
+)
+
+// Formats a feed can be rendered as.
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+	FormatJSON = "json"
+)
+
+// DefaultCount is how many posts a feed carries unless asked otherwise.
+const DefaultCount = 20
+
+// Config sets up a Generator.
+type Config struct {
+	// ConsumerKey and ConsumerSecret are the Twitter app's credentials,
+	// exchanged for an app-only bearer token.
+	ConsumerKey    string
+	ConsumerSecret string
+	// APIVersion is the Twitter API timelines are read from, APIv1 unless
+	// set.
+	APIVersion string
+	// HTTPClient, if set, makes the API calls instead and must authorize
+	// them itself, say with a user context token.
+	HTTPClient *http.Client
+	// Source, if set, is used instead of the Twitter API.
+	Source Source
+	// OnResponse, if set, is told of every Twitter API call.
+	OnResponse ResponseHook
+}
+
+// Generator renders feeds of the timelines its source fetches.
+type Generator struct {
+	source Source
+}
+
+// New returns a Generator reading from the Twitter API as cfg describes.
+func New(cfg Config) (*Generator, error) {
+	if cfg.Source != nil {
+		return &Generator{source: cfg.Source}, nil
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		if cfg.ConsumerKey == "" || cfg.ConsumerSecret == "" {
+			return nil, fmt.Errorf("feedgen: ConsumerKey and ConsumerSecret are required")
+		}
+		credentials := &clientcredentials.Config{
+			ClientID:     cfg.ConsumerKey,
+			ClientSecret: cfg.ConsumerSecret,
+			TokenURL:     TwitterTokenURL,
+		}
+		client = credentials.Client(context.Background())
+	}
+	switch cfg.APIVersion {
+	case "", APIv1:
+		source := NewTwitterV1Source(client)
+		source.OnResponse = cfg.OnResponse
+		return &Generator{source: source}, nil
+	case APIv2:
+		source := NewTwitterV2Source(client)
+		source.OnResponse = cfg.OnResponse
+		return &Generator{source: source}, nil
+	}
+	return nil, fmt.Errorf("feedgen: unsupported Twitter API version %q", cfg.APIVersion)
+}
+
+// FeedOptions tune a feed.
+type FeedOptions struct {
+	// Format is FormatRSS unless set.
+	Format string
+	// Count is the most posts the feed carries, DefaultCount unless set.
+	Count int
+	// IncludeReplies keeps replies to other accounts.
+	IncludeReplies bool
+	// Title is "<username> tweets" unless set.
+	Title string
+	// Link is the feed's own URL.
+	Link string
+}
+
+// Items fetches username's recent posts.
+func (g *Generator) Items(ctx context.Context, username string, opts FeedOptions) ([]Item, error) {
+	count := opts.Count
+	if count <= 0 {
+		count = DefaultCount
+	}
+	return g.source.FetchTimeline(username, FetchOptions{Count: count, ExcludeReplies: !opts.IncludeReplies, Context: ctx})
+}
+
+// Feed fetches username's recent posts and renders them.
+func (g *Generator) Feed(ctx context.Context, username string, opts FeedOptions) ([]byte, error) {
+	items, err := g.Items(ctx, username, opts)
+	if err != nil {
+		return nil, err
+	}
+	doc := Build(username, items, opts)
+	var rendered string
+	switch opts.Format {
+	case "", FormatRSS:
+		rendered, err = RenderRSS(doc, nil)
+	case FormatAtom:
+		rendered, err = RenderAtom(doc)
+	case FormatJSON:
+		rendered, err = doc.ToJSON()
+	default:
+		return nil, fmt.Errorf("feedgen: unknown format %q", opts.Format)
+	}
+	return []byte(rendered), err
+}
+
+// Build makes username's feed of items.
+func Build(username string, items []Item, opts FeedOptions) *Document {
+	title := opts.Title
+	if title == "" {
+		title = fmt.Sprintf("%s tweets", username)
+	}
+	doc := NewDocument(&feeds.Feed{
+		Title:       title,
+		Link:        &feeds.Link{Href: opts.Link},
+		Description: fmt.Sprintf("%s tweets", username),
+		Author:      &feeds.Author{Name: "https://github.com/halkeye/twitterrss"},
+		Created:     time.Now().UTC(),
+	})
+	for _, it := range items {
+		doc.Items = append(doc.Items, &feeds.Item{
+			Id:          it.ID,
+			Title:       it.FeedTitle(),
+			Link:        &feeds.Link{Href: it.URL},
+			Description: ItemHTML(it),
+			Created:     it.CreatedAt.UTC(),
+		})
+		doc.Annotate(it)
+	}
+	return doc
+}
+
+// Attribution names the author, e.g. "Jane Doe (@jane)".
+func (a Author) Attribution() string {
+	if a.Name != "" {
+		return fmt.Sprintf("%s (@%s)", a.Name, a.Username)
+	}
+	return "@" + a.Username
+}
+
+// ItemHTML is the item body for feeds: its text and any poll, followed by
+// any quoted post as a blockquote so the item reads without clicking
+// through, and thumbnails of its media.
+func ItemHTML(it Item) string {
+	description := it.TextHTML() + pollHTML(it.Poll)
+	if quoted := it.Quoted; quoted != nil {
+		description += fmt.Sprintf("\n<blockquote>%s<br>&mdash; %s <a href=\"%s\">%s</a></blockquote>",
+			quoted.TextHTML(), html.EscapeString(quoted.Author.Attribution()),
+			html.EscapeString(quoted.URL), html.EscapeString(quoted.URL))
+	}
+	return description + mediaHTML(it.Media)
+}
+
+// mediaHTML links a lazily loaded thumbnail of each media to the full size
+// file. Sized images let readers lay the item out before they load. Alt
+// text goes in the image's alt and title and in a caption below it, as
+// readers that drop attributes still show the caption.
+func mediaHTML(media []Media) string {
+	var b strings.Builder
+	for _, m := range media {
+		src := m.ThumbnailURL
+		if src == "" {
+			src = m.URL
+		}
+		alt := html.EscapeString(m.AltText)
+		b.WriteString("\n")
+		if alt != "" {
+			b.WriteString("<figure>")
+		}
+		fmt.Fprintf(&b, "<a href=\"%s\"><img src=\"%s\" alt=\"%s\"", html.EscapeString(m.URL), html.EscapeString(src), alt)
+		if alt != "" {
+			fmt.Fprintf(&b, " title=\"%s\"", alt)
+		}
+		if m.Width > 0 && m.Height > 0 {
+			fmt.Fprintf(&b, " width=\"%d\" height=\"%d\"", m.Width, m.Height)
+		}
+		b.WriteString(` loading="lazy" decoding="async"></a>`)
+		if alt != "" {
+			fmt.Fprintf(&b, "<figcaption>%s</figcaption></figure>", alt)
+		}
+	}
+	return b.String()
+}
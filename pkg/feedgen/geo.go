@@ -0,0 +1,25 @@
+package feedgen
+
+import "fmt"
+
+// Geo is where a post was made: exact coordinates when the author shared
+// them, otherwise the centre of the tagged place.
+type Geo struct {
+	Lat   float64 `json:"lat"`
+	Long  float64 `json:"long"`
+	Place string  `json:"place,omitempty"`
+}
+
+// Point is the location as a GeoRSS "lat long" pair.
+func (g Geo) Point() string {
+	return fmt.Sprintf("%g %g", g.Lat, g.Long)
+}
+
+// boundingBoxCentre is the centre of a place's bounding box, given as
+// [west, south, east, north] in degrees.
+func boundingBoxCentre(bbox []float64) (Geo, bool) {
+	if len(bbox) != 4 {
+		return Geo{}, false
+	}
+	return Geo{Lat: (bbox[1] + bbox[3]) / 2, Long: (bbox[0] + bbox[2]) / 2}, true
+}
@@ -0,0 +1,192 @@
+package feedgen
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Author is the account that posted an item.
+type Author struct {
+	Username  string `json:"username"`
+	Name      string `json:"name,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	// URL is the account's profile page.
+	URL string `json:"url,omitempty"`
+}
+
+// Media is a photo, video or GIF attached to an item. For videos URL is
+// the still preview image.
+type Media struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// ThumbnailURL is a small rendition for item HTML, Width and Height its
+	// size when the source reports one.
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	// AltText is the author's description of the media, if they wrote one.
+	AltText string `json:"alt_text,omitempty"`
+}
+
+// ThumbnailSize is the longest side of the thumbnails sources are asked
+// for, matching Twitter's "small" rendition.
+const ThumbnailSize = 680
+
+// FitThumbnail scales width and height down to fit within ThumbnailSize.
+func FitThumbnail(width int, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	longest := width
+	if height > longest {
+		longest = height
+	}
+	if longest <= ThumbnailSize {
+		return width, height
+	}
+	return width * ThumbnailSize / longest, height * ThumbnailSize / longest
+}
+
+// twitterThumbnail is the small rendition of a pbs.twimg.com image.
+func twitterThumbnail(mediaURL string) string {
+	if !strings.HasPrefix(mediaURL, "https://pbs.twimg.com/") || strings.Contains(mediaURL, "?") {
+		return ""
+	}
+	return mediaURL + "?name=small"
+}
+
+// Metrics are a post's public engagement counts when it was fetched.
+type Metrics struct {
+	Retweets int `json:"retweets"`
+	Likes    int `json:"likes"`
+	Replies  int `json:"replies"`
+	Quotes   int `json:"quotes"`
+}
+
+// Item is a post as returned by a timeline source. Everything downstream of
+// the sources - the store, feed rendering and integrations - works from
+// items, so it doesn't matter which API they came from.
+type Item struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	Author    Author    `json:"author"`
+	Media     []Media   `json:"media,omitempty"`
+	// InReplyToID and InReplyToUser identify the post this one replies to.
+	InReplyToID   string `json:"in_reply_to_id,omitempty"`
+	InReplyToUser string `json:"in_reply_to_user,omitempty"`
+	// Quoted is the post this one quotes, one level deep.
+	Quoted *Item `json:"quoted,omitempty"`
+	// RetweetOf is the id of the original post when this is a retweet.
+	RetweetOf string   `json:"retweet_of,omitempty"`
+	Metrics   *Metrics `json:"metrics,omitempty"`
+	// Hashtags are the post's hashtags, without the #.
+	Hashtags []string `json:"hashtags,omitempty"`
+	// Lang is the BCP 47 language the source detected, if any.
+	Lang string `json:"lang,omitempty"`
+	// Geo is set on geotagged posts.
+	Geo *Geo `json:"geo,omitempty"`
+	// Poll is set on posts with a poll.
+	Poll *Poll `json:"poll,omitempty"`
+	// Title is set on items that aren't posts, such as trends, whose ids
+	// make poor titles.
+	Title string `json:"title,omitempty"`
+	// HTML is Text as markup, for items whose links were added here from
+	// escaped text. Text from sources is plain and always escaped.
+	HTML string `json:"html,omitempty"`
+	// DeletedAt is when the post was found to have been deleted, for
+	// archived items.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// FeedTitle is the item's title in feeds: its Title, or else its id.
+func (it Item) FeedTitle() string {
+	if it.Title != "" {
+		return it.Title
+	}
+	return it.ID
+}
+
+// TextHTML is the item's text as HTML: its HTML if set, or else its Text
+// escaped.
+func (it Item) TextHTML() string {
+	if it.HTML != "" {
+		return it.HTML
+	}
+	return html.EscapeString(it.Text)
+}
+
+// twitterEpoch is when Twitter's snowflake ids start counting, in
+// milliseconds since the Unix epoch.
+const twitterEpoch = 1288834974657
+
+// SnowflakeTime is when the post with Twitter id was created, which the id
+// encodes, for posts whose timestamp is missing or unreadable. Ids from
+// before snowflakes (late 2010) don't encode one.
+func SnowflakeTime(id string) (time.Time, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n>>22 == 0 {
+		return time.Time{}, false
+	}
+	ms := n>>22 + twitterEpoch
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC(), true
+}
+
+// SelfReply reports whether it continues a thread by its own author.
+func (it Item) SelfReply() bool {
+	return it.InReplyToID != "" && strings.EqualFold(it.InReplyToUser, it.Author.Username)
+}
+
+// newer orders items newest first, falling back to the id (Twitter ids
+// grow over time) when timestamps tie.
+func newer(a Item, b Item) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	if len(a.ID) != len(b.ID) {
+		return len(a.ID) > len(b.ID)
+	}
+	return a.ID > b.ID
+}
+
+// SortItems sorts items newest first.
+func SortItems(items []Item) {
+	sort.Slice(items, func(i, j int) bool { return newer(items[i], items[j]) })
+}
+
+// AsRetweet turns it into a retweet of original: the full original text
+// attributed to its author, linking to the original post. Twitter truncates
+// the retweet's own text, so it isn't used.
+func (it *Item) AsRetweet(original Item) {
+	it.RetweetOf = original.ID
+	it.Text = fmt.Sprintf("RT @%s: %s", original.Author.Username, original.Text)
+	it.URL = original.URL
+	it.Media = original.Media
+	it.Quoted = original.Quoted
+	it.Geo = original.Geo
+	it.Poll = original.Poll
+}
+
+// DedupeKey is the same for a post and every retweet of it.
+func (it Item) DedupeKey() string {
+	if it.RetweetOf != "" {
+		return it.RetweetOf
+	}
+	return it.ID
+}
+
+// isQuoteLink reports whether link points at the status with id, as the
+// link Twitter appends to a quote tweet's text does.
+func isQuoteLink(link string, id string) bool {
+	return strings.HasSuffix(strings.TrimRight(link, "/"), "/status/"+id)
+}
+
+// stripLink removes link from text, tidying the space left behind.
+func stripLink(text string, link string) string {
+	return strings.TrimSpace(strings.Replace(text, link, "", -1))
+}
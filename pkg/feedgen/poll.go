@@ -0,0 +1,60 @@
+package feedgen
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Poll is a poll attached to a post, with the votes when it was fetched.
+type Poll struct {
+	Options []PollOption `json:"options"`
+	// EndsAt is when voting closes, or closed.
+	EndsAt time.Time `json:"ends_at,omitempty"`
+	Closed bool      `json:"closed"`
+}
+
+// PollOption is one of a poll's choices.
+type PollOption struct {
+	Label string `json:"label"`
+	Votes int    `json:"votes"`
+}
+
+// TotalVotes is the votes cast across every option.
+func (p Poll) TotalVotes() int {
+	total := 0
+	for _, option := range p.Options {
+		total += option.Votes
+	}
+	return total
+}
+
+// pollHTML lists the options with their share of the votes, and whether
+// the poll is still open.
+func pollHTML(p *Poll) string {
+	if p == nil || len(p.Options) == 0 {
+		return ""
+	}
+	total := p.TotalVotes()
+	var b strings.Builder
+	b.WriteString("\n<ul>")
+	for _, option := range p.Options {
+		share := 0
+		if total > 0 {
+			share = option.Votes * 100 / total
+		}
+		fmt.Fprintf(&b, "<li>%s: %d%% (%d)</li>", html.EscapeString(option.Label), share, option.Votes)
+	}
+	b.WriteString("</ul>\n<p>")
+	switch {
+	case p.Closed:
+		fmt.Fprintf(&b, "Final results, %d votes", total)
+	case !p.EndsAt.IsZero():
+		fmt.Fprintf(&b, "%d votes so far, open until %s", total, p.EndsAt.UTC().Format("Jan 2, 2006 15:04 MST"))
+	default:
+		fmt.Fprintf(&b, "%d votes so far", total)
+	}
+	b.WriteString("</p>")
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package feedgen
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// Document is a feed along with what gorilla's feed model can't hold.
+type Document struct {
+	*feeds.Feed
+	// Categories are each item's categories, by item id.
+	Categories map[string][]string
+	// Locations are where geotagged items were posted, by item id.
+	Locations map[string]Geo
+	// Media are each item's media, by item id, for Media RSS.
+	Media map[string][]Media
+	// TTL is how long readers should wait before polling again.
+	TTL time.Duration
+	// Archive marks an RFC 5005 archive page, whose items won't change.
+	Archive bool
+	// Generator names the software that rendered the feed.
+	Generator string
+}
+
+// NewDocument wraps feed, whose items are added with Annotate as well.
+func NewDocument(feed *feeds.Feed) *Document {
+	return &Document{Feed: feed, Categories: map[string][]string{}, Locations: map[string]Geo{}, Media: map[string][]Media{}}
+}
+
+// Annotate records what the feed's rendering of it needs beyond the
+// gorilla item.
+func (d *Document) Annotate(it Item) {
+	if len(it.Hashtags) > 0 {
+		d.Categories[it.ID] = it.Hashtags
+	}
+	if it.Geo != nil {
+		d.Locations[it.ID] = *it.Geo
+	}
+	if len(it.Media) > 0 {
+		d.Media[it.ID] = it.Media
+	}
+}
+
+const (
+	// HistoryNamespace is RFC 5005's, for archive pages and their links.
+	HistoryNamespace = "http://purl.org/syndication/history/1.0"
+
+	geoRSSNamespace   = "http://www.georss.org/georss"
+	mediaRSSNamespace = "http://search.yahoo.com/mrss/"
+	w3cGeoNamespace   = "http://www.w3.org/2003/01/geo/wgs84_pos#"
+)
+
+// AtomLink is an <atom:link> element inside an RSS channel.
+type AtomLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+}
+
+// mediaContent is a Media RSS <media:content> element.
+type mediaContent struct {
+	XMLName     xml.Name `xml:"media:content"`
+	URL         string   `xml:"url,attr"`
+	Medium      string   `xml:"medium,attr,omitempty"`
+	Description string   `xml:"media:description,omitempty"`
+}
+
+// mediaMedium is the Media RSS medium of a media type; video and GIF
+// URLs are still preview images.
+func mediaMedium(mediaType string) string {
+	if mediaType == "photo" || mediaType == "video" || mediaType == "animated_gif" {
+		return "image"
+	}
+	return ""
+}
+
+// rssItem extends the gorilla item with repeated categories, its media
+// and its location as both GeoRSS and W3C geo.
+type rssItem struct {
+	*feeds.RssItem
+	Categories  []string `xml:"category"`
+	Media       []mediaContent
+	Point       string `xml:"georss:point,omitempty"`
+	FeatureName string `xml:"georss:featurename,omitempty"`
+	Lat         string `xml:"geo:lat,omitempty"`
+	Long        string `xml:"geo:long,omitempty"`
+}
+
+// rssChannel extends the gorilla channel with elements it can't express.
+type rssChannel struct {
+	*feeds.RssFeed
+	AtomLinks []AtomLink
+	Archive   *struct{} `xml:"fh:archive"`
+	Items     []rssItem `xml:"item"`
+}
+
+type rssDocument struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	AtomNamespace    string   `xml:"xmlns:atom,attr"`
+	GeoRSSNamespace  string   `xml:"xmlns:georss,attr,omitempty"`
+	GeoNamespace     string   `xml:"xmlns:geo,attr,omitempty"`
+	HistoryNamespace string   `xml:"xmlns:fh,attr,omitempty"`
+	MediaNamespace   string   `xml:"xmlns:media,attr,omitempty"`
+	Channel          *rssChannel
+}
+
+// RenderRSS renders feed as RSS 2.0 with any extra channel links.
+func RenderRSS(feed *Document, links []AtomLink) (string, error) {
+	channel := &rssChannel{
+		RssFeed:   (&feeds.Rss{Feed: feed.Feed}).RssFeed(),
+		AtomLinks: links,
+	}
+	channel.RssFeed.Generator = feed.Generator
+	if feed.TTL > 0 {
+		// <ttl> is in minutes; round up so readers never poll early
+		channel.RssFeed.Ttl = int((feed.TTL + time.Minute - 1) / time.Minute)
+	}
+	for i, it := range channel.RssFeed.Items {
+		id := feed.Items[i].Id
+		entry := rssItem{RssItem: it, Categories: feed.Categories[id]}
+		for _, m := range feed.Media[id] {
+			entry.Media = append(entry.Media, mediaContent{URL: m.URL, Medium: mediaMedium(m.Type), Description: m.AltText})
+		}
+		if geo, ok := feed.Locations[id]; ok {
+			entry.Point = geo.Point()
+			entry.FeatureName = geo.Place
+			entry.Lat = fmt.Sprint(geo.Lat)
+			entry.Long = fmt.Sprint(geo.Long)
+		}
+		channel.Items = append(channel.Items, entry)
+	}
+	channel.RssFeed.Items = nil
+
+	doc := &rssDocument{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		AtomNamespace:    "http://www.w3.org/2005/Atom",
+		Channel:          channel,
+	}
+	if len(feed.Locations) > 0 {
+		doc.GeoRSSNamespace = geoRSSNamespace
+		doc.GeoNamespace = w3cGeoNamespace
+	}
+	if len(feed.Media) > 0 {
+		doc.MediaNamespace = mediaRSSNamespace
+	}
+	if feed.Archive {
+		doc.HistoryNamespace = HistoryNamespace
+		channel.Archive = &struct{}{}
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data), nil
+}
+
+type atomCategory struct {
+	XMLName xml.Name `xml:"category"`
+	Term    string   `xml:"term,attr"`
+}
+
+// atomEntry extends the gorilla entry with repeated categories and its
+// GeoRSS location.
+type atomEntry struct {
+	*feeds.AtomEntry
+	Categories  []atomCategory
+	Point       string `xml:"georss:point,omitempty"`
+	FeatureName string `xml:"georss:featurename,omitempty"`
+}
+
+type atomDocument struct {
+	*feeds.AtomFeed
+	GeoRSSNamespace string      `xml:"xmlns:georss,attr,omitempty"`
+	Entries         []atomEntry `xml:"entry"`
+}
+
+// RenderAtom renders feed as Atom 1.0.
+func RenderAtom(feed *Document) (string, error) {
+	doc := &atomDocument{AtomFeed: (&feeds.Atom{Feed: feed.Feed}).AtomFeed()}
+	for i, entry := range doc.AtomFeed.Entries {
+		var categories []atomCategory
+		id := feed.Items[i].Id
+		for _, term := range feed.Categories[id] {
+			categories = append(categories, atomCategory{Term: term})
+		}
+		extended := atomEntry{AtomEntry: entry, Categories: categories}
+		if geo, ok := feed.Locations[id]; ok {
+			extended.Point = geo.Point()
+			extended.FeatureName = geo.Place
+		}
+		doc.Entries = append(doc.Entries, extended)
+	}
+	doc.AtomFeed.Entries = nil
+	if len(feed.Locations) > 0 {
+		doc.GeoRSSNamespace = geoRSSNamespace
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data), nil
+}
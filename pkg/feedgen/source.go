@@ -0,0 +1,85 @@
+package feedgen
+
+import (
+	"context"
+	"net/http"
+)
+
+// FetchOptions tune a timeline fetch.
+type FetchOptions struct {
+	// Count is the most items to return.
+	Count int
+	// ExcludeReplies drops replies to other accounts.
+	ExcludeReplies bool
+	// Context carries the trace of whatever started the fetch.
+	Context context.Context
+}
+
+// Source fetches a user's recent posts from somewhere: one of the Twitter
+// APIs, a scraper, or a chain of those.
+type Source interface {
+	FetchTimeline(username string, opts FetchOptions) ([]Item, error)
+}
+
+// Profile is the account a feed follows, as shown on its profile page.
+type Profile struct {
+	Username    string `json:"username"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// ProfileSource is implemented by sources that can also look up the
+// account behind a timeline.
+type ProfileSource interface {
+	FetchProfile(username string) (Profile, error)
+}
+
+// MaxLookupIDs is the most post ids a LookupSource checks in one call,
+// the limit of both Twitter APIs' lookup endpoints.
+const MaxLookupIDs = 100
+
+// LookupSource is implemented by sources that can look posts up again, so
+// deletions can be noticed.
+type LookupSource interface {
+	// DeletedPosts returns those of ids, at most MaxLookupIDs of them, the
+	// source explicitly reports as gone. Posts it leaves out of its answer
+	// aren't.
+	DeletedPosts(ids []string) ([]string, error)
+}
+
+// ResponseHook is told of each API call a source makes, as endpoint, for
+// metrics. resp is nil when the request itself failed.
+type ResponseHook func(endpoint string, resp *http.Response, err error)
+
+func (h ResponseHook) record(endpoint string, resp *http.Response, err error) {
+	if h != nil {
+		h(endpoint, resp, err)
+	}
+}
+
+// contextTransport sends every request with ctx.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(req.WithContext(t.ctx))
+}
+
+// WithContext is client sending its requests with ctx, for sources whose
+// API clients don't take a context.
+func WithContext(client *http.Client, ctx context.Context) *http.Client {
+	if ctx == nil {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	bound := *client
+	bound.Transport = &contextTransport{ctx: ctx, next: next}
+	return &bound
+}
@@ -0,0 +1,377 @@
+package feedgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// TwitterV1Client is the part of the v1.1 API the sources call. The go-twitter
+// client is behind it in production; FakeTwitterClient stands in for tests
+// and recorded fixtures.
+type TwitterV1Client interface {
+	UserTimeline(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, *http.Response, error)
+	ShowUser(ctx context.Context, params *twitter.UserShowParams) (*twitter.User, *http.Response, error)
+	SearchTweets(ctx context.Context, params *twitter.SearchTweetParams) (*twitter.Search, *http.Response, error)
+	// LookupTweets looks ids up with map=true: every id the API answers
+	// for is in the result, nil if the tweet can't be returned.
+	LookupTweets(ctx context.Context, ids []int64) (map[string]*twitter.Tweet, *http.Response, error)
+}
+
+// goTwitterClient calls the API through go-twitter.
+type goTwitterClient struct {
+	httpClient *http.Client
+}
+
+// NewGoTwitterClient calls the API with httpClient, which must authorize
+// its requests.
+func NewGoTwitterClient(httpClient *http.Client) TwitterV1Client {
+	return &goTwitterClient{httpClient: httpClient}
+}
+
+// api is a go-twitter client sending its requests with ctx.
+func (c *goTwitterClient) api(ctx context.Context) *twitter.Client {
+	return twitter.NewClient(WithContext(c.httpClient, ctx))
+}
+
+func (c *goTwitterClient) UserTimeline(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, *http.Response, error) {
+	tweets, _, resp, err := c.userTimelineWithAltText(ctx, params)
+	return tweets, resp, err
+}
+
+// altTextTimeline is implemented by clients that can also report media
+// alt text, which go-twitter's types leave out, by media id.
+type altTextTimeline interface {
+	userTimelineWithAltText(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, map[string]string, *http.Response, error)
+}
+
+// userTimelineWithAltText asks for alt text and reads it from the raw
+// response go-twitter decoded.
+func (c *goTwitterClient) userTimelineWithAltText(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, map[string]string, *http.Response, error) {
+	capture := &altTextCapture{next: c.httpClient.Transport}
+	if capture.next == nil {
+		capture.next = http.DefaultTransport
+	}
+	client := *c.httpClient
+	client.Transport = capture
+	tweets, resp, err := twitter.NewClient(WithContext(&client, ctx)).Timelines.UserTimeline(params)
+	if err != nil {
+		return tweets, nil, resp, err
+	}
+	var raw []altTextTweet
+	altTexts := map[string]string{}
+	if json.Unmarshal(capture.body.Bytes(), &raw) == nil {
+		for _, tweet := range raw {
+			tweet.collect(altTexts)
+		}
+	}
+	return tweets, altTexts, resp, nil
+}
+
+// altTextCapture asks the API for alt text and keeps a copy of the
+// response body.
+type altTextCapture struct {
+	next http.RoundTripper
+	body bytes.Buffer
+}
+
+func (t *altTextCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	asked := req.Clone(req.Context())
+	query := asked.URL.Query()
+	query.Set("include_ext_alt_text", "true")
+	asked.URL.RawQuery = query.Encode()
+	resp, err := t.next.RoundTrip(asked)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, &t.body), resp.Body}
+	return resp, nil
+}
+
+// altTextTweet is the part of a v1.1 tweet carrying media alt text.
+type altTextTweet struct {
+	ExtendedEntities *struct {
+		Media []struct {
+			IDStr      string `json:"id_str"`
+			ExtAltText string `json:"ext_alt_text"`
+		} `json:"media"`
+	} `json:"extended_entities"`
+	QuotedStatus    *altTextTweet `json:"quoted_status"`
+	RetweetedStatus *altTextTweet `json:"retweeted_status"`
+}
+
+// collect adds the alt text of the tweet's media, and that of the tweets
+// it quotes or retweets, to altTexts.
+func (t altTextTweet) collect(altTexts map[string]string) {
+	if t.ExtendedEntities != nil {
+		for _, m := range t.ExtendedEntities.Media {
+			if m.ExtAltText != "" {
+				altTexts[m.IDStr] = m.ExtAltText
+			}
+		}
+	}
+	if t.QuotedStatus != nil {
+		t.QuotedStatus.collect(altTexts)
+	}
+	if t.RetweetedStatus != nil {
+		t.RetweetedStatus.collect(altTexts)
+	}
+}
+
+func (c *goTwitterClient) ShowUser(ctx context.Context, params *twitter.UserShowParams) (*twitter.User, *http.Response, error) {
+	return c.api(ctx).Users.Show(params)
+}
+
+func (c *goTwitterClient) SearchTweets(ctx context.Context, params *twitter.SearchTweetParams) (*twitter.Search, *http.Response, error) {
+	return c.api(ctx).Search.Tweets(params)
+}
+
+// LookupTweets calls statuses/lookup itself, since go-twitter can't decode
+// the object map=true answers with.
+func (c *goTwitterClient) LookupTweets(ctx context.Context, ids []int64) (map[string]*twitter.Tweet, *http.Response, error) {
+	idList := make([]string, len(ids))
+	for i, id := range ids {
+		idList[i] = strconv.FormatInt(id, 10)
+	}
+	query := url.Values{"id": {strings.Join(idList, ",")}, "map": {"true"}, "trim_user": {"true"}}
+	resp, err := WithContext(c.httpClient, ctx).Get("https://api.twitter.com/1.1/statuses/lookup.json?" + query.Encode())
+	if err != nil {
+		return nil, resp, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr twitter.APIError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Empty() {
+			return nil, resp, fmt.Errorf("statuses/lookup: %s", resp.Status)
+		}
+		return nil, resp, apiErr
+	}
+	var body struct {
+		ID map[string]*twitter.Tweet `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, resp, err
+	}
+	return body.ID, resp, nil
+}
+
+// TwitterFixtures are canned v1.1 API responses, in the API's own JSON, so
+// recorded responses can be replayed as they are.
+type TwitterFixtures struct {
+	// Timelines are user timelines, newest first, by screen name.
+	Timelines map[string][]twitter.Tweet `json:"timelines"`
+	// Users are users/show responses by screen name.
+	Users map[string]twitter.User `json:"users"`
+	// Searches are search results by query.
+	Searches map[string][]twitter.Tweet `json:"searches"`
+}
+
+// FakeTwitterClient answers from fixtures in memory. Screen names match
+// regardless of case, and accounts it has no fixtures for are reported as
+// missing, like the API does.
+type FakeTwitterClient struct {
+	mu       sync.Mutex
+	fixtures TwitterFixtures
+	// altTexts is the ext_alt_text of fixture media, by media id.
+	altTexts map[string]string
+	err      error
+	calls    []string
+}
+
+// NewFakeTwitterClient answers from a copy of fixtures.
+func NewFakeTwitterClient(fixtures TwitterFixtures) *FakeTwitterClient {
+	c := &FakeTwitterClient{fixtures: TwitterFixtures{
+		Timelines: map[string][]twitter.Tweet{},
+		Users:     map[string]twitter.User{},
+		Searches:  map[string][]twitter.Tweet{},
+	}, altTexts: map[string]string{}}
+	for name, tweets := range fixtures.Timelines {
+		c.fixtures.Timelines[strings.ToLower(name)] = tweets
+	}
+	for name, user := range fixtures.Users {
+		c.fixtures.Users[strings.ToLower(name)] = user
+	}
+	for query, tweets := range fixtures.Searches {
+		c.fixtures.Searches[query] = tweets
+	}
+	return c
+}
+
+// LoadTwitterFixtures decodes TwitterFixtures JSON into a fake client,
+// along with the alt text of the timelines' media.
+func LoadTwitterFixtures(r io.Reader) (*FakeTwitterClient, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures TwitterFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+	c := NewFakeTwitterClient(fixtures)
+	var raw struct {
+		Timelines map[string][]altTextTweet `json:"timelines"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for _, tweets := range raw.Timelines {
+		for _, tweet := range tweets {
+			tweet.collect(c.altTexts)
+		}
+	}
+	return c, nil
+}
+
+// AddTweet adds tweet to the top of its author's timeline.
+func (c *FakeTwitterClient) AddTweet(tweet twitter.Tweet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := ""
+	if tweet.User != nil {
+		name = strings.ToLower(tweet.User.ScreenName)
+		if _, ok := c.fixtures.Users[name]; !ok {
+			c.fixtures.Users[name] = *tweet.User
+		}
+	}
+	c.fixtures.Timelines[name] = append([]twitter.Tweet{tweet}, c.fixtures.Timelines[name]...)
+}
+
+// AddSearchResult adds tweet to the top of query's search results.
+func (c *FakeTwitterClient) AddSearchResult(query string, tweet twitter.Tweet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fixtures.Searches[query] = append([]twitter.Tweet{tweet}, c.fixtures.Searches[query]...)
+}
+
+// DeleteTweet removes the tweet with id from every timeline, as if its
+// author deleted it.
+func (c *FakeTwitterClient) DeleteTweet(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, timeline := range c.fixtures.Timelines {
+		kept := make([]twitter.Tweet, 0, len(timeline))
+		for _, tweet := range timeline {
+			if tweet.IDStr != id {
+				kept = append(kept, tweet)
+			}
+		}
+		c.fixtures.Timelines[name] = kept
+	}
+}
+
+// FailWith makes every call fail with err until it is called with nil.
+func (c *FakeTwitterClient) FailWith(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Calls lists the calls made so far, e.g. "user_timeline alice".
+func (c *FakeTwitterClient) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// respond records a call and returns the response it gets.
+func (c *FakeTwitterClient) respond(call string, found bool) (*http.Response, error) {
+	c.calls = append(c.calls, call)
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !found {
+		resp := &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Header: http.Header{}}
+		return resp, twitter.APIError{Errors: []twitter.ErrorDetail{{Code: 34, Message: "Sorry, that page does not exist."}}}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}}, nil
+}
+
+func (c *FakeTwitterClient) userTimelineWithAltText(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, map[string]string, *http.Response, error) {
+	tweets, resp, err := c.UserTimeline(ctx, params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return tweets, c.altTexts, resp, err
+}
+
+func (c *FakeTwitterClient) UserTimeline(ctx context.Context, params *twitter.UserTimelineParams) ([]twitter.Tweet, *http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := strings.ToLower(params.ScreenName)
+	timeline, found := c.fixtures.Timelines[name]
+	resp, err := c.respond("user_timeline "+name, found)
+	if err != nil {
+		return nil, resp, err
+	}
+	excludeReplies := params.ExcludeReplies != nil && *params.ExcludeReplies
+	var tweets []twitter.Tweet
+	for _, tweet := range timeline {
+		if excludeReplies && tweet.InReplyToStatusIDStr != "" {
+			continue
+		}
+		tweets = append(tweets, tweet)
+		if params.Count > 0 && len(tweets) == params.Count {
+			break
+		}
+	}
+	return tweets, resp, nil
+}
+
+func (c *FakeTwitterClient) ShowUser(ctx context.Context, params *twitter.UserShowParams) (*twitter.User, *http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := strings.ToLower(params.ScreenName)
+	user, found := c.fixtures.Users[name]
+	resp, err := c.respond("users_show "+name, found)
+	if err != nil {
+		return nil, resp, err
+	}
+	return &user, resp, nil
+}
+
+func (c *FakeTwitterClient) SearchTweets(ctx context.Context, params *twitter.SearchTweetParams) (*twitter.Search, *http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := c.fixtures.Searches[params.Query]
+	resp, err := c.respond("search_tweets "+params.Query, true)
+	if err != nil {
+		return nil, resp, err
+	}
+	if params.Count > 0 && len(results) > params.Count {
+		results = results[:params.Count]
+	}
+	return &twitter.Search{Statuses: results}, resp, nil
+}
+
+func (c *FakeTwitterClient) LookupTweets(ctx context.Context, ids []int64) (map[string]*twitter.Tweet, *http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, err := c.respond(fmt.Sprintf("statuses_lookup %d", len(ids)), true)
+	if err != nil {
+		return nil, resp, err
+	}
+	tweets := make(map[string]*twitter.Tweet, len(ids))
+	for _, id := range ids {
+		tweets[strconv.FormatInt(id, 10)] = nil
+	}
+	for _, timeline := range c.fixtures.Timelines {
+		for i, tweet := range timeline {
+			if found, wanted := tweets[tweet.IDStr]; wanted && found == nil {
+				tweets[tweet.IDStr] = &timeline[i]
+			}
+		}
+	}
+	return tweets, resp, nil
+}
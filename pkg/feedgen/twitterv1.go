@@ -0,0 +1,204 @@
+package feedgen
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// TwitterV1Source reads timelines from the v1.1 API.
+type TwitterV1Source struct {
+	client TwitterV1Client
+	// OnResponse, if set, is told of every API call.
+	OnResponse ResponseHook
+}
+
+// NewTwitterV1Source calls the API with httpClient, which must authorize
+// its requests.
+func NewTwitterV1Source(httpClient *http.Client) *TwitterV1Source {
+	return NewTwitterV1SourceWithClient(NewGoTwitterClient(httpClient))
+}
+
+// NewTwitterV1SourceWithClient reads from client, say a FakeTwitterClient.
+func NewTwitterV1SourceWithClient(client TwitterV1Client) *TwitterV1Source {
+	return &TwitterV1Source{client: client}
+}
+
+func (b *TwitterV1Source) FetchTimeline(username string, opts FetchOptions) ([]Item, error) {
+	// Status Show
+	params := &twitter.UserTimelineParams{
+		ScreenName:     username,
+		Count:          opts.Count,
+		ExcludeReplies: twitter.Bool(opts.ExcludeReplies),
+	}
+	var tweets []twitter.Tweet
+	var altTexts map[string]string
+	var resp *http.Response
+	var err error
+	if client, ok := b.client.(altTextTimeline); ok {
+		tweets, altTexts, resp, err = client.userTimelineWithAltText(opts.Context, params)
+	} else {
+		tweets, resp, err = b.client.UserTimeline(opts.Context, params)
+	}
+	b.OnResponse.record("user_timeline", resp, err)
+	if err = v1AccountError(username, resp, err); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(tweets))
+	for _, tweet := range tweets {
+		items = append(items, itemFromV1(username, tweet, altTexts))
+	}
+	return items, nil
+}
+
+func (b *TwitterV1Source) FetchProfile(username string) (Profile, error) {
+	user, resp, err := b.client.ShowUser(context.Background(), &twitter.UserShowParams{ScreenName: username})
+	b.OnResponse.record("users_show", resp, err)
+	if err != nil {
+		return Profile{}, err
+	}
+	return Profile{
+		Username:    user.ScreenName,
+		Name:        user.Name,
+		Description: user.Description,
+		URL:         "https://twitter.com/" + user.ScreenName,
+		AvatarURL:   user.ProfileImageURLHttps,
+	}, nil
+}
+
+// DeletedPosts looks ids up with statuses/lookup, which answers null for
+// tweets that are gone. It answers null too for tweets the account can't
+// see, such as a suspended or protected account's, so callers should
+// check the account is readable first.
+func (b *TwitterV1Source) DeletedPosts(ids []string) ([]string, error) {
+	numeric := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("not a tweet id: %q", id)
+		}
+		numeric = append(numeric, n)
+	}
+	tweets, resp, err := b.client.LookupTweets(context.Background(), numeric)
+	b.OnResponse.record("statuses_lookup", resp, err)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, id := range ids {
+		if tweet, reported := tweets[id]; reported && tweet == nil {
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+// v1Media returns the media attached to a tweet, preferring the extended
+// entities which list every photo rather than just the first.
+func v1Media(tweet twitter.Tweet) []twitter.MediaEntity {
+	if tweet.ExtendedEntities != nil && len(tweet.ExtendedEntities.Media) > 0 {
+		return tweet.ExtendedEntities.Media
+	}
+	if tweet.Entities != nil {
+		return tweet.Entities.Media
+	}
+	return nil
+}
+
+// ItemFromV1 converts a v1.1 tweet. username is the timeline it came from,
+// for tweets without their user expanded.
+func ItemFromV1(username string, tweet twitter.Tweet) Item {
+	return itemFromV1(username, tweet, nil)
+}
+
+// itemFromV1 is ItemFromV1 with the alt text of media, by media id.
+func itemFromV1(username string, tweet twitter.Tweet, altTexts map[string]string) Item {
+	createdAt, err := tweet.CreatedAtTime()
+	if err != nil {
+		// the id says when the tweet was posted, closer than a zero time
+		createdAt, _ = SnowflakeTime(tweet.IDStr)
+	}
+	it := Item{
+		ID:        tweet.IDStr,
+		Lang:      tweet.Lang,
+		Text:      tweet.Text,
+		CreatedAt: createdAt.UTC(),
+		Author:    Author{Username: username},
+	}
+	if tweet.FullText != "" {
+		it.Text = tweet.FullText
+	}
+	if tweet.User != nil {
+		it.Author = Author{
+			Username:  tweet.User.ScreenName,
+			Name:      tweet.User.Name,
+			AvatarURL: tweet.User.ProfileImageURLHttps,
+		}
+	}
+	it.Author.URL = "https://twitter.com/" + it.Author.Username
+	it.URL = fmt.Sprintf("https://twitter.com/%s/status/%s", it.Author.Username, it.ID)
+	if tweet.QuotedStatus != nil {
+		quoted := itemFromV1("", *tweet.QuotedStatus, altTexts)
+		quoted.Quoted = nil
+		it.Quoted = &quoted
+		if tweet.Entities != nil {
+			for _, link := range tweet.Entities.Urls {
+				if isQuoteLink(link.ExpandedURL, quoted.ID) {
+					it.Text = stripLink(it.Text, link.URL)
+				}
+			}
+		}
+	}
+	it.Geo = geoFromV1(tweet)
+	if tweet.RetweetedStatus != nil {
+		it.AsRetweet(itemFromV1("", *tweet.RetweetedStatus, altTexts))
+	}
+	if tweet.Entities != nil {
+		for _, tag := range tweet.Entities.Hashtags {
+			it.Hashtags = append(it.Hashtags, tag.Text)
+		}
+	}
+	it.Metrics = &Metrics{Retweets: tweet.RetweetCount, Likes: tweet.FavoriteCount, Replies: tweet.ReplyCount, Quotes: tweet.QuoteCount}
+	it.InReplyToID = tweet.InReplyToStatusIDStr
+	it.InReplyToUser = tweet.InReplyToScreenName
+	for _, media := range v1Media(tweet) {
+		it.Media = append(it.Media, Media{
+			Type:         media.Type,
+			URL:          media.MediaURLHttps,
+			ThumbnailURL: twitterThumbnail(media.MediaURLHttps),
+			Width:        media.Sizes.Small.Width,
+			Height:       media.Sizes.Small.Height,
+			AltText:      altTexts[media.IDStr],
+		})
+	}
+	return it
+}
+
+// geoFromV1 is the tweet's exact coordinates, or the centre of its place.
+func geoFromV1(tweet twitter.Tweet) *Geo {
+	var geo *Geo
+	if tweet.Place != nil && tweet.Place.BoundingBox != nil {
+		// the box is a single polygon ring of [long, lat] corners
+		for _, ring := range tweet.Place.BoundingBox.Coordinates {
+			if len(ring) < 3 {
+				continue
+			}
+			bbox := []float64{ring[0][0], ring[0][1], ring[2][0], ring[2][1]}
+			if centre, ok := boundingBoxCentre(bbox); ok {
+				geo = &centre
+			}
+			break
+		}
+	}
+	if tweet.Coordinates != nil {
+		geo = &Geo{Long: tweet.Coordinates.Coordinates[0], Lat: tweet.Coordinates.Coordinates[1]}
+	}
+	if geo != nil && tweet.Place != nil {
+		geo.Place = tweet.Place.FullName
+	}
+	return geo
+}
@@ -0,0 +1,472 @@
+package feedgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const twitterV2BaseURL = "https://api.twitter.com/2"
+
+// TwitterV2Error is a problem reported by the v2 API, either as the whole
+// response or inside a partial-error "errors" array.
+type TwitterV2Error struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Type   string `json:"type"`
+	Status int    `json:"status"`
+	// ResourceID is the id of the tweet or user a partial error is about.
+	ResourceID string `json:"resource_id,omitempty"`
+}
+
+func (e *TwitterV2Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("twitter v2: %s: %s", e.Title, e.Detail)
+	}
+	return fmt.Sprintf("twitter v2: %s", e.Title)
+}
+
+type twitterV2User struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Username        string `json:"username"`
+	ProfileImageURL string `json:"profile_image_url"`
+	Description     string `json:"description"`
+}
+
+type twitterV2Media struct {
+	MediaKey        string `json:"media_key"`
+	Type            string `json:"type"`
+	URL             string `json:"url"`
+	PreviewImageURL string `json:"preview_image_url"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	AltText         string `json:"alt_text"`
+}
+
+type twitterV2Poll struct {
+	ID      string `json:"id"`
+	Options []struct {
+		Position int    `json:"position"`
+		Label    string `json:"label"`
+		Votes    int    `json:"votes"`
+	} `json:"options"`
+	EndDatetime  time.Time `json:"end_datetime"`
+	VotingStatus string    `json:"voting_status"`
+}
+
+type twitterV2Place struct {
+	ID       string `json:"id"`
+	FullName string `json:"full_name"`
+	Geo      struct {
+		BBox []float64 `json:"bbox"`
+	} `json:"geo"`
+}
+
+type twitterV2ReferencedTweet struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type twitterV2Tweet struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	AuthorID  string    `json:"author_id"`
+	Lang      string    `json:"lang"`
+	// InReplyToUserID is set on replies.
+	InReplyToUserID string `json:"in_reply_to_user_id"`
+	Attachments     struct {
+		MediaKeys []string `json:"media_keys"`
+		PollIDs   []string `json:"poll_ids"`
+	} `json:"attachments"`
+	ReferencedTweets []twitterV2ReferencedTweet `json:"referenced_tweets"`
+	PublicMetrics    *struct {
+		RetweetCount int `json:"retweet_count"`
+		LikeCount    int `json:"like_count"`
+		ReplyCount   int `json:"reply_count"`
+		QuoteCount   int `json:"quote_count"`
+	} `json:"public_metrics"`
+	Geo *struct {
+		PlaceID     string `json:"place_id"`
+		Coordinates *struct {
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"coordinates"`
+	} `json:"geo"`
+	Entities struct {
+		Hashtags []struct {
+			Tag string `json:"tag"`
+		} `json:"hashtags"`
+		URLs []struct {
+			URL         string `json:"url"`
+			ExpandedURL string `json:"expanded_url"`
+		} `json:"urls"`
+	} `json:"entities"`
+}
+
+type twitterV2Includes struct {
+	Users  []twitterV2User  `json:"users"`
+	Media  []twitterV2Media `json:"media"`
+	Tweets []twitterV2Tweet `json:"tweets"`
+	Places []twitterV2Place `json:"places"`
+	Polls  []twitterV2Poll  `json:"polls"`
+}
+
+type twitterV2TimelineResponse struct {
+	Data     []twitterV2Tweet  `json:"data"`
+	Includes twitterV2Includes `json:"includes"`
+	Errors   []TwitterV2Error  `json:"errors"`
+}
+
+type twitterV2UserResponse struct {
+	Data   *twitterV2User   `json:"data"`
+	Errors []TwitterV2Error `json:"errors"`
+}
+
+// TwitterV2Source reads timelines from the v2 API, expanding media,
+// referenced tweets and authors so items are complete in one call.
+type TwitterV2Source struct {
+	client  *http.Client
+	baseURL string
+	// OnResponse, if set, is told of every API call.
+	OnResponse ResponseHook
+
+	mu      sync.Mutex
+	userIDs map[string]string
+	// me is the id of the account the client acts for, once looked up.
+	me string
+}
+
+// NewTwitterV2Source calls the API with httpClient, which must authorize
+// its requests.
+func NewTwitterV2Source(httpClient *http.Client) *TwitterV2Source {
+	return &TwitterV2Source{client: httpClient, baseURL: twitterV2BaseURL, userIDs: map[string]string{}}
+}
+
+// get calls a v2 endpoint and decodes its JSON response into v.
+func (b *TwitterV2Source) get(ctx context.Context, endpoint string, path string, query url.Values, v interface{}) error {
+	resp, err := WithContext(b.client, ctx).Get(b.baseURL + path + "?" + query.Encode())
+	if err != nil {
+		b.OnResponse.record(endpoint, nil, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		problem := &TwitterV2Error{Status: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(problem) != nil || problem.Title == "" {
+			problem.Title = resp.Status
+		}
+		b.OnResponse.record(endpoint, resp, problem)
+		return problem
+	}
+
+	err = errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "unable to decode response")
+	b.OnResponse.record(endpoint, resp, err)
+	return err
+}
+
+func (b *TwitterV2Source) userID(ctx context.Context, username string) (string, error) {
+	key := strings.ToLower(username)
+	b.mu.Lock()
+	id, ok := b.userIDs[key]
+	b.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body := twitterV2UserResponse{}
+	if err := b.get(ctx, "v2_user_by_username", "/users/by/username/"+url.PathEscape(username), url.Values{}, &body); err != nil {
+		return "", err
+	}
+	if body.Data == nil {
+		if len(body.Errors) > 0 {
+			return "", &body.Errors[0]
+		}
+		return "", fmt.Errorf("twitter v2: no user %s", username)
+	}
+
+	b.mu.Lock()
+	b.userIDs[key] = body.Data.ID
+	b.mu.Unlock()
+	return body.Data.ID, nil
+}
+
+func (b *TwitterV2Source) FetchProfile(username string) (Profile, error) {
+	body := twitterV2UserResponse{}
+	query := url.Values{"user.fields": {"name,username,description,profile_image_url"}}
+	if err := b.get(context.Background(), "v2_user_by_username", "/users/by/username/"+url.PathEscape(username), query, &body); err != nil {
+		return Profile{}, err
+	}
+	if body.Data == nil {
+		if len(body.Errors) > 0 {
+			return Profile{}, &body.Errors[0]
+		}
+		return Profile{}, fmt.Errorf("twitter v2: no user %s", username)
+	}
+
+	user := body.Data
+	b.mu.Lock()
+	b.userIDs[strings.ToLower(username)] = user.ID
+	b.mu.Unlock()
+	return Profile{
+		Username:    user.Username,
+		Name:        user.Name,
+		Description: user.Description,
+		URL:         "https://twitter.com/" + user.Username,
+		AvatarURL:   user.ProfileImageURL,
+	}, nil
+}
+
+func (b *TwitterV2Source) FetchTimeline(username string, opts FetchOptions) ([]Item, error) {
+	id, err := b.userID(opts.Context, username)
+	if err != nil {
+		return nil, v2AccountError(username, err)
+	}
+
+	query := v2TweetQuery(opts.Count)
+	if opts.ExcludeReplies {
+		query.Set("exclude", "replies")
+	}
+	body := twitterV2TimelineResponse{}
+	if err := b.get(opts.Context, "v2_user_tweets", "/users/"+id+"/tweets", query, &body); err != nil {
+		return nil, v2AccountError(username, err)
+	}
+	if body.Data == nil && len(body.Errors) > 0 {
+		return nil, v2AccountError(username, &body.Errors[0])
+	}
+	return body.items(username), nil
+}
+
+// DeletedPosts looks ids up. Only tweets the API reports as not found
+// were deleted; it won't show others for reasons such as a now protected
+// account's.
+func (b *TwitterV2Source) DeletedPosts(ids []string) ([]string, error) {
+	body := twitterV2TimelineResponse{}
+	query := url.Values{"ids": {strings.Join(ids, ",")}}
+	if err := b.get(context.Background(), "v2_tweets_lookup", "/tweets", query, &body); err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, problem := range body.Errors {
+		if problem.ResourceID != "" && problem.Type == v2ResourceNotFound {
+			deleted = append(deleted, problem.ResourceID)
+		}
+	}
+	return deleted, nil
+}
+
+// FetchBookmarks fetches the bookmarks of the account b's client acts for,
+// which takes an OAuth 2.0 user context with the bookmark.read scope.
+func (b *TwitterV2Source) FetchBookmarks(opts FetchOptions) ([]Item, error) {
+	id, err := b.meID(opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	body := twitterV2TimelineResponse{}
+	if err := b.get(opts.Context, "v2_bookmarks", "/users/"+id+"/bookmarks", v2TweetQuery(opts.Count), &body); err != nil {
+		return nil, err
+	}
+	if body.Data == nil && len(body.Errors) > 0 {
+		return nil, &body.Errors[0]
+	}
+	return body.items(""), nil
+}
+
+// meID is the id of the account b's client acts for.
+func (b *TwitterV2Source) meID(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	id := b.me
+	b.mu.Unlock()
+	if id != "" {
+		return id, nil
+	}
+
+	body := twitterV2UserResponse{}
+	if err := b.get(ctx, "v2_users_me", "/users/me", url.Values{}, &body); err != nil {
+		return "", err
+	}
+	if body.Data == nil {
+		if len(body.Errors) > 0 {
+			return "", &body.Errors[0]
+		}
+		return "", fmt.Errorf("twitter v2: no authenticated user")
+	}
+
+	b.mu.Lock()
+	b.me = body.Data.ID
+	b.mu.Unlock()
+	return body.Data.ID, nil
+}
+
+// v2TweetQuery asks for count tweets with everything items are made of
+// expanded.
+func v2TweetQuery(count int) url.Values {
+	// the v2 API only accepts between 5 and 100 results
+	if count < 5 {
+		count = 5
+	}
+	return url.Values{
+		"max_results":  {fmt.Sprint(count)},
+		"expansions":   {"author_id,attachments.media_keys,attachments.poll_ids,referenced_tweets.id,referenced_tweets.id.author_id,geo.place_id"},
+		"tweet.fields": {"created_at,author_id,attachments,referenced_tweets,in_reply_to_user_id,entities,public_metrics,lang,geo"},
+		"user.fields":  {"name,username,profile_image_url"},
+		"media.fields": {"type,url,preview_image_url,width,height,alt_text"},
+		"place.fields": {"full_name,geo"},
+		"poll.fields":  {"end_datetime,voting_status"},
+	}
+}
+
+// items are the response's tweets with their expansions filled in.
+// username is the timeline they came from, for tweets without an author.
+func (body twitterV2TimelineResponse) items(username string) []Item {
+	users := map[string]twitterV2User{}
+	for _, user := range body.Includes.Users {
+		users[user.ID] = user
+	}
+	media := map[string]twitterV2Media{}
+	for _, m := range body.Includes.Media {
+		media[m.MediaKey] = m
+	}
+	tweets := map[string]twitterV2Tweet{}
+	for _, tweet := range body.Includes.Tweets {
+		tweets[tweet.ID] = tweet
+	}
+	places := map[string]twitterV2Place{}
+	for _, place := range body.Includes.Places {
+		places[place.ID] = place
+	}
+	polls := map[string]twitterV2Poll{}
+	for _, poll := range body.Includes.Polls {
+		polls[poll.ID] = poll
+	}
+
+	items := make([]Item, 0, len(body.Data))
+	for _, tweet := range body.Data {
+		it := itemFromV2(username, tweet, users, media, polls)
+		it.Geo = geoFromV2(tweet, places)
+		for _, ref := range tweet.ReferencedTweets {
+			quotedTweet, ok := tweets[ref.ID]
+			if !ok {
+				continue
+			}
+			if ref.Type == "retweeted" {
+				it.AsRetweet(itemFromV2("", quotedTweet, users, media, polls))
+				continue
+			}
+			if ref.Type != "quoted" {
+				continue
+			}
+			if it.RetweetOf != "" {
+				// a retweet of a quote tweet already carries the quote
+				continue
+			}
+			quoted := itemFromV2("", quotedTweet, users, media, polls)
+			it.Quoted = &quoted
+			for _, link := range tweet.Entities.URLs {
+				if isQuoteLink(link.ExpandedURL, quoted.ID) {
+					it.Text = stripLink(it.Text, link.URL)
+				}
+			}
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+func itemFromV2(username string, tweet twitterV2Tweet, users map[string]twitterV2User, media map[string]twitterV2Media, polls map[string]twitterV2Poll) Item {
+	it := Item{
+		ID:        tweet.ID,
+		Lang:      tweet.Lang,
+		Text:      tweet.Text,
+		CreatedAt: tweet.CreatedAt.UTC(),
+		Author:    Author{Username: username},
+	}
+	if it.CreatedAt.IsZero() {
+		it.CreatedAt, _ = SnowflakeTime(tweet.ID)
+	}
+	if user, ok := users[tweet.AuthorID]; ok {
+		it.Author = Author{Username: user.Username, Name: user.Name, AvatarURL: user.ProfileImageURL}
+	}
+	it.Author.URL = "https://twitter.com/" + it.Author.Username
+	it.URL = fmt.Sprintf("https://twitter.com/%s/status/%s", it.Author.Username, it.ID)
+	for _, tag := range tweet.Entities.Hashtags {
+		it.Hashtags = append(it.Hashtags, tag.Tag)
+	}
+	if m := tweet.PublicMetrics; m != nil {
+		it.Metrics = &Metrics{Retweets: m.RetweetCount, Likes: m.LikeCount, Replies: m.ReplyCount, Quotes: m.QuoteCount}
+	}
+	for _, ref := range tweet.ReferencedTweets {
+		if ref.Type == "replied_to" {
+			it.InReplyToID = ref.ID
+			it.InReplyToUser = tweet.InReplyToUserID
+			if user, ok := users[tweet.InReplyToUserID]; ok {
+				it.InReplyToUser = user.Username
+			} else if tweet.InReplyToUserID == tweet.AuthorID {
+				it.InReplyToUser = it.Author.Username
+			}
+		}
+	}
+	for _, key := range tweet.Attachments.MediaKeys {
+		m, ok := media[key]
+		if !ok {
+			continue
+		}
+		mediaURL := m.URL
+		if mediaURL == "" {
+			mediaURL = m.PreviewImageURL
+		}
+		width, height := FitThumbnail(m.Width, m.Height)
+		it.Media = append(it.Media, Media{Type: m.Type, URL: mediaURL, ThumbnailURL: twitterThumbnail(mediaURL), Width: width, Height: height, AltText: m.AltText})
+	}
+	it.Poll = pollFromV2(tweet, polls)
+	return it
+}
+
+// pollFromV2 is the tweet's poll, if it has one. Options come in the order
+// they were offered.
+func pollFromV2(tweet twitterV2Tweet, polls map[string]twitterV2Poll) *Poll {
+	for _, id := range tweet.Attachments.PollIDs {
+		expanded, ok := polls[id]
+		if !ok {
+			continue
+		}
+		options := expanded.Options
+		sort.Slice(options, func(i, j int) bool { return options[i].Position < options[j].Position })
+		poll := &Poll{EndsAt: expanded.EndDatetime, Closed: expanded.VotingStatus == "closed"}
+		for _, option := range options {
+			poll.Options = append(poll.Options, PollOption{Label: option.Label, Votes: option.Votes})
+		}
+		return poll
+	}
+	return nil
+}
+
+// geoFromV2 is the tweet's exact coordinates, or the centre of its place.
+func geoFromV2(tweet twitterV2Tweet, places map[string]twitterV2Place) *Geo {
+	if tweet.Geo == nil {
+		return nil
+	}
+	var geo *Geo
+	place, hasPlace := places[tweet.Geo.PlaceID]
+	if hasPlace {
+		if centre, ok := boundingBoxCentre(place.Geo.BBox); ok {
+			geo = &centre
+		}
+	}
+	if c := tweet.Geo.Coordinates; c != nil && len(c.Coordinates) == 2 {
+		geo = &Geo{Long: c.Coordinates[0], Lat: c.Coordinates[1]}
+	}
+	if geo != nil && hasPlace {
+		geo.Place = place.FullName
+	}
+	return geo
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// poller refreshes every configured feed in the background so readers are
+// served from the cache and new tweets are noticed without a reader asking.
+type poller struct {
+	cfg      *config
+	fetcher  *fetcher
+	interval time.Duration
+}
+
+func (p *poller) pollOnce() {
+	for _, username := range p.cfg.usernames() {
+		if _, err := p.fetcher.Refresh(context.Background(), username); err != nil {
+			log.Print(errors.Wrapf(err, "polling %s failed", username))
+		}
+	}
+}
+
+func (p *poller) Run() {
+	for {
+		p.pollOnce()
+		time.Sleep(p.interval)
+	}
+}
+
+// warmStartupGap spaces the fetches of the startup pass, which fills the
+// cache as soon as the rate limits allow rather than over a whole interval.
+const warmStartupGap = time.Second
+
+// cacheWarmer fetches every configured feed at startup, then refreshes each
+// one in turn, spread evenly over the interval so they don't all hit the
+// API at once. Fetches wait while Twitter reports a timeline endpoint
+// rate limited.
+type cacheWarmer struct {
+	cfg      *config
+	fetcher  *fetcher
+	interval time.Duration
+}
+
+// rateLimitedUntil is when the last exhausted Twitter rate limit resets,
+// or the zero time if none is.
+func rateLimitedUntil(now time.Time) time.Time {
+	var until time.Time
+	for _, quota := range latestQuotas(upstreamLog) {
+		if quota.Remaining == 0 && quota.Reset.After(now) && quota.Reset.After(until) {
+			until = quota.Reset
+		}
+	}
+	return until
+}
+
+func (w *cacheWarmer) warm(username string) {
+	if until := rateLimitedUntil(time.Now()); !until.IsZero() {
+		log.Printf("cache warming paused until %s for rate limits", until.Format(time.RFC3339))
+		time.Sleep(time.Until(until))
+	}
+	if _, err := w.fetcher.Refresh(context.Background(), username); err != nil {
+		log.Print(errors.Wrapf(err, "warming %s failed", username))
+	}
+}
+
+func (w *cacheWarmer) Run() {
+	for _, username := range w.cfg.usernames() {
+		w.warm(username)
+		time.Sleep(warmStartupGap)
+	}
+	for {
+		usernames := w.cfg.usernames()
+		if len(usernames) == 0 {
+			time.Sleep(w.interval)
+			continue
+		}
+		gap := w.interval / time.Duration(len(usernames))
+		for _, username := range usernames {
+			time.Sleep(gap)
+			w.warm(username)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/pkg/errors"
+)
+
+var errNoProfiles = errors.New("source has no profiles")
+
+func fetchProfile(source timelineSource, username string) (profile, error) {
+	profiles, ok := source.(profileSource)
+	if !ok {
+		return profile{}, errNoProfiles
+	}
+	return profiles.FetchProfile(username)
+}
+
+func (s *fallbackSource) FetchProfile(username string) (profile, error) {
+	var errs []string
+	for _, named := range s.sources {
+		p, err := fetchProfile(named.source, username)
+		if err == nil {
+			return p, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", named.name, err))
+	}
+	return profile{}, errors.New(strings.Join(errs, "; "))
+}
+
+// FetchProfile looks up Twitter profiles; other networks have none yet.
+func (s *routedSource) FetchProfile(key string) (profile, error) {
+	network, account := splitFeedKey(key)
+	if network != "" {
+		return profile{}, errNoProfiles
+	}
+	return fetchProfile(s.twitter, account)
+}
+
+// profileFailureTTL is how long a failed profile lookup is remembered
+// before it is tried again.
+const profileFailureTTL = 10 * time.Minute
+
+type cachedProfile struct {
+	profile profile
+	ok      bool
+	expires time.Time
+}
+
+// profileCache looks profiles up from the source, keeping each for ttl since
+// they change far less often than timelines.
+type profileCache struct {
+	source timelineSource
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedProfile
+}
+
+func newProfileCache(source timelineSource, ttl time.Duration) *profileCache {
+	return &profileCache{source: source, ttl: ttl, entries: map[string]cachedProfile{}}
+}
+
+// Get returns username's profile, or false when it can't be looked up.
+// A failed lookup keeps serving the last profile found, if there was one.
+func (c *profileCache) Get(username string) (profile, bool) {
+	c.mu.Lock()
+	cached, ok := c.entries[username]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.profile, cached.ok
+	}
+
+	p, err := fetchProfile(c.source, username)
+	switch {
+	case err == nil:
+		cached = cachedProfile{profile: p, ok: true, expires: time.Now().Add(c.ttl)}
+	case ok && cached.ok:
+		log.Print(errors.Wrapf(err, "unable to refresh profile for %s", username))
+		cached.expires = time.Now().Add(profileFailureTTL)
+	default:
+		if err != errNoProfiles {
+			log.Print(errors.Wrapf(err, "unable to get profile for %s", username))
+		}
+		cached = cachedProfile{expires: time.Now().Add(profileFailureTTL)}
+	}
+
+	c.mu.Lock()
+	c.entries[username] = cached
+	c.mu.Unlock()
+	return cached.profile, cached.ok
+}
+
+func (c *profileCache) PurgeFeed(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, username)
+	return nil
+}
+
+func (c *profileCache) HasFeed(username string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[username]
+	return ok
+}
+
+// applyProfile fills in the feed's metadata from the account profile,
+// leaving a configured or templated title alone.
+func applyProfile(doc *feedDocument, feedCfg feedConfig, p profile) {
+	name := p.Username
+	if p.Name != "" {
+		name = fmt.Sprintf("%s (@%s)", p.Name, p.Username)
+	}
+	if feedCfg.Title == "" && (feedCfg.Templates == nil || feedCfg.Templates.FeedTitle == "") {
+		doc.Title = name
+	}
+	if p.Description != "" {
+		doc.Description = p.Description
+	}
+	if p.URL != "" {
+		doc.Link = &feeds.Link{Href: p.URL}
+	}
+	doc.Author = &feeds.Author{Name: name}
+	if p.AvatarURL != "" {
+		doc.Image = &feeds.Image{Url: p.AvatarURL, Title: doc.Title, Link: p.URL}
+	}
+}
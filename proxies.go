@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/handlers"
+)
+
+// Anyone can send X-Forwarded-For, so the client address the IP filter and
+// rate limits go by is only taken from forwarding headers set by the
+// reverse proxies listed in -trusted-proxies.
+
+// trustedProxies honours forwarding headers on requests from its proxies.
+type trustedProxies struct {
+	nets []*net.IPNet
+}
+
+func newTrustedProxies(list string) (*trustedProxies, error) {
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		return nil, err
+	}
+	return &trustedProxies{nets: nets}, nil
+}
+
+func (p *trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	return ip != nil && containsIP(p.nets, ip)
+}
+
+// client is the address of the client behind the trusted proxy the
+// request came from. X-Forwarded-For is read from the right, where each
+// proxy appends the address it saw, skipping the trusted proxies: entries
+// further left were written by the client and prove nothing.
+func (p *trustedProxies) client(r *http.Request, peer string) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !p.trusts(hop) || i == 0 {
+			return hop
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+// clientIP is the address a request came from, as trustedProxies resolved
+// it. The IP filter, rate limits and quotas all key on it, so a client
+// can't rotate X-Forwarded-For to look like many.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Handler applies ProxyHeaders to requests from trusted proxies, with the
+// client address resolved as client does, and leaves every other request
+// as it arrived.
+func (p *trustedProxies) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(peer); err == nil {
+			peer = host
+		}
+		if !p.trusts(peer) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := p.client(r, peer)
+		handlers.ProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = client
+			next.ServeHTTP(w, r)
+		})).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := newTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer forwarding", "203.0.113.7:4000", map[string]string{"X-Forwarded-For": "10.1.1.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hop ahead of the client", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "192.0.2.99, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"real ip header", "10.0.0.2:4000", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without headers", "10.0.0.2:4000", nil, "10.0.0.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			handler := proxies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+			r := httptest.NewRequest("GET", "/feed/alice.xml", nil)
+			r.RemoteAddr = test.peer
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != test.want {
+				t.Errorf("clientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBucketLimiterIgnoresRotatedForwardedFor(t *testing.T) {
+	proxies, err := newTrustedProxies("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := proxies.Handler(newBucketLimiter(0.001, 2).Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	codes := []int{}
+	for _, forwarded := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		r := httptest.NewRequest("GET", "/feed/alice.xml", nil)
+		r.RemoteAddr = "203.0.113.7:4000"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want the third request limited", codes)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// publicCacheTTL is the shortest cache TTL public mode allows, so a popular
+// instance doesn't spend its API quota refetching the same feeds.
+const publicCacheTTL = 30 * time.Minute
+
+// publicQuotas limits how hard any one client, and everyone together, can
+// use a public instance.
+type publicQuotas struct {
+	perIP  *windowLimiter
+	global *windowLimiter
+}
+
+// Limit applies the quotas to a feed handler.
+func (q *publicQuotas) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := q.perIP.Allow(clientIP(r)); !ok {
+			tooManyRequests(w, "public_ip", retry)
+			return
+		}
+		if ok, retry := q.global.Allow(""); !ok {
+			tooManyRequests(w, "public_global", retry)
+			return
+		}
+		next(w, r)
+	}
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html lang="{{.Locale.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>twitterrss</title>
+</head>
+<body>
+<h1>twitterrss</h1>
+<p>{{.Locale.T "public_intro"}}</p>
+<h2>{{.Locale.T "usage"}}</h2>
+<ul>
+<li>Twitter: <code>{{.Base}}/feed/{username}.xml</code></li>
+<li>Mastodon: <code>{{.Base}}/feed/mastodon/{instance}/{username}.xml</code></li>
+<li>Bluesky: <code>{{.Base}}/feed/bsky/{handle}.xml</code></li>
+</ul>
+<p>{{.Locale.T "public_caching"}}</p>
+<p>{{.Locale.T "source"}}: <a href="https://github.com/halkeye/twitterrss">github.com/halkeye/twitterrss</a></p>
+</body>
+</html>
+`))
+
+// LandingHandler explains how to use a public instance instead of listing
+// every feed anyone has asked it for.
+func LandingHandler(w http.ResponseWriter, r *http.Request) {
+	var body bytes.Buffer
+	data := struct {
+		Locale locale
+		Base   string
+	}{negotiateLocale(r), baseURL(r)}
+	if err := landingTemplate.Execute(&body, data); err != nil {
+		panic(errors.Wrap(err, "unable to render landing page"))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
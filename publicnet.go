@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Feeds anyone can ask for, and the media in them, make this instance
+// fetch URLs strangers chose. The helpers here keep those fetches on the
+// public internet, so they can't reach the host's own network or cloud
+// metadata services and have the response served back.
+
+// reservedNets are ranges that aren't routable on the internet, beyond
+// the loopback, private and link-local ones net.IP reports itself.
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this network"
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved, and broadcast
+		"64:ff9b::/96",  // NAT64, which can reach IPv4 private ranges
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip is a public internet address.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicDialer refuses to connect to addresses that aren't public. The
+// check runs on the resolved address at dial time, so a name can't pass a
+// check and then be rebound to an internal address.
+var publicDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control: func(network string, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+			return fmt.Errorf("refusing to connect to non-public address %s", host)
+		}
+		return nil
+	},
+}
+
+// publicTransport only connects to public addresses. It never uses a
+// proxy, which would make the connection on its behalf.
+func publicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = publicDialer.DialContext
+	return transport
+}
+
+// checkPublicHost fails unless host resolves, and only to public
+// addresses.
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return fmt.Errorf("%s resolves to non-public address %s", host, addr.IP)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// publishTarget stores the files "twitterrss publish" writes. s3Client is
+// one.
+type publishTarget interface {
+	Put(key string, body []byte, contentType string) error
+}
+
+// dirTarget publishes into a local directory, say one a web server or CDN
+// origin serves.
+type dirTarget string
+
+func (d dirTarget) Put(key string, body []byte, contentType string) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "unable to create publish directory")
+	}
+	return writeGenerated(path, string(body))
+}
+
+// newPublishTarget is the target -publish-to names: s3://bucket/prefix
+// for an S3 bucket, at -s3-endpoint for S3-compatible stores, or a
+// directory. It also returns the key prefix files are published under.
+func newPublishTarget(dest string, s3Endpoint string, s3Region string) (publishTarget, string) {
+	if !strings.HasPrefix(dest, "s3://") {
+		return dirTarget(dest), ""
+	}
+	bucket := strings.TrimPrefix(dest, "s3://")
+	prefix := ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, prefix = bucket[:i], strings.Trim(bucket[i+1:], "/")
+	}
+	return newS3Client(s3Endpoint, s3Region, bucket), prefix
+}
+
+// staticPublisher renders every configured feed and publishes the files
+// to a target, so feeds can be served without exposing this instance.
+type staticPublisher struct {
+	cfg      *config
+	fetcher  *fetcher
+	profiles *profileCache
+	target   publishTarget
+	prefix   string
+	base     string
+	formats  []staticFormat
+}
+
+func newStaticPublisher(cfg *config, f *fetcher, profiles *profileCache, target publishTarget, prefix string, base string, formats string) (*staticPublisher, error) {
+	p := &staticPublisher{cfg: cfg, fetcher: f, profiles: profiles, target: target, prefix: prefix, base: base}
+	for _, name := range strings.Split(formats, ",") {
+		sf, err := lookupStaticFormat(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		p.formats = append(p.formats, sf)
+	}
+	return p, nil
+}
+
+// key is where username's feed is published in format, mirroring the path
+// it is served at.
+func (p *staticPublisher) key(username string, format staticFormat) string {
+	key := strings.TrimSuffix(feedPath(username), ".xml") + format.extension
+	if p.prefix != "" {
+		key = p.prefix + key
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// Publish renders and uploads every feed once. A feed that fails is logged
+// and left as last published, and the others are still published.
+func (p *staticPublisher) Publish(ctx context.Context) error {
+	usernames := p.cfg.usernames()
+	failed := 0
+	for _, username := range usernames {
+		if err := p.publishFeed(ctx, username); err != nil {
+			log.Print(errors.Wrapf(err, "unable to publish %s", username))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds weren't published", failed, len(usernames))
+	}
+	return nil
+}
+
+func (p *staticPublisher) publishFeed(ctx context.Context, username string) error {
+	feed, err := staticFeed(ctx, p.cfg, p.fetcher, p.profiles, username, p.base)
+	if err != nil {
+		return err
+	}
+	for _, format := range p.formats {
+		body, err := format.render(feed)
+		if err != nil {
+			return err
+		}
+		if err := p.target.Put(p.key(username, format), []byte(body), format.contentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run publishes every interval, starting straight away.
+func (p *staticPublisher) Run(interval time.Duration) {
+	for {
+		if err := p.Publish(context.Background()); err != nil {
+			log.Print(err)
+		}
+		time.Sleep(interval)
+	}
+}
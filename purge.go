@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// purgeable is implemented by everything holding per-feed data, so a purge
+// can remove a feed everywhere and then prove it is gone.
+type purgeable interface {
+	PurgeFeed(username string) error
+	HasFeed(username string) bool
+}
+
+// purger removes every trace of a feed from the registered subsystems.
+type purger struct {
+	targets map[string]purgeable
+	persist *persister
+}
+
+func newPurger(persist *persister) *purger {
+	return &purger{targets: map[string]purgeable{}, persist: persist}
+}
+
+// Register adds a subsystem to be purged under name.
+func (p *purger) Register(name string, target purgeable) {
+	p.targets[name] = target
+}
+
+// Purge removes username from every subsystem, checkpoints the store so the
+// data is gone from disk and backups too, and returns the names of any
+// subsystems that still hold data for the feed.
+func (p *purger) Purge(username string) ([]string, error) {
+	for name, target := range p.targets {
+		if err := target.PurgeFeed(username); err != nil {
+			return nil, errors.Wrapf(err, "unable to purge %s", name)
+		}
+	}
+	if p.persist != nil {
+		if _, err := p.persist.Checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+
+	var remaining []string
+	for name, target := range p.targets {
+		if target.HasFeed(username) {
+			remaining = append(remaining, name)
+		}
+	}
+	return remaining, nil
+}
+
+// PurgeHandler completely removes a feed: its config, cached and archived
+// tweets and everything else recorded about it. The feed is purged under
+// the key it's served and cached under, however the request spells it.
+func PurgeHandler(p *purger, cfg *config, audit *auditLog) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := normalizeFeedKey(mux.Vars(r)["username"])
+		if feedCfg, ok := cfg.Feed(username); ok {
+			username = feedCfg.Username
+		}
+
+		remaining, err := p.Purge(username)
+		if err != nil {
+			log.Print(errors.Wrapf(err, "purge of %s failed", username))
+			http.Error(w, "purge failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := audit.Record(r, "purge", map[string]string{"feed": username}); err != nil {
+			log.Print(errors.Wrapf(err, "unable to audit purge of %s", username))
+			http.Error(w, "the feed was purged but couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+
+		status := http.StatusOK
+		response := map[string]interface{}{"feed": username, "purged": true}
+		if len(remaining) > 0 {
+			sort.Strings(remaining)
+			log.Printf("purge of %s left data in %s", username, strings.Join(remaining, ", "))
+			status = http.StatusInternalServerError
+			response = map[string]interface{}{"feed": username, "purged": false, "remaining": remaining}
+		}
+		jsonBody, err := json.Marshal(response)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// stuckTarget claims to purge but keeps the feed, like a subsystem that
+// missed it.
+type stuckTarget struct{ feed string }
+
+func (s stuckTarget) PurgeFeed(username string) error { return nil }
+func (s stuckTarget) HasFeed(username string) bool    { return username == s.feed }
+
+func TestPurgeHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		stuck      bool
+		wantStatus int
+		wantGone   bool
+	}{
+		{"canonical key", "alice", false, http.StatusOK, true},
+		{"differently cased", "Alice", false, http.StatusOK, true},
+		{"with an @", "@ALICE", false, http.StatusOK, true},
+		{"data left behind", "alice", true, http.StatusInternalServerError, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestFeedServer(t, time.Minute, "alice", "bob")
+			s.client.AddTweet(testTweet("1500000000000000000", "alice", "about to be purged"))
+			s.client.AddTweet(testTweet("1500000000000000001", "bob", "staying put"))
+			for _, username := range []string{"alice", "bob"} {
+				if w := getFeed(s, username); w.Code != http.StatusOK {
+					t.Fatalf("%s status = %d, want 200", username, w.Code)
+				}
+			}
+
+			p := newPurger(nil)
+			p.Register("config", s.cfg)
+			p.Register("store", s.fetcher.store)
+			if test.stuck {
+				p.Register("stuck", stuckTarget{feed: "alice"})
+			}
+			r := mux.NewRouter()
+			r.HandleFunc("/admin/feeds/{username:.+}", PurgeHandler(p, s.cfg, newAuditLog("", 10))).Methods("DELETE")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/feeds/"+test.path, nil))
+			if w.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, test.wantStatus, w.Body)
+			}
+			if test.stuck && !strings.Contains(w.Body.String(), `"remaining":["stuck"]`) {
+				t.Errorf("response doesn't name the subsystem left holding data: %s", w.Body)
+			}
+			if gone := !s.cfg.HasFeed("alice") && !s.fetcher.store.HasFeed("alice"); gone != test.wantGone {
+				t.Errorf("alice gone = %v, want %v", gone, test.wantGone)
+			}
+			if !s.cfg.HasFeed("bob") || !s.fetcher.store.HasFeed("bob") {
+				t.Error("purging alice removed bob")
+			}
+		})
+	}
+}
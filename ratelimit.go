@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type limitWindow struct {
+	start time.Time
+	count int
+}
+
+// windowLimiter allows up to limit events per key in each fixed window.
+type windowLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*limitWindow
+}
+
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{limit: limit, window: window, keys: map[string]*limitWindow{}}
+}
+
+// Allow counts an event for key, returning false and how long until the
+// window resets once the limit is used up.
+func (l *windowLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.keys[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if len(l.keys) > 10000 {
+			l.sweepLocked(now)
+		}
+		w = &limitWindow{start: now}
+		l.keys[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+func (l *windowLimiter) sweepLocked(now time.Time) {
+	for key, w := range l.keys {
+		if now.Sub(w.start) >= l.window {
+			delete(l.keys, key)
+		}
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketLimiter is a token bucket per key: each key may burst up to burst
+// events, refilling at rate per second.
+type bucketLimiter struct {
+	rate  float64
+	burst int
+
+	mu   sync.Mutex
+	keys map[string]*bucket
+}
+
+func newBucketLimiter(rate float64, burst int) *bucketLimiter {
+	return &bucketLimiter{rate: rate, burst: burst, keys: map[string]*bucket{}}
+}
+
+// Allow takes a token for key, returning false and how long until the next
+// token once the bucket is empty.
+func (l *bucketLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.keys[key]
+	if !ok {
+		if len(l.keys) > 10000 {
+			l.sweepLocked(now)
+		}
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.keys[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweepLocked forgets buckets that have refilled completely.
+func (l *bucketLimiter) sweepLocked(now time.Time) {
+	for key, b := range l.keys {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// Limit refuses feed requests from clients that have used up their bucket.
+func (l *bucketLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeGroup(r.URL.Path) == routeGroupFeeds {
+			if ok, retry := l.Allow(clientIP(r)); !ok {
+				tooManyRequests(w, "ip", retry)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var rateLimited = newCounterVec("twitterrss_rate_limited_requests_total",
+	"Requests refused for exceeding a rate limit.", "limit")
+
+// tooManyRequests refuses a request that went over a limit.
+func tooManyRequests(w http.ResponseWriter, limit string, retryAfter time.Duration) {
+	rateLimited.Inc(limit)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)+1))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/pkg/errors"
+)
+
+// maxReadersPerFeed caps how many distinct readers are tracked per feed;
+// the rest are counted together as "other".
+const maxReadersPerFeed = 50
+
+var (
+	// compatibleProduct finds the real client in browser-like agents, e.g.
+	// "Mozilla/5.0 (compatible; Feedbin feed-id:1; ...)".
+	compatibleProduct = regexp.MustCompile(`compatible; ?([^;/)]+)`)
+	// subscriberCount is the audience aggregators report, e.g. "12 subscribers".
+	subscriberCount = regexp.MustCompile(`(\d+) (?:subscribers|readers)`)
+	trailingVersion = regexp.MustCompile(`[\s/]+v?[0-9][0-9.]*$`)
+)
+
+// readerName is the product a user agent names, without its version, so
+// every install of a reader is counted together.
+func readerName(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if userAgent == "" {
+		return "(none)"
+	}
+	if match := compatibleProduct.FindStringSubmatch(userAgent); match != nil {
+		return trailingVersion.ReplaceAllString(strings.TrimSpace(match[1]), "")
+	}
+	name := userAgent
+	if i := strings.IndexAny(name, "/(;"); i > 0 {
+		name = name[:i]
+	}
+	return trailingVersion.ReplaceAllString(strings.TrimSpace(name), "")
+}
+
+// readerUsage is how one reader polls one feed.
+type readerUsage struct {
+	Reader    string    `json:"reader"`
+	UserAgent string    `json:"user_agent"`
+	Requests  int       `json:"requests"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Conditional requests sent If-None-Match or If-Modified-Since, and
+	// NotModified of them were answered 304.
+	Conditional int `json:"conditional"`
+	NotModified int `json:"not_modified"`
+	// Deltas asked for RFC 3229 feed deltas.
+	Deltas int `json:"deltas"`
+	// Subscribers is the most the reader's user agent reported.
+	Subscribers int `json:"subscribers,omitempty"`
+}
+
+// readerReport adds what a reader's usage says about it.
+type readerReport struct {
+	readerUsage
+	// PollsPerHour is the reader's average request rate.
+	PollsPerHour float64 `json:"polls_per_hour"`
+	// Compatibility is "conditional" for readers that nearly always send
+	// validators, "partial" for some, and "none" for readers refetching the
+	// whole feed every time.
+	Compatibility string `json:"compatibility"`
+}
+
+func (u readerUsage) report() readerReport {
+	report := readerReport{readerUsage: u, Compatibility: "none"}
+	if hours := u.LastSeen.Sub(u.FirstSeen).Hours(); hours > 0 {
+		report.PollsPerHour = float64(u.Requests-1) / hours
+	}
+	switch share := float64(u.Conditional) / float64(u.Requests); {
+	case share >= 0.9:
+		report.Compatibility = "conditional"
+	case share > 0:
+		report.Compatibility = "partial"
+	}
+	return report
+}
+
+// readerStats aggregates the readers polling each feed, so operators can
+// tune TTLs and find readers that never make conditional requests.
+type readerStats struct {
+	mu    sync.Mutex
+	feeds map[string]map[string]*readerUsage
+}
+
+func newReaderStats() *readerStats {
+	return &readerStats{feeds: map[string]map[string]*readerUsage{}}
+}
+
+func (s *readerStats) record(username string, r *http.Request, status int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	readers, ok := s.feeds[username]
+	if !ok {
+		readers = map[string]*readerUsage{}
+		s.feeds[username] = readers
+	}
+	name := readerName(r.UserAgent())
+	usage, ok := readers[name]
+	if !ok && len(readers) >= maxReadersPerFeed {
+		name = "other"
+		usage, ok = readers[name]
+	}
+	if !ok {
+		usage = &readerUsage{Reader: name, FirstSeen: at}
+		readers[name] = usage
+	}
+	usage.UserAgent = r.UserAgent()
+	usage.Requests++
+	usage.LastSeen = at
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		usage.Conditional++
+	}
+	if status == http.StatusNotModified {
+		usage.NotModified++
+	}
+	if acceptsFeedDelta(r) {
+		usage.Deltas++
+	}
+	if match := subscriberCount.FindStringSubmatch(r.UserAgent()); match != nil {
+		if n, _ := strconv.Atoi(match[1]); n > usage.Subscribers {
+			usage.Subscribers = n
+		}
+	}
+}
+
+// Track records the reader of each feed request next serves. Feeds that
+// don't exist aren't tracked.
+func (s *readerStats) Track(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					status = code
+					next(code)
+				}
+			},
+		})
+		next(w, r)
+		if status != http.StatusNotFound {
+			s.record(routeFeedKey(r), r, status, time.Now())
+		}
+	}
+}
+
+// Report lists each feed's readers, busiest first.
+func (s *readerStats) Report(username string) map[string][]readerReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := map[string][]readerReport{}
+	for feed, readers := range s.feeds {
+		if username != "" && feed != username {
+			continue
+		}
+		list := make([]readerReport, 0, len(readers))
+		for _, usage := range readers {
+			list = append(list, usage.report())
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Requests != list[j].Requests {
+				return list[i].Requests > list[j].Requests
+			}
+			return list[i].Reader < list[j].Reader
+		})
+		report[feed] = list
+	}
+	return report
+}
+
+func (s *readerStats) PurgeFeed(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.feeds, username)
+	return nil
+}
+
+func (s *readerStats) HasFeed(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.feeds[username]
+	return ok
+}
+
+// ReadersHandler reports the readers polling each feed, or with ?feed=
+// just one.
+func ReadersHandler(s *readerStats) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(s.Report(r.URL.Query().Get("feed")))
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const eventItemRedacted = "item.redacted"
+
+type redactRequest struct {
+	Feed   string `json:"feed"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// RedactHandler removes a tweet from the archive and all rendered feeds,
+// e.g. in response to a takedown request. The redaction is audited and a
+// tombstone event is published so downstream consumers can drop it too.
+func RedactHandler(st *store, audit *auditLog, bus *eventBus) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := redactRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid redaction request", http.StatusBadRequest)
+			return
+		}
+		if req.Feed == "" || req.ID == "" {
+			http.Error(w, "feed and id are required", http.StatusBadRequest)
+			return
+		}
+
+		req.Feed = normalizeFeedKey(req.Feed)
+
+		red := redaction{Feed: req.Feed, ID: req.ID, Reason: req.Reason, At: time.Now()}
+		found := st.Redact(red)
+		bus.Publish(event{Type: eventItemRedacted, Feed: req.Feed, Item: &tweetPayload{ID: req.ID}})
+
+		if err := audit.Record(r, "redact", map[string]string{
+			"feed":   req.Feed,
+			"id":     req.ID,
+			"reason": req.Reason,
+			"found":  strconv.FormatBool(found),
+		}); err != nil {
+			log.Print(errors.Wrapf(err, "unable to audit redaction of %s", req.ID))
+			http.Error(w, "the item was redacted but couldn't be audited", http.StatusInternalServerError)
+			return
+		}
+
+		jsonBody, err := json.Marshal(map[string]interface{}{"redaction": red, "found": found})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
+
+func RedactionsHandler(st *store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBody, err := json.Marshal(st.Redactions())
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactHandler(t *testing.T) {
+	const id = "1500000000000000000"
+	tests := []struct {
+		name string
+		feed string
+	}{
+		{"canonical key", "alice"},
+		{"differently cased", "@Alice"},
+		{"another feed holding the item", "search/golang"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestFeedServer(t, 0, "alice", "search/golang")
+			tweet := testTweet(id, "alice", "golang secret to redact")
+			s.client.AddTweet(tweet)
+			s.client.AddSearchResult("golang", tweet)
+			for _, feed := range []string{"alice", "search/golang"} {
+				if w := getFeed(s, feed); w.Code != http.StatusOK {
+					t.Fatalf("%s status = %d, want 200: %s", feed, w.Code, w.Body)
+				}
+			}
+
+			st := s.fetcher.store
+			w := httptest.NewRecorder()
+			body := strings.NewReader(`{"feed":"` + test.feed + `","id":"` + id + `"}`)
+			RedactHandler(st, newAuditLog("", 10), newEventBus(10))(w, httptest.NewRequest("POST", "/admin/redact", body))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), `"found":true`) {
+				t.Errorf("redaction didn't find the item: %s", w.Body)
+			}
+
+			for _, feed := range []string{"alice", "search/golang"} {
+				for _, it := range st.Archived(feed) {
+					if it.ID == id {
+						t.Errorf("%s archive still has the redacted item", feed)
+					}
+				}
+				if w := getFeed(s, feed); strings.Contains(w.Body.String(), "golang secret") {
+					t.Errorf("%s feed still serves the redacted item:\n%s", feed, w.Body)
+				}
+			}
+			if hits := st.Search("secret", "", 10, func(string) bool { return true }); len(hits) != 0 {
+				t.Errorf("search still finds the redacted item: %v", hits)
+			}
+		})
+	}
+}
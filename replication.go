@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotHandler serves the full store so a standby can replicate it.
+// Queued notifications are left out: the primary delivers them, and their
+// payloads can carry webhook secrets.
+func SnapshotHandler(st *store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot := st.Snapshot()
+		snapshot.Notifications = nil
+		jsonBody, err := json.Marshal(snapshot)
+		if err != nil {
+			panic(errors.Wrap(err, "unable to create snapshot"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
+
+// replicator keeps a standby's store in sync with a primary instance.
+type replicator struct {
+	primary  string
+	token    string
+	interval time.Duration
+	store    *store
+	status   *feedStatus
+	client   *http.Client
+}
+
+func (rep *replicator) pull() error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(rep.primary, "/")+"/admin/replication/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	if rep.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rep.token)
+	}
+
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned %s", resp.Status)
+	}
+
+	snapshot := &storeSnapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return errors.Wrap(err, "unable to decode snapshot")
+	}
+	rep.store.Restore(snapshot)
+	for username, entry := range snapshot.Feeds {
+		rep.status.Update(username, entry.Items)
+	}
+	return nil
+}
+
+// Run replicates forever, logging (but surviving) failures so the standby
+// keeps its last good copy while the primary is unreachable.
+func (rep *replicator) Run() {
+	for {
+		if err := rep.pull(); err != nil {
+			log.Print(errors.Wrap(err, "replication failed"))
+		}
+		time.Sleep(rep.interval)
+	}
+}
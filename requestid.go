@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/handlers"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits incoming ids to ones safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestID gives every request an id, keeping a valid X-Request-ID from a
+// proxy in front of us, and returns it in the response so readers'
+// reports can be matched to the logs.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDFrom is the id RequestID gave the request, if any.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// writeRequestLog writes a Common Log Format line, as
+// handlers.LoggingHandler does, followed by the request id.
+func writeRequestLog(w io.Writer, params handlers.LogFormatterParams) {
+	host, _, err := net.SplitHostPort(params.Request.RemoteAddr)
+	if err != nil {
+		host = params.Request.RemoteAddr
+	}
+	username := "-"
+	if params.URL.User != nil && params.URL.User.Username() != "" {
+		username = params.URL.User.Username()
+	}
+	fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %d %s\n",
+		host, username, params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		params.Request.Method, params.URL.RequestURI(), params.Request.Proto,
+		params.StatusCode, params.Size, params.Request.Header.Get(requestIDHeader))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+var (
+	upstreamRetries = newCounterVec("twitterrss_upstream_retries_total",
+		"Twitter API calls retried after a 5xx or network error.")
+	circuitState = newGaugeVec("twitterrss_upstream_circuit_state",
+		"State of the Twitter API circuit breaker: 0 closed, 1 half open, 2 open.")
+	staleServed = newCounterVec("twitterrss_stale_feeds_served_total",
+		"Feeds served from an expired cache because the upstream failed.")
+)
+
+// errCircuitOpen is returned for calls refused while the breaker is open.
+var errCircuitOpen = errors.New("Twitter API circuit breaker is open")
+
+// retryTransport retries idempotent calls that fail with a network error
+// or 5xx a bounded number of times, backing off with jitter between them.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+}
+
+// transientFailure reports whether a call may succeed if tried again.
+func transientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return t.next.RoundTrip(req)
+	}
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = 250 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.retries || !transientFailure(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		upstreamRetries.Inc()
+		select {
+		case <-time.After(policy.NextBackOff()):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Circuit breaker states, as exported in twitterrss_upstream_circuit_state.
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// circuitBreaker stops calling the Twitter API after repeated transient
+// failures, so readers are served stale feeds straight away rather than
+// waiting out timeouts. After the cooldown one call is let through, and
+// its outcome closes or reopens the breaker.
+type circuitBreaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(next http.RoundTripper, threshold int, cooldown time.Duration) *circuitBreaker {
+	circuitState.Set(circuitClosed)
+	return &circuitBreaker{next: next, threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may go ahead now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setLocked(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		// a probe is already out
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with a call's outcome.
+func (b *circuitBreaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		b.setLocked(circuitClosed)
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = now
+		b.setLocked(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) setLocked(state int) {
+	b.state = state
+	circuitState.Set(float64(state))
+}
+
+// RetryIn is how long until an open breaker lets a call through again.
+func (b *circuitBreaker) RetryIn(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != circuitOpen {
+		return 0
+	}
+	return b.openedAt.Add(b.cooldown).Sub(now)
+}
+
+func (b *circuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.threshold <= 0 {
+		return b.next.RoundTrip(req)
+	}
+	if !b.allow(time.Now()) {
+		return nil, errCircuitOpen
+	}
+	resp, err := b.next.RoundTrip(req)
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, which says nothing about the upstream; let
+		// another probe through
+		b.mu.Lock()
+		if b.state == circuitHalfOpen {
+			b.openedAt = time.Time{}
+			b.setLocked(circuitOpen)
+		}
+		b.mu.Unlock()
+		return resp, err
+	}
+	b.record(transientFailure(resp, err), time.Now())
+	return resp, err
+}
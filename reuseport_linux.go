@@ -0,0 +1,5 @@
+package main
+
+// soReusePort is SO_REUSEPORT, which the syscall package doesn't export on
+// Linux.
+const soReusePort = 0xf
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network string, address string, conn syscall.RawConn) error {
+	return errors.New("-reuse-port isn't supported on this platform")
+}
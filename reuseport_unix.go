@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// reusePortControl sets SO_REUSEPORT, letting a new process bind the port
+// while the old one drains.
+func reusePortControl(network string, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// twitterSearchSource serves search feeds, keyed "search/<query>", from the
+// v1.1 standard search API.
+type twitterSearchSource struct {
+	client feedgen.TwitterV1Client
+}
+
+func newTwitterSearchSource(client feedgen.TwitterV1Client) *twitterSearchSource {
+	return &twitterSearchSource{client: client}
+}
+
+func (s *twitterSearchSource) FetchTimeline(query string, opts fetchOptions) ([]item, error) {
+	search, resp, err := s.client.SearchTweets(opts.Context, &twitter.SearchTweetParams{
+		Query:     query,
+		Count:     opts.Count,
+		TweetMode: "extended",
+	})
+	recordUpstream("search_tweets", resp, err)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]item, 0, len(search.Statuses))
+	for _, tweet := range search.Statuses {
+		if opts.ExcludeReplies && tweet.InReplyToStatusIDStr != "" {
+			continue
+		}
+		items = append(items, feedgen.ItemFromV1("", tweet))
+	}
+	return items, nil
+}
+
+func searchFeedKey(query string) string {
+	return networkSearch + "/" + query
+}
+
+type savedSearch struct {
+	ID    string `json:"id_str"`
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// savedSearchSync keeps a search feed for each of the authenticated
+// account's saved searches, adding and removing them as the account does.
+type savedSearchSync struct {
+	client   *http.Client
+	cfg      *config
+	status   *feedStatus
+	interval time.Duration
+}
+
+func (s *savedSearchSync) list() ([]savedSearch, error) {
+	resp, err := s.client.Get("https://api.twitter.com/1.1/saved_searches/list.json")
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("saved searches: %s", resp.Status)
+	}
+	recordUpstream("saved_searches_list", resp, err)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var searches []savedSearch
+	if err := json.NewDecoder(resp.Body).Decode(&searches); err != nil {
+		return nil, errors.Wrap(err, "unable to decode saved searches")
+	}
+	return searches, nil
+}
+
+// syncOnce reconciles the search feeds with the saved searches.
+func (s *savedSearchSync) syncOnce() error {
+	searches, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, search := range searches {
+		key := searchFeedKey(search.Query)
+		wanted[key] = true
+		if s.cfg.Add(feedConfig{Username: key, Title: fmt.Sprintf("Search: %s", search.Name), SavedSearch: true}) {
+			s.status.Add(key)
+			log.Print(feedPath(key))
+		}
+	}
+	for _, feed := range s.cfg.List() {
+		if feed.SavedSearch && !wanted[feed.Username] {
+			// the archive is kept; only the feed stops being served
+			s.cfg.PurgeFeed(feed.Username)
+			s.status.PurgeFeed(feed.Username)
+			log.Printf("Removed %s, no longer a saved search", feedPath(feed.Username))
+		}
+	}
+	return nil
+}
+
+func (s *savedSearchSync) Run() {
+	for {
+		if err := s.syncOnce(); err != nil {
+			log.Print(errors.Wrap(err, "saved search sync failed"))
+		}
+		time.Sleep(s.interval)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretFlag is a string flag that can instead be read from a file, so
+// credentials can be mounted as Docker or Kubernetes secrets rather than
+// show up in process args or env vars.
+type secretFlag struct {
+	name  string
+	value *string
+	file  string
+}
+
+var secretFlags []*secretFlag
+
+// secretStringVar defines a string flag like flag.StringVar, plus a
+// -name-file flag (TWITTER_NAME_FILE in the environment) naming a file to
+// read it from.
+func secretStringVar(p *string, name string, usage string) {
+	flag.StringVar(p, name, "", usage)
+	secret := &secretFlag{name: name, value: p}
+	flag.StringVar(&secret.file, name+"-file", "", fmt.Sprintf("File to read -%s from", name))
+	secretFlags = append(secretFlags, secret)
+}
+
+// loadSecretFiles reads every secret given as a file. Trailing newlines
+// are dropped, since most ways of writing the file leave one.
+func loadSecretFiles() error {
+	for _, secret := range secretFlags {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("-%s and -%s-file can't both be given", secret.name, secret.name)
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to read -%s-file", secret.name)
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
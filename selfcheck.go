@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// renderedItem is what every feed format has to agree on for an item.
+type renderedItem struct {
+	ID   string
+	Time time.Time
+}
+
+// feedFormats render a feed and parse the result back, one per format.
+var feedFormats = []struct {
+	name  string
+	parse func(feed *feedDocument) ([]renderedItem, error)
+}{
+	{"rss", parseRenderedRSS},
+	{"atom", parseRenderedAtom},
+	{"json", parseRenderedJSON},
+}
+
+func parseRenderedRSS(feed *feedDocument) ([]renderedItem, error) {
+	rss, err := feedgen.RenderRSS(feed, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		Items []struct {
+			GUID    string `xml:"guid"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"channel>item"`
+	}{}
+	if err := xml.Unmarshal([]byte(rss), &doc); err != nil {
+		return nil, err
+	}
+	var items []renderedItem
+	for _, it := range doc.Items {
+		t, err := time.Parse(time.RFC1123Z, it.PubDate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "item %s", it.GUID)
+		}
+		items = append(items, renderedItem{ID: it.GUID, Time: t})
+	}
+	return items, nil
+}
+
+func parseRenderedAtom(feed *feedDocument) ([]renderedItem, error) {
+	atom, err := feedgen.RenderAtom(feed)
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		Entries []struct {
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}{}
+	if err := xml.Unmarshal([]byte(atom), &doc); err != nil {
+		return nil, err
+	}
+	var items []renderedItem
+	for _, entry := range doc.Entries {
+		t, err := time.Parse(time.RFC3339, entry.Updated)
+		if err != nil {
+			return nil, errors.Wrapf(err, "entry %s", entry.ID)
+		}
+		items = append(items, renderedItem{ID: entry.ID, Time: t})
+	}
+	return items, nil
+}
+
+func parseRenderedJSON(feed *feedDocument) ([]renderedItem, error) {
+	data, err := feed.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		Items []struct {
+			ID            string     `json:"id"`
+			DatePublished *time.Time `json:"date_published"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, err
+	}
+	var items []renderedItem
+	for _, it := range doc.Items {
+		rendered := renderedItem{ID: it.ID}
+		if it.DatePublished != nil {
+			rendered.Time = *it.DatePublished
+		}
+		items = append(items, rendered)
+	}
+	return items, nil
+}
+
+// selfCheckResult is the outcome of checking one feed.
+type selfCheckResult struct {
+	Feed     string         `json:"feed"`
+	Counts   map[string]int `json:"counts"`
+	Problems []string       `json:"problems,omitempty"`
+}
+
+// selfCheckFeed renders feed in every format and reports anywhere the
+// formats disagree with the items it was built from.
+func selfCheckFeed(username string, feed *feedDocument) selfCheckResult {
+	result := selfCheckResult{Feed: username, Counts: map[string]int{}}
+	for _, format := range feedFormats {
+		items, err := format.parse(feed)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s: %s", format.name, err))
+			continue
+		}
+		result.Counts[format.name] = len(items)
+		if len(items) != len(feed.Items) {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s: %d items, expected %d", format.name, len(items), len(feed.Items)))
+			continue
+		}
+		for i, it := range items {
+			want := feed.Items[i]
+			if it.ID != want.Id {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: item %d has id %q, expected %q", format.name, i, it.ID, want.Id))
+			}
+			// RSS and Atom only carry whole seconds
+			if !it.Time.Truncate(time.Second).Equal(want.Created.Truncate(time.Second)) {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: item %s has time %s, expected %s", format.name, want.Id, it.Time.Format(time.RFC3339), want.Created.Format(time.RFC3339)))
+			}
+		}
+	}
+	return result
+}
+
+// SelfCheckHandler checks every feed's cached items render consistently in
+// every format. It responds 500 when any feed has problems.
+func SelfCheckHandler(cfg *config, st *store, media mediaProxy) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok := true
+		var results []selfCheckResult
+		for _, feedCfg := range cfg.List() {
+			items := st.ArchivedAsOf(feedCfg.Username, time.Now(), timelineSize)
+			result := selfCheckFeed(feedCfg.Username, buildFeed(feedCfg, r, items, time.Now(), media))
+			if len(result.Problems) > 0 {
+				ok = false
+			}
+			results = append(results, result)
+		}
+
+		jsonBody, err := json.Marshal(map[string]interface{}{"ok": ok, "feeds": results})
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(jsonBody)
+	}
+}
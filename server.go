@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// serverSettings are the flags bounding how long connections and requests
+// may hold on to the server.
+type serverSettings struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	// maxConcurrent is the most requests handled at once (0 is unlimited).
+	maxConcurrent int
+	// reusePort shares the port with a new process during an upgrade.
+	reusePort bool
+	// shutdownTimeout is how long in-flight requests get to finish on
+	// SIGTERM.
+	shutdownTimeout time.Duration
+
+	tls tlsSettings
+}
+
+func (s serverSettings) server(port int, handler http.Handler) *http.Server {
+	if s.maxConcurrent > 0 {
+		handler = limitConcurrency(s.maxConcurrent, handler)
+	}
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		ReadTimeout:       s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
+		MaxHeaderBytes:    s.maxHeaderBytes,
+	}
+}
+
+// streamLifetime is how long a streaming response may run before the write
+// timeout would cut it off, leaving time to end it cleanly. Zero means
+// streams can run forever.
+func (s serverSettings) streamLifetime() time.Duration {
+	if s.writeTimeout <= 0 {
+		return 0
+	}
+	if s.writeTimeout > 10*time.Second {
+		return s.writeTimeout - 5*time.Second
+	}
+	return s.writeTimeout / 2
+}
+
+var requestsShed = newCounterVec("twitterrss_requests_shed_total",
+	"Requests refused because -max-concurrent-requests were already in flight.")
+
+// limitConcurrency refuses requests while max are already being handled,
+// rather than queueing them behind slow ones.
+func limitConcurrency(max int, next http.Handler) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			requestsShed.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server busy", http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// signatureHeader carries the detached JWS over a feed response body.
+const signatureHeader = "X-JWS-Signature"
+
+// feedSigner signs feed responses with the operator's Ed25519 key, so
+// archives can check a feed is what this instance served. Signatures are
+// detached compact JWS (RFC 7515 appendix F): the body is the payload, and
+// verifiers fetch the public key from /.well-known/jwks.json.
+type feedSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+	// protected is the encoded JWS header, the same for every signature.
+	protected string
+}
+
+// loadSigningKey reads a PEM encoded PKCS #8 Ed25519 private key, as made
+// by `openssl genpkey -algorithm ed25519`.
+func loadSigningKey(path string) (*feedSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read signing key")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key %s isn't a PEM PRIVATE KEY", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse signing key")
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s isn't an Ed25519 key", path)
+	}
+
+	s := &feedSigner{key: key}
+	s.keyID = s.thumbprint()
+	header, err := json.Marshal(map[string]string{"alg": "EdDSA", "kid": s.keyID})
+	if err != nil {
+		return nil, err
+	}
+	s.protected = base64.RawURLEncoding.EncodeToString(header)
+	return s, nil
+}
+
+func (s *feedSigner) publicKey() string {
+	return base64.RawURLEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of the public key, used as its
+// key id.
+func (s *feedSigner) thumbprint() string {
+	sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + s.publicKey() + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Sign returns the detached JWS over body.
+func (s *feedSigner) Sign(body []byte) string {
+	input := s.protected + "." + base64.RawURLEncoding.EncodeToString(body)
+	return s.protected + ".." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.key, []byte(input)))
+}
+
+// bufferedResponse holds a response back until it has been signed.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// Signed signs successful feed responses.
+func (s *feedSigner) Signed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeGroup(r.URL.Path) != routeGroupFeeds {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buffered := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(buffered, r)
+		if buffered.status == http.StatusOK {
+			w.Header().Set(signatureHeader, s.Sign(buffered.body.Bytes()))
+		}
+		w.WriteHeader(buffered.status)
+		w.Write(buffered.body.Bytes())
+	})
+}
+
+// JWKSHandler publishes the public signing key.
+func JWKSHandler(s *feedSigner) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, "application/jwk-set+json", map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"x":   s.publicKey(),
+				"kid": s.keyID,
+				"use": "sig",
+				"alg": "EdDSA",
+			}},
+		})
+	}
+}
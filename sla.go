@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slaWindows are the rolling windows feed health is reported over.
+var slaWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// maxFetchAttempts caps the attempts kept per feed, a week of polling
+// every minute.
+const maxFetchAttempts = 7 * 24 * 60
+
+// fetchAttempt is one refresh of a feed. Age is how far behind the feed
+// was afterwards: zero when the refresh worked, otherwise the time since
+// the last one that did.
+type fetchAttempt struct {
+	at  time.Time
+	ok  bool
+	age time.Duration
+}
+
+type feedAttempts struct {
+	first       time.Time
+	lastSuccess time.Time
+	attempts    []fetchAttempt
+}
+
+// slaWindow is a feed's health over one rolling window.
+type slaWindow struct {
+	Window    string `json:"window"`
+	Attempts  int    `json:"attempts"`
+	Succeeded int    `json:"succeeded"`
+	// Availability is the percentage of attempts that succeeded.
+	Availability float64 `json:"availability"`
+	// P95Age is the 95th percentile of how stale the feed was after each
+	// attempt.
+	P95Age        time.Duration `json:"-"`
+	P95AgeSeconds float64       `json:"p95_age_seconds"`
+}
+
+// slaTracker keeps each feed's recent refreshes from the event bus, so
+// chronically failing feeds stand out from ones that failed once.
+type slaTracker struct {
+	mu    sync.Mutex
+	feeds map[string]*feedAttempts
+}
+
+func newSLATracker() *slaTracker {
+	return &slaTracker{feeds: map[string]*feedAttempts{}}
+}
+
+// Record is an event bus handler counting refreshes and failed fetches.
+func (t *slaTracker) Record(ev event) {
+	if ev.Type != eventFeedRefreshed && ev.Type != eventFetchFailed {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	feed, ok := t.feeds[ev.Feed]
+	if !ok {
+		feed = &feedAttempts{first: ev.At}
+		t.feeds[ev.Feed] = feed
+	}
+	attempt := fetchAttempt{at: ev.At, ok: ev.Type == eventFeedRefreshed}
+	if attempt.ok {
+		feed.lastSuccess = ev.At
+	} else if feed.lastSuccess.IsZero() {
+		attempt.age = ev.At.Sub(feed.first)
+	} else {
+		attempt.age = ev.At.Sub(feed.lastSuccess)
+	}
+
+	cutoff := ev.At.Add(-slaWindows[len(slaWindows)-1].duration)
+	kept := feed.attempts[:0]
+	for _, a := range feed.attempts {
+		if a.at.After(cutoff) {
+			kept = append(kept, a)
+		}
+	}
+	feed.attempts = append(kept, attempt)
+	if len(feed.attempts) > maxFetchAttempts {
+		feed.attempts = feed.attempts[len(feed.attempts)-maxFetchAttempts:]
+	}
+}
+
+// Report is feed's health over every window. Windows without attempts
+// report zero attempts and full availability.
+func (t *slaTracker) Report(feed string, now time.Time) []slaWindow {
+	t.mu.Lock()
+	var attempts []fetchAttempt
+	if f, ok := t.feeds[feed]; ok {
+		attempts = append(attempts, f.attempts...)
+	}
+	t.mu.Unlock()
+
+	report := make([]slaWindow, 0, len(slaWindows))
+	for _, window := range slaWindows {
+		w := slaWindow{Window: window.name, Availability: 100}
+		var ages []time.Duration
+		for _, a := range attempts {
+			if now.Sub(a.at) > window.duration {
+				continue
+			}
+			w.Attempts++
+			if a.ok {
+				w.Succeeded++
+			}
+			ages = append(ages, a.age)
+		}
+		if w.Attempts > 0 {
+			w.Availability = math.Round(1000*float64(w.Succeeded)/float64(w.Attempts)) / 10
+			w.P95Age = percentile(ages, 0.95)
+			w.P95AgeSeconds = w.P95Age.Seconds()
+		}
+		report = append(report, w)
+	}
+	return report
+}
+
+// percentile is the nearest-rank p percentile of durations.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := int(math.Ceil(p*float64(len(durations)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return durations[rank]
+}
+
+// LastSuccess is when feed last refreshed, if it ever has.
+func (t *slaTracker) LastSuccess(feed string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.feeds[feed]
+	if !ok || f.lastSuccess.IsZero() {
+		return time.Time{}, false
+	}
+	return f.lastSuccess, true
+}
+
+func (t *slaTracker) PurgeFeed(username string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.feeds, username)
+	return nil
+}
+
+func (t *slaTracker) HasFeed(username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.feeds[username]
+	return ok
+}
+
+type feedHealth struct {
+	Username    string      `json:"username"`
+	LastUpdated *time.Time  `json:"last_updated,omitempty"`
+	Windows     []slaWindow `json:"windows"`
+}
+
+// StatusHandler reports every feed's availability and freshness.
+func StatusHandler(status *feedStatus, sla *slaTracker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		feeds := []feedHealth{}
+		for _, info := range status.List() {
+			health := feedHealth{Username: info.Username, Windows: sla.Report(info.Username, now)}
+			if !info.LastUpdated.IsZero() {
+				lastUpdated := info.LastUpdated
+				health.LastUpdated = &lastUpdated
+			}
+			feeds = append(feeds, health)
+		}
+
+		jsonBody, err := json.Marshal(feeds)
+		if err != nil {
+			panic(errors.Wrap(err, "Unable to create response"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonBody)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// feedSlugger names the path segment a feed is served under in place of
+// its key, so feed URLs don't reveal which accounts are followed.
+type feedSlugger interface {
+	Slug(key string) string
+}
+
+// slugs obfuscates feed URLs when -feed-slugs is set; feeds are served
+// under their keys when it is nil.
+var slugs feedSlugger
+
+// Slug schemes selectable with -feed-slugs.
+const slugsHMAC = "hmac"
+
+var slugEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// hmacSlugs derives each slug from a keyed hash of the feed key: stable
+// across restarts, and unguessable without the secret.
+type hmacSlugs struct {
+	secret []byte
+}
+
+func (s hmacSlugs) Slug(key string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.ToLower(key)))
+	return strings.ToLower(slugEncoding.EncodeToString(mac.Sum(nil)[:15]))
+}
+
+// resolveSlug finds the configured feed served under slug.
+func resolveSlug(cfg *config, slug string) (string, bool) {
+	for _, feed := range cfg.List() {
+		if hmac.Equal([]byte(slugs.Slug(feed.Username)), []byte(slug)) {
+			return feed.Username, true
+		}
+	}
+	return "", false
+}
+
+// SlugRoutes serves a feed handler under slugs, handing it the feed key
+// the slug stands for.
+func SlugRoutes(cfg *config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := resolveSlug(cfg, mux.Vars(r)["slug"])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, mux.SetURLVars(r, map[string]string{"username": username}))
+	}
+}
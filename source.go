@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/oauth1"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// defaultFetchOptions are used for every feed.
+var defaultFetchOptions = fetchOptions{Count: timelineSize, ExcludeReplies: true}
+
+// Twitter API versions selectable with -twitter-api-version.
+const (
+	twitterAPIv1 = feedgen.APIv1
+	twitterAPIv2 = feedgen.APIv2
+)
+
+// appOnlyTokens holds a token transport per consumer key, so every source
+// using the same app shares its bearer token and readiness can check it.
+var appOnlyTokens = struct {
+	sync.Mutex
+	byKey map[string]*tokenTransport
+}{byKey: map[string]*tokenTransport{}}
+
+func newAppOnlyHTTPClient(consumerKey string, consumerSecret string) *http.Client {
+	return audited(&http.Client{Transport: appOnlyTransport(consumerKey, consumerSecret)})
+}
+
+// appOnlyTransport is the shared token transport for an app's credentials.
+func appOnlyTransport(consumerKey string, consumerSecret string) *tokenTransport {
+	appOnlyTokens.Lock()
+	defer appOnlyTokens.Unlock()
+	transport, ok := appOnlyTokens.byKey[consumerKey]
+	if !ok {
+		// app credentials are exchanged for a bearer token, kept fresh by the transport
+		config := &clientcredentials.Config{
+			ClientID:     consumerKey,
+			ClientSecret: consumerSecret,
+			TokenURL:     feedgen.TwitterTokenURL,
+		}
+		transport = &tokenTransport{config: config, next: twitterEgress}
+		appOnlyTokens.byKey[consumerKey] = transport
+	}
+	return transport
+}
+
+// appOnlyTransports returns every token transport in use.
+func appOnlyTransports() []*tokenTransport {
+	appOnlyTokens.Lock()
+	defer appOnlyTokens.Unlock()
+	transports := make([]*tokenTransport, 0, len(appOnlyTokens.byKey))
+	for _, t := range appOnlyTokens.byKey {
+		transports = append(transports, t)
+	}
+	return transports
+}
+
+// tokenClient fetches bearer tokens. It is traced but not audited, as the
+// responses carry the token.
+var tokenClient = &http.Client{Timeout: 30 * time.Second, Transport: &traceTransport{next: twitterEgress}}
+
+// tokenTransport authorizes requests with an app-only bearer token,
+// fetching a new one in its own span when it expires.
+type tokenTransport struct {
+	config *clientcredentials.Config
+	next   http.RoundTripper
+
+	mu      sync.Mutex
+	token   *oauth2.Token
+	fetched time.Time
+	// checkErr is the last failed Check, repeated until checkRetry passes
+	// so probes don't hammer the token endpoint with bad credentials.
+	checkErr   error
+	checkRetry time.Time
+}
+
+// tokenCheckBackoff is how long a failed token check is reported before
+// it's retried.
+const tokenCheckBackoff = 30 * time.Second
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.current(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	authorized := req.Clone(req.Context())
+	token.SetAuthHeader(authorized)
+	return t.next.RoundTrip(authorized)
+}
+
+func (t *tokenTransport) current(ctx context.Context) (*oauth2.Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token.Valid() {
+		return t.token, nil
+	}
+	ctx, s := startSpan(ctx, "oauth2 token", spanKindInternal)
+	token, err := t.config.Token(context.WithValue(ctx, oauth2.HTTPClient, tokenClient))
+	s.End(err)
+	if err != nil {
+		return nil, err
+	}
+	t.token = token
+	t.fetched = time.Now()
+	return token, nil
+}
+
+// Check makes sure the transport holds a valid token, fetching one if it
+// doesn't, and returns when the current token was fetched.
+func (t *tokenTransport) Check(ctx context.Context) (time.Time, error) {
+	t.mu.Lock()
+	if t.checkErr != nil && time.Now().Before(t.checkRetry) {
+		defer t.mu.Unlock()
+		return time.Time{}, t.checkErr
+	}
+	t.mu.Unlock()
+
+	_, err := t.current(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checkErr, t.checkRetry = err, time.Now().Add(tokenCheckBackoff)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.fetched, nil
+}
+
+// newUserContextHTTPClient signs requests as the account owning the access
+// token, for endpoints app-only auth can't reach.
+func newUserContextHTTPClient(consumerKey string, consumerSecret string, accessToken string, accessSecret string) *http.Client {
+	config := oauth1.NewConfig(consumerKey, consumerSecret)
+	return audited(config.Client(userContextBase, oauth1.NewToken(accessToken, accessSecret)))
+}
+
+// userContextBase sends oauth1 signed requests over twitterEgress.
+var userContextBase = context.WithValue(oauth1.NoContext, oauth1.HTTPClient, &http.Client{Transport: twitterEgress})
+
+// newTwitterSource returns the Twitter API source for version.
+func newTwitterSource(version string, httpClient *http.Client) (timelineSource, error) {
+	switch version {
+	case twitterAPIv1:
+		source := feedgen.NewTwitterV1Source(httpClient)
+		source.OnResponse = recordUpstream
+		return source, nil
+	case twitterAPIv2:
+		source := feedgen.NewTwitterV2Source(httpClient)
+		source.OnResponse = recordUpstream
+		return source, nil
+	}
+	return nil, fmt.Errorf("unsupported Twitter API version %q", version)
+}
+
+// loadTwitterFixtures reads the -twitter-fixtures file.
+func loadTwitterFixtures(path string) (*feedgen.FakeTwitterClient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fixtures, err := feedgen.LoadTwitterFixtures(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -twitter-fixtures %s: %s", path, err)
+	}
+	return fixtures, nil
+}
+
+// Source names selectable with -sources.
+const (
+	sourceTwitter = "twitter"
+	sourceNitter  = "nitter"
+)
+
+var sourceFallbacks = newCounterVec("twitterrss_source_fallbacks_total",
+	"Timeline fetches that failed on a source and fell through to the next one.", "source")
+
+type namedSource struct {
+	name   string
+	source timelineSource
+}
+
+// fallbackSource tries each source in order and returns the first success,
+// so a scraper can stand in when the API is rate-limited or unavailable.
+// An account that doesn't exist or can't be read is the same on every
+// source, so that ends the search.
+type fallbackSource struct {
+	sources []namedSource
+}
+
+func (s *fallbackSource) FetchTimeline(username string, opts fetchOptions) ([]item, error) {
+	var errs []string
+	for i, named := range s.sources {
+		items, err := named.source.FetchTimeline(username, opts)
+		if err == nil {
+			return items, nil
+		}
+		var unavailable *accountError
+		if errors.As(err, &unavailable) {
+			return nil, err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", named.name, err))
+		debugf(username, "source %s failed: %s", named.name, err)
+		if i < len(s.sources)-1 {
+			sourceFallbacks.Inc(named.name)
+		}
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+// sourceSettings are the flags needed to build timeline sources.
+type sourceSettings struct {
+	order []string
+	// fixtures, if set, answers for the Twitter API.
+	fixtures          *feedgen.FakeTwitterClient
+	twitterAPIVersion string
+	consumerKey       string
+	consumerSecret    string
+	// accessToken and accessSecret, when set, make Twitter calls in the
+	// user's context, which reaches protected accounts they follow.
+	accessToken  string
+	accessSecret string
+	// credentials, if set, spreads app-only calls over several credential
+	// sets.
+	credentials *credentialPool
+	// vault, if set, supplies the credentials from Vault instead.
+	vault          *vaultCredentials
+	nitterInstance string
+}
+
+// hasAppCredentials reports whether app-only Twitter calls can be made.
+func (s sourceSettings) hasAppCredentials() bool {
+	return s.vault != nil || s.credentials != nil || (s.consumerKey != "" && s.consumerSecret != "")
+}
+
+// twitterHTTPClient authorizes Twitter API calls with the credentials read
+// from Vault, if any, or else as the user when there is an access token,
+// or else as the app.
+func (s sourceSettings) twitterHTTPClient() *http.Client {
+	if s.vault != nil {
+		return audited(&http.Client{Transport: s.vault})
+	}
+	if s.accessToken != "" && s.accessSecret != "" {
+		return newUserContextHTTPClient(s.consumerKey, s.consumerSecret, s.accessToken, s.accessSecret)
+	}
+	if s.credentials != nil {
+		return audited(&http.Client{Transport: s.credentials})
+	}
+	return newAppOnlyHTTPClient(s.consumerKey, s.consumerSecret)
+}
+
+// needsTwitterCredentials reports whether the Twitter API is one of the
+// configured sources.
+func (s sourceSettings) needsTwitterCredentials() bool {
+	if s.fixtures != nil {
+		return false
+	}
+	for _, name := range s.order {
+		if name == sourceTwitter {
+			return true
+		}
+	}
+	return false
+}
+
+// newTimelineSource builds the configured sources in fallback order.
+func newTimelineSource(settings sourceSettings) (timelineSource, error) {
+	chain := &fallbackSource{}
+	for _, name := range settings.order {
+		var source timelineSource
+		var err error
+		switch name {
+		case sourceTwitter:
+			if settings.fixtures != nil {
+				fake := feedgen.NewTwitterV1SourceWithClient(settings.fixtures)
+				fake.OnResponse = recordUpstream
+				source = fake
+				break
+			}
+			source, err = newTwitterSource(settings.twitterAPIVersion, settings.twitterHTTPClient())
+		case sourceNitter:
+			source = newNitterSource(settings.nitterInstance)
+		default:
+			err = fmt.Errorf("unknown timeline source %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain.sources = append(chain.sources, namedSource{name: name, source: source})
+	}
+	if len(chain.sources) == 0 {
+		return nil, errors.New("no timeline sources configured")
+	}
+	if len(chain.sources) == 1 {
+		return chain.sources[0].source, nil
+	}
+	return chain, nil
+}
+
+// Networks other than Twitter are served under a prefix of the feed key, so
+// "mastodon/mastodon.social/Gargron" is a Mastodon account while a bare
+// username stays a Twitter account.
+const (
+	networkMastodon = "mastodon"
+	networkBluesky  = "bsky"
+	networkSearch   = "search"
+	// networkAccount serves the authenticated account's own timelines.
+	networkAccount = "me"
+	networkTrends  = "trends"
+)
+
+// splitFeedKey returns the network a feed key belongs to and the account
+// within it. Twitter feeds have an empty network.
+func splitFeedKey(key string) (network string, account string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// normalizeFeedKey is the form feed keys are matched in. Twitter ignores
+// case and readers often paste usernames with their @, so Twitter keys lose
+// both; other networks' keys are left as they are.
+func normalizeFeedKey(key string) string {
+	network, account := splitFeedKey(key)
+	if network != "" {
+		return key
+	}
+	return strings.ToLower(strings.TrimPrefix(account, "@"))
+}
+
+// routedSource sends each feed key to the source for its network.
+type routedSource struct {
+	twitter  timelineSource
+	networks map[string]timelineSource
+}
+
+func newRoutedSource(twitter timelineSource) *routedSource {
+	return &routedSource{
+		twitter: twitter,
+		networks: map[string]timelineSource{
+			networkMastodon: newMastodonSource(),
+			networkBluesky:  newBlueskySource(),
+		},
+	}
+}
+
+// Add serves network from source.
+func (s *routedSource) Add(network string, source timelineSource) {
+	s.networks[network] = source
+}
+
+func (s *routedSource) FetchTimeline(key string, opts fetchOptions) ([]item, error) {
+	network, account := splitFeedKey(key)
+	if network == "" {
+		return s.twitter.FetchTimeline(account, opts)
+	}
+	source, ok := s.networks[network]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", network)
+	}
+	return source.FetchTimeline(account, opts)
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlText flattens post HTML into the plain text items carry.
+func htmlText(s string) string {
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	return strings.TrimSpace(html.UnescapeString(htmlTags.ReplaceAllString(s, "")))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// feedInfo is what we remember about a feed between requests.
+type feedInfo struct {
+	Username    string
+	Name        string
+	AvatarURL   string
+	LastUpdated time.Time
+}
+
+// feedStatus tracks the last known state of every configured feed.
+type feedStatus struct {
+	mu    sync.RWMutex
+	order []string
+	feeds map[string]*feedInfo
+}
+
+func newFeedStatus(usernames []string) *feedStatus {
+	s := &feedStatus{feeds: map[string]*feedInfo{}}
+	for _, username := range usernames {
+		s.order = append(s.order, username)
+		s.feeds[username] = &feedInfo{Username: username}
+	}
+	return s
+}
+
+// Add starts tracking a feed that was configured after startup.
+func (s *feedStatus) Add(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.feeds[username]; !ok {
+		s.order = append(s.order, username)
+		s.feeds[username] = &feedInfo{Username: username}
+	}
+}
+
+// Update records profile details and the newest item time from a fresh
+// fetch. Feeds that are not being tracked are ignored.
+func (s *feedStatus) Update(username string, items []item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.feeds[username]
+	if !ok {
+		return
+	}
+
+	for _, it := range items {
+		if it.Author.AvatarURL != "" && info.AvatarURL == "" {
+			info.Name = it.Author.Name
+			info.AvatarURL = it.Author.AvatarURL
+		}
+		if it.CreatedAt.After(info.LastUpdated) {
+			info.LastUpdated = it.CreatedAt
+		}
+	}
+}
+
+// List returns a copy of every feed in configuration order.
+func (s *feedStatus) List() []feedInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]feedInfo, 0, len(s.order))
+	for _, username := range s.order {
+		list = append(list, *s.feeds[username])
+	}
+	return list
+}
+
+func (s *feedStatus) PurgeFeed(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.feeds, username)
+	kept := s.order[:0]
+	for _, name := range s.order {
+		if name != username {
+			kept = append(kept, name)
+		}
+	}
+	s.order = kept
+	return nil
+}
+
+func (s *feedStatus) HasFeed(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.feeds[username]
+	return ok
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+)
+
+// feedEntry is the most recent timeline fetch for a feed.
+type feedEntry struct {
+	Items     []item    `json:"items"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// redaction records an item removed from the archive and every rendering.
+type redaction struct {
+	Feed   string    `json:"feed"`
+	ID     string    `json:"id"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// metricSample is an item's engagement counts at one fetch.
+type metricSample struct {
+	At      time.Time   `json:"at"`
+	Metrics itemMetrics `json:"metrics"`
+}
+
+// maxMetricSamples caps each item's metric time series.
+const maxMetricSamples = 200
+
+// storeSnapshot is the serialisable form of a store, used for replication.
+type storeSnapshot struct {
+	Feeds      map[string]*feedEntry `json:"feeds"`
+	Archive    map[string][]item     `json:"archive"`
+	Redactions []redaction           `json:"redactions,omitempty"`
+	// Series is each feed's item metric time series, by item id.
+	Series map[string]map[string][]metricSample `json:"series,omitempty"`
+	// Notifications are the deliveries not yet made or given up on.
+	Notifications []notification `json:"notifications,omitempty"`
+}
+
+// store holds the cached timeline for each feed along with an archive of
+// every item seen for it.
+type store struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]*feedEntry
+	archive map[string]map[string]item
+	// redacted items are dropped from every fetch so they never reappear
+	redacted map[string]redaction
+	// series records how each item's metrics changed across fetches
+	series map[string]map[string][]metricSample
+	// notifications are queued outbound deliveries, by id
+	notifications map[string]notification
+	// index finds archived items by the words in them
+	index   *searchIndex
+	changed time.Time
+}
+
+func newStore(ttl time.Duration) *store {
+	return &store{
+		ttl:           ttl,
+		entries:       map[string]*feedEntry{},
+		archive:       map[string]map[string]item{},
+		redacted:      map[string]redaction{},
+		series:        map[string]map[string][]metricSample{},
+		notifications: map[string]notification{},
+		index:         newSearchIndex(),
+	}
+}
+
+// FreshFor is how much longer username's cached entry will be served
+// before it's refetched, or the full ttl when nothing is cached.
+func (s *store) FreshFor(username string, now time.Time) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[username]
+	if !ok {
+		return s.ttl
+	}
+	return entry.FetchedAt.Add(s.ttl).Sub(now)
+}
+
+// Fresh returns the cached entry for username if it is younger than the ttl.
+func (s *store) Fresh(username string) *feedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[username]
+	if !ok || time.Since(entry.FetchedAt) > s.ttl {
+		return nil
+	}
+	return entry
+}
+
+// Latest returns the cached entry for username however old it is.
+func (s *store) Latest(username string) *feedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[username]
+}
+
+// Put caches a fresh fetch and adds its items to the archive. It returns
+// the items that were not archived before; the first fetch of a feed only
+// establishes a baseline and reports nothing as new.
+func (s *store) Put(username string, items []item) []item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items = s.withoutRedactedLocked(items)
+	var fresh []item
+	if archived, ok := s.archive[username]; ok {
+		for _, it := range items {
+			if _, seen := archived[it.ID]; !seen {
+				fresh = append(fresh, it)
+			}
+		}
+	}
+
+	s.entries[username] = &feedEntry{Items: items, FetchedAt: time.Now()}
+	s.archiveLocked(username, items)
+	s.recordMetricsLocked(username, items, time.Now())
+	s.changed = time.Now()
+	return fresh
+}
+
+// ChangedSince reports whether the store was modified after t.
+func (s *store) ChangedSince(t time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changed.After(t)
+}
+
+func (s *store) archiveLocked(username string, items []item) {
+	archived, ok := s.archive[username]
+	if !ok {
+		archived = map[string]item{}
+		s.archive[username] = archived
+	}
+	for _, it := range s.withoutRedactedLocked(items) {
+		archived[it.ID] = it
+		s.index.add(username, it)
+	}
+}
+
+// recordMetricsLocked appends a sample to each item's series whenever its
+// metrics changed since the last one.
+func (s *store) recordMetricsLocked(username string, items []item, at time.Time) {
+	series, ok := s.series[username]
+	if !ok {
+		series = map[string][]metricSample{}
+		s.series[username] = series
+	}
+	for _, it := range items {
+		if it.Metrics == nil {
+			continue
+		}
+		samples := series[it.ID]
+		if len(samples) > 0 && samples[len(samples)-1].Metrics == *it.Metrics {
+			continue
+		}
+		samples = append(samples, metricSample{At: at, Metrics: *it.Metrics})
+		if len(samples) > maxMetricSamples {
+			samples = samples[len(samples)-maxMetricSamples:]
+		}
+		series[it.ID] = samples
+	}
+}
+
+// MetricSeries returns the recorded metrics of an item, oldest first.
+func (s *store) MetricSeries(username string, id string) []metricSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]metricSample(nil), s.series[username][id]...)
+}
+
+// WithoutRedacted drops the redacted items from a fetch, so it can't serve
+// a post that's redacted here but still up at the source.
+func (s *store) WithoutRedacted(items []item) []item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.withoutRedactedLocked(items)
+}
+
+func (s *store) withoutRedactedLocked(items []item) []item {
+	if len(s.redacted) == 0 {
+		return items
+	}
+	kept := make([]item, 0, len(items))
+	for _, it := range items {
+		if _, ok := s.redacted[it.ID]; !ok {
+			kept = append(kept, it)
+		}
+	}
+	return kept
+}
+
+// Redact removes an item from the cache and archive, and keeps it out of
+// every future fetch. It reports whether the item was present.
+func (s *store) Redact(red redaction) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.changed = time.Now() }()
+
+	s.redacted[red.ID] = red
+	return s.applyRedactionLocked(red)
+}
+
+// applyRedactionLocked drops the redacted item from every feed holding it,
+// not just the one named: search, home and differently spelled feeds
+// archive the same post.
+func (s *store) applyRedactionLocked(red redaction) bool {
+	found := false
+	for feed, archived := range s.archive {
+		if _, ok := archived[red.ID]; ok {
+			found = true
+			delete(archived, red.ID)
+			s.index.remove(feed, red.ID)
+		}
+	}
+	for _, series := range s.series {
+		delete(series, red.ID)
+	}
+	for feed, entry := range s.entries {
+		if kept := s.withoutRedactedLocked(entry.Items); len(kept) != len(entry.Items) {
+			found = true
+			s.entries[feed] = &feedEntry{Items: kept, FetchedAt: entry.FetchedAt}
+		}
+	}
+	return found
+}
+
+// Redactions lists every redaction, oldest first.
+func (s *store) Redactions() []redaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.redactionsLocked()
+}
+
+func (s *store) redactionsLocked() []redaction {
+	list := make([]redaction, 0, len(s.redacted))
+	for _, red := range s.redacted {
+		list = append(list, red)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+	return list
+}
+
+// Archived returns every archived item for username, newest first.
+func (s *store) Archived(username string) []item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var items []item
+	for _, it := range s.archive[username] {
+		items = append(items, it)
+	}
+	feedgen.SortItems(items)
+	return items
+}
+
+// Undeleted lists the ids of username's archived items posted since since
+// that aren't known to be deleted, newest first.
+func (s *store) Undeleted(username string, since time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var items []item
+	for _, it := range s.archive[username] {
+		if it.DeletedAt == nil && !it.CreatedAt.Before(since) {
+			items = append(items, it)
+		}
+	}
+	feedgen.SortItems(items)
+	ids := make([]string, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+	return ids
+}
+
+// MarkDeleted records that the items with ids were found deleted at at,
+// in the archive and the cached timeline. It returns the items it marked.
+func (s *store) MarkDeleted(username string, ids []string, at time.Time) []item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := map[string]bool{}
+	var marked []item
+	for _, id := range ids {
+		it, ok := s.archive[username][id]
+		if !ok || it.DeletedAt != nil {
+			continue
+		}
+		it.DeletedAt = &at
+		s.archive[username][id] = it
+		deleted[id] = true
+		marked = append(marked, it)
+	}
+	if entry, ok := s.entries[username]; ok && len(marked) > 0 {
+		items := make([]item, len(entry.Items))
+		for i, it := range entry.Items {
+			if deleted[it.ID] {
+				it.DeletedAt = &at
+			}
+			items[i] = it
+		}
+		s.entries[username] = &feedEntry{Items: items, FetchedAt: entry.FetchedAt}
+	}
+	if len(marked) > 0 {
+		s.changed = time.Now()
+	}
+	return marked
+}
+
+// ArchiveSize is how many items username has archived.
+func (s *store) ArchiveSize(username string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.archive[username])
+}
+
+// Find looks an item up by id in every feed's archive.
+func (s *store) Find(id string) (string, item, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for username, archived := range s.archive {
+		if it, ok := archived[id]; ok {
+			return username, it, true
+		}
+	}
+	return "", item{}, false
+}
+
+// ArchivedAsOf returns the newest limit archived items for username that
+// were posted at or before asOf, reconstructing the timeline at that time.
+func (s *store) ArchivedAsOf(username string, asOf time.Time, limit int) []item {
+	var items []item
+	for _, it := range s.Archived(username) {
+		if it.CreatedAt.After(asOf) {
+			continue
+		}
+		items = append(items, it)
+		if len(items) == limit {
+			break
+		}
+	}
+	return items
+}
+
+// Snapshot copies the full contents of the store.
+func (s *store) Snapshot() *storeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := &storeSnapshot{
+		Feeds:         map[string]*feedEntry{},
+		Archive:       map[string][]item{},
+		Redactions:    s.redactionsLocked(),
+		Series:        map[string]map[string][]metricSample{},
+		Notifications: s.notificationsLocked(),
+	}
+	for username, entry := range s.entries {
+		snapshot.Feeds[username] = entry
+	}
+	for username, archived := range s.archive {
+		for _, it := range archived {
+			snapshot.Archive[username] = append(snapshot.Archive[username], it)
+		}
+	}
+	for username, series := range s.series {
+		copied := map[string][]metricSample{}
+		for id, samples := range series {
+			copied[id] = append([]metricSample(nil), samples...)
+		}
+		snapshot.Series[username] = copied
+	}
+	return snapshot
+}
+
+// Restore merges a snapshot into the store. Cached entries only replace ours
+// when they are newer, so a restore never rolls a feed back.
+func (s *store) Restore(snapshot *storeSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, red := range snapshot.Redactions {
+		s.redacted[red.ID] = red
+	}
+	for username, entry := range snapshot.Feeds {
+		current, ok := s.entries[username]
+		if !ok || entry.FetchedAt.After(current.FetchedAt) {
+			s.entries[username] = entry
+		}
+	}
+	for username, items := range snapshot.Archive {
+		s.archiveLocked(username, items)
+	}
+	for username, series := range snapshot.Series {
+		if _, ok := s.series[username]; !ok {
+			s.series[username] = map[string][]metricSample{}
+		}
+		for id, samples := range series {
+			// keep whichever side has seen the item longer
+			if len(samples) > len(s.series[username][id]) {
+				s.series[username][id] = samples
+			}
+		}
+	}
+	for _, n := range snapshot.Notifications {
+		if _, ok := s.notifications[n.ID]; !ok {
+			s.notifications[n.ID] = n
+		}
+	}
+	for _, red := range snapshot.Redactions {
+		s.applyRedactionLocked(red)
+	}
+	s.changed = time.Now()
+}
+
+func (s *store) PurgeFeed(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, username)
+	delete(s.archive, username)
+	s.index.removeFeed(username)
+	delete(s.series, username)
+	for id, red := range s.redacted {
+		if red.Feed == username {
+			delete(s.redacted, id)
+		}
+	}
+	for id, n := range s.notifications {
+		if n.Feed == username {
+			delete(s.notifications, id)
+		}
+	}
+	s.changed = time.Now()
+	return nil
+}
+
+func (s *store) HasFeed(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.entries[username]; ok {
+		return true
+	}
+	if _, ok := s.archive[username]; ok {
+		return true
+	}
+	if _, ok := s.series[username]; ok {
+		return true
+	}
+	for _, red := range s.redacted {
+		if red.Feed == username {
+			return true
+		}
+	}
+	for _, n := range s.notifications {
+		if n.Feed == username {
+			return true
+		}
+	}
+	return false
+}
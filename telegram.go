@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+// telegramMaxMediaGroup is the most photos Telegram accepts in an album.
+const telegramMaxMediaGroup = 10
+
+// telegramPublisher posts new tweets to the Telegram chats configured on a
+// feed through the Bot API.
+type telegramPublisher struct {
+	cfg     *config
+	baseURL string
+	client  *http.Client
+	queue   *notifyQueue
+}
+
+// telegramJob is a queued Bot API call.
+type telegramJob struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func newTelegramPublisher(cfg *config, token string, queue *notifyQueue) *telegramPublisher {
+	p := &telegramPublisher{
+		cfg:     cfg,
+		baseURL: fmt.Sprintf("https://api.telegram.org/bot%s", token),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		queue:   queue,
+	}
+	queue.Register(notifyTelegram, p.Send)
+	return p
+}
+
+type telegramInputMedia struct {
+	Type    string `json:"type"`
+	Media   string `json:"media"`
+	Caption string `json:"caption,omitempty"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Publish queues fresh items, oldest first, for each of the feed's chats.
+func (p *telegramPublisher) Publish(username string, fresh []item) {
+	feed, ok := p.cfg.Feed(username)
+	if !ok || len(feed.TelegramChats) == 0 {
+		return
+	}
+
+	for i := len(fresh) - 1; i >= 0; i-- {
+		tweet := newTweetPayload(fresh[i])
+		for _, chat := range feed.TelegramChats {
+			if err := p.queue.Enqueue(notifyTelegram, username, chat, telegramMessage(chat, tweet)); err != nil {
+				log.Print(errors.Wrapf(err, "unable to queue tweet %s for telegram chat %s", tweet.ID, chat))
+			}
+		}
+	}
+}
+
+// telegramMessage is the call posting tweet to chat, as a photo, an album
+// or plain text.
+func telegramMessage(chat string, tweet tweetPayload) telegramJob {
+	caption := fmt.Sprintf("@%s: %s\n\n%s", tweet.Author, tweet.Text, tweet.URL)
+
+	switch {
+	case len(tweet.Media) == 1:
+		return telegramJob{"sendPhoto", map[string]interface{}{
+			"chat_id": chat,
+			"photo":   tweet.Media[0],
+			"caption": caption,
+		}}
+	case len(tweet.Media) > 1:
+		var album []telegramInputMedia
+		for i, media := range tweet.Media {
+			if i == telegramMaxMediaGroup {
+				break
+			}
+			item := telegramInputMedia{Type: "photo", Media: media}
+			if i == 0 {
+				item.Caption = caption
+			}
+			album = append(album, item)
+		}
+		return telegramJob{"sendMediaGroup", map[string]interface{}{
+			"chat_id": chat,
+			"media":   album,
+		}}
+	}
+	return telegramJob{"sendMessage", map[string]interface{}{
+		"chat_id": chat,
+		"text":    caption,
+	}}
+}
+
+// Send makes one attempt at a queued Bot API call, passing on Telegram's
+// flood control hints to the queue.
+func (p *telegramPublisher) Send(job notification) error {
+	call := telegramJob{}
+	if err := json.Unmarshal(job.Payload, &call); err != nil {
+		return backoff.Permanent(err)
+	}
+	body, err := json.Marshal(call.Params)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	resp, err := p.client.Post(p.baseURL+"/"+call.Method, "application/json", bytes.NewReader(body))
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// the URL holds the bot token, and the error is logged and kept
+		// on the notification for the admin UI
+		return fmt.Errorf("%s: %s", call.Method, urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := telegramResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s returned %s", call.Method, resp.Status)
+	}
+	if result.OK {
+		return nil
+	}
+	err = fmt.Errorf("%s failed: %s", call.Method, result.Description)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &retryAfterError{after: time.Duration(result.Parameters.RetryAfter) * time.Second, err: err}
+	}
+	if resp.StatusCode >= 500 {
+		return err
+	}
+	return backoff.Permanent(err)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// feedTemplates are Go text/template overrides for how a feed is rendered.
+// Item templates are executed with the item, e.g. "{{.Author.Name}}:
+// {{.Text}}"; the feed title template with the feed's config.
+type feedTemplates struct {
+	FeedTitle       string `json:"feed_title,omitempty"`
+	ItemTitle       string `json:"item_title,omitempty"`
+	ItemDescription string `json:"item_description,omitempty"`
+
+	feedTitle       *template.Template
+	itemTitle       *template.Template
+	itemDescription *template.Template
+}
+
+func (t *feedTemplates) compile() error {
+	for _, tmpl := range []struct {
+		name     string
+		text     string
+		compiled **template.Template
+	}{
+		{"feed_title", t.FeedTitle, &t.feedTitle},
+		{"item_title", t.ItemTitle, &t.itemTitle},
+		{"item_description", t.ItemDescription, &t.itemDescription},
+	} {
+		if tmpl.text == "" {
+			continue
+		}
+		compiled, err := template.New(tmpl.name).Option("missingkey=error").Parse(tmpl.text)
+		if err != nil {
+			return errors.Wrapf(err, "template %s", tmpl.name)
+		}
+		*tmpl.compiled = compiled
+	}
+	return nil
+}
+
+// executeTemplate renders tmpl with data, falling back when the feed has no such
+// template or it fails.
+func executeTemplate(tmpl *template.Template, data interface{}, fallback string) string {
+	if tmpl == nil {
+		return fallback
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		log.Print(errors.Wrapf(err, "unable to execute template %s", tmpl.Name()))
+		return fallback
+	}
+	return out.String()
+}
+
+// templatedTitle is the feed's templated title, or its configured one.
+func (f feedConfig) templatedTitle() string {
+	if f.Templates == nil {
+		return f.FeedTitle()
+	}
+	return executeTemplate(f.Templates.feedTitle, f, f.FeedTitle())
+}
+
+func (f feedConfig) itemTitle(it item, fallback string) string {
+	if f.Templates == nil {
+		return fallback
+	}
+	return executeTemplate(f.Templates.itemTitle, it, fallback)
+}
+
+func (f feedConfig) itemDescription(it item, fallback string) string {
+	if f.Templates == nil {
+		return fallback
+	}
+	return executeTemplate(f.Templates.itemDescription, it, fallback)
+}
@@ -0,0 +1,46 @@
+package main
+
+// unrollThreads combines each run of self-replies with the post it
+// continues into one item, so a thread reads as a single article. The
+// combined item keeps the first post's id, link and time; items newer than
+// it in the thread only add their text and media. items must be newest
+// first, and so is the result.
+func unrollThreads(items []item) []item {
+	byID := make(map[string]item, len(items))
+	for _, it := range items {
+		byID[it.ID] = it
+	}
+	root := func(it item) string {
+		for it.SelfReply() {
+			parent, ok := byID[it.InReplyToID]
+			if !ok {
+				break
+			}
+			it = parent
+		}
+		return it.ID
+	}
+
+	// walk oldest first so thread text ends up in posting order
+	threads := map[string]*item{}
+	for i := len(items) - 1; i >= 0; i-- {
+		it := items[i]
+		id := root(it)
+		thread, ok := threads[id]
+		if !ok {
+			copied := it
+			threads[id] = &copied
+			continue
+		}
+		thread.Text += "\n\n" + it.Text
+		thread.Media = append(thread.Media, it.Media...)
+	}
+
+	unrolled := make([]item, 0, len(threads))
+	for _, it := range items {
+		if thread, ok := threads[it.ID]; ok {
+			unrolled = append(unrolled, *thread)
+		}
+	}
+	return unrolled
+}
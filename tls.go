@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSettings are the flags for terminating HTTPS in process: either a
+// certificate and key, or domains to get Let's Encrypt certificates for.
+type tlsSettings struct {
+	certFile string
+	keyFile  string
+
+	autocertDomains string
+	autocertCache   string
+	autocertEmail   string
+	// challengePort serves ACME http-01 challenges and redirects
+	// everything else to HTTPS.
+	challengePort int
+	// requestClientCerts asks clients for certificates, for mTLS admin
+	// auth. They are verified by the auth, not the handshake.
+	requestClientCerts bool
+}
+
+func (t tlsSettings) enabled() bool {
+	return t.certFile != "" || t.autocertDomains != ""
+}
+
+func (t tlsSettings) apply(config *tls.Config) *tls.Config {
+	if t.requestClientCerts {
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return config
+}
+
+func (t tlsSettings) validate() error {
+	if (t.certFile == "") != (t.keyFile == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if t.certFile != "" && t.autocertDomains != "" {
+		return fmt.Errorf("-autocert-domain can't be combined with -tls-cert")
+	}
+	return nil
+}
+
+// listen serves handler on port, over HTTPS when TLS is configured, until
+// the server is drained on shutdown.
+func listen(port int, handler http.Handler, settings serverSettings) error {
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	server := settings.server(port, handler)
+	listener, err := listenerFor(inherited, 0, server.Addr, settings.reusePort)
+	if err != nil {
+		return err
+	}
+	drained := make(chan struct{})
+	go func() {
+		drainOnTerm(server, settings.shutdownTimeout)
+		close(drained)
+	}()
+
+	switch tlsConfig := settings.tls; {
+	case tlsConfig.autocertDomains != "":
+		var domains []string
+		for _, domain := range strings.Split(tlsConfig.autocertDomains, ",") {
+			domains = append(domains, strings.TrimSpace(domain))
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(tlsConfig.autocertCache),
+			Email:      tlsConfig.autocertEmail,
+		}
+		challenges := settings.server(tlsConfig.challengePort, manager.HTTPHandler(nil))
+		challengeListener, err := listenerFor(inherited, 1, challenges.Addr, settings.reusePort)
+		if err != nil {
+			return err
+		}
+		server.RegisterOnShutdown(func() { challenges.Close() })
+		go func() {
+			log.Printf("Serving ACME challenges on %s\n", challenges.Addr)
+			if err := challenges.Serve(challengeListener); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		server.TLSConfig = tlsConfig.apply(manager.TLSConfig())
+		log.Printf("Listening on %s with certificates for %s\n", server.Addr, strings.Join(domains, ", "))
+		err = server.ServeTLS(listener, "", "")
+	case tlsConfig.certFile != "":
+		server.TLSConfig = tlsConfig.apply(&tls.Config{MinVersion: tls.VersionTLS12})
+		log.Printf("Listening on %s with TLS\n", server.Addr)
+		err = server.ServeTLS(listener, tlsConfig.certFile, tlsConfig.keyFile)
+	default:
+		log.Printf("Listening on %s\n", server.Addr)
+		err = server.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	<-drained
+	return nil
+}
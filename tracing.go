@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// OpenTelemetry span kinds.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// maxPendingSpans bounds the spans waiting for export while the collector
+// is unreachable; older ones are dropped.
+const maxPendingSpans = 2048
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// span is one timed operation of a trace.
+type span struct {
+	name   string
+	kind   int
+	sc     spanContext
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  []otlpAttribute
+	err    string
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+// tracer exports sampled spans to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding. It is configured from the standard OTEL_
+// environment variables.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	ratio    float64
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []*span
+}
+
+// tracing is nil when no OTLP endpoint is configured, which turns every
+// span into a no-op.
+var tracing *tracer
+
+func newTracerFromEnv() (*tracer, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "otlp":
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q", exporter)
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	if protocol := otelEnv("PROTOCOL"); protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, only http/json is", protocol)
+	}
+
+	t := &tracer{
+		endpoint: endpoint,
+		headers:  map[string]string{},
+		service:  os.Getenv("OTEL_SERVICE_NAME"),
+		ratio:    1,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if t.service == "" {
+		t.service = "twitterrss"
+	}
+	if timeout := otelEnv("TIMEOUT"); timeout != "" {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP timeout %q", timeout)
+		}
+		t.client.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	for _, pair := range strings.Split(otelEnv("HEADERS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid OTLP header %q", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP header %q", pair)
+		}
+		t.headers[strings.TrimSpace(parts[0])] = value
+	}
+
+	switch sampler := os.Getenv("OTEL_TRACES_SAMPLER"); sampler {
+	case "", "always_on", "parentbased_always_on":
+	case "always_off", "parentbased_always_off":
+		t.ratio = 0
+	case "traceidratio", "parentbased_traceidratio":
+		if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+			ratio, err := strconv.ParseFloat(arg, 64)
+			if err != nil || ratio < 0 || ratio > 1 {
+				return nil, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1")
+			}
+			t.ratio = ratio
+		}
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_SAMPLER %q", sampler)
+	}
+	return t, nil
+}
+
+// otelEnv reads a trace exporter setting, preferring the traces specific
+// variable over the general one.
+func otelEnv(name string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); value != "" {
+		return value
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// sample decides whether a new trace is recorded, from its id so every
+// service seeing the trace agrees.
+func (t *tracer) sample(traceID [16]byte) bool {
+	var n uint64
+	for _, b := range traceID[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>11)/float64(1<<53) < t.ratio
+}
+
+// startSpan starts a span under the one in ctx, or under a remote parent
+// from a traceparent header, or as a new trace.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracing == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now()}
+	if parent := spanFrom(ctx); parent != nil {
+		s.sc.traceID, s.parent, s.sc.sampled = parent.sc.traceID, parent.sc.spanID, parent.sc.sampled
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(spanContext); ok {
+		s.sc.traceID, s.parent, s.sc.sampled = remote.traceID, remote.spanID, remote.sampled
+	} else {
+		rand.Read(s.sc.traceID[:])
+		s.sc.sampled = tracing.sample(s.sc.traceID)
+	}
+	rand.Read(s.sc.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// SetName renames the span, once a better name is known.
+func (s *span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// SetAttribute records a string, int or bool attribute.
+func (s *span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	v := map[string]interface{}{}
+	switch value := value.(type) {
+	case int:
+		v["intValue"] = strconv.Itoa(value)
+	case bool:
+		v["boolValue"] = value
+	default:
+		v["stringValue"] = fmt.Sprint(value)
+	}
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: v})
+}
+
+// End finishes the span, marking it failed when err is set, and queues
+// sampled spans for export.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	if s.sc.sampled {
+		tracing.enqueue(s)
+	}
+}
+
+func (t *tracer) enqueue(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, s)
+	if len(t.pending) > maxPendingSpans {
+		t.pending = t.pending[len(t.pending)-maxPendingSpans:]
+	}
+}
+
+// Run exports queued spans every few seconds.
+func (t *tracer) Run() {
+	for range time.Tick(5 * time.Second) {
+		t.Flush()
+	}
+}
+
+// Flush exports the queued spans now.
+func (t *tracer) Flush() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(spans); err != nil {
+		log.Print(errors.Wrapf(err, "unable to export %d spans", len(spans)))
+	}
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func (t *tracer) export(spans []*span) error {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			// STATUS_CODE_ERROR
+			o.Status.Code = 2
+			o.Status.Message = s.err
+		}
+		encoded = append(encoded, o)
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{
+					{Key: "service.name", Value: map[string]interface{}{"stringValue": t.service}},
+					{Key: "service.version", Value: map[string]interface{}{"stringValue": currentBuild().Version}},
+				},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/halkeye/twitterrss"},
+				"spans": encoded,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// parseTraceparent reads a W3C traceparent header.
+func parseTraceparent(header string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(header, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Tracing starts a server span for every request, continuing the trace of
+// an incoming traceparent header.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, remoteParentKey{}, remote)
+		}
+		ctx, s := startSpan(ctx, r.Method, spanKindServer)
+		if s == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.SetAttribute("http.method", r.Method)
+		s.SetAttribute("http.target", r.URL.Path)
+		s.SetAttribute("http.request_id", requestIDFrom(ctx))
+
+		status := http.StatusOK
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					status = code
+					next(code)
+				}
+			},
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		s.SetAttribute("http.status_code", status)
+		var err error
+		if status >= http.StatusInternalServerError {
+			err = errors.New(http.StatusText(status))
+		}
+		s.End(err)
+	})
+}
+
+// traceRoute names the server span after the matched route, as router
+// middleware.
+func traceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				spanFrom(r.Context()).SetName(r.Method + " " + template)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceTransport records a client span for each upstream request. Trace
+// headers aren't sent, since the upstreams are third party APIs.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, s := startSpan(req.Context(), "HTTP "+req.Method, spanKindClient)
+	if s == nil {
+		return t.next.RoundTrip(req)
+	}
+	s.SetAttribute("http.method", req.Method)
+	s.SetAttribute("net.peer.name", req.URL.Host)
+	s.SetAttribute("http.target", req.URL.Path)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err == nil {
+		s.SetAttribute("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= http.StatusBadRequest {
+			s.End(errors.New(resp.Status))
+			return resp, nil
+		}
+	}
+	s.End(err)
+	return resp, err
+}
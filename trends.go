@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// twitterTrendsSource serves trends feeds, keyed "trends/<woeid>", from the
+// v1.1 trends API. Each trend is an item once a day, so a trend lasting
+// all day shows up once but returns if it's back tomorrow.
+type twitterTrendsSource struct {
+	client *http.Client
+}
+
+func newTwitterTrendsSource(client *http.Client) *twitterTrendsSource {
+	return &twitterTrendsSource{client: client}
+}
+
+func (s *twitterTrendsSource) FetchTimeline(woeid string, opts fetchOptions) ([]item, error) {
+	id, err := strconv.ParseInt(woeid, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("trends feeds need a numeric WOEID, not %q", woeid)
+	}
+	lists, resp, err := twitter.NewClient(feedgen.WithContext(s.client, opts.Context)).Trends.Place(id, &twitter.TrendsPlaceParams{})
+	recordUpstream("trends_place", resp, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, nil
+	}
+
+	list := lists[0]
+	asOf, err := time.Parse(time.RFC3339, list.AsOf)
+	if err != nil {
+		asOf = time.Now()
+	}
+	asOf = asOf.UTC()
+	place := ""
+	if len(list.Locations) > 0 {
+		place = list.Locations[0].Name
+	}
+	var items []item
+	for _, trend := range list.Trends {
+		if trend.PromotedContent != "" {
+			continue
+		}
+		if opts.Count > 0 && len(items) == opts.Count {
+			break
+		}
+		items = append(items, trendItem(woeid, place, trend, asOf, len(items)))
+	}
+	return items, nil
+}
+
+// trendItem is the rank'th trend. Items are dated a second apart from
+// asOf back, so feeds list them in trending order.
+func trendItem(woeid string, place string, trend twitter.Trend, asOf time.Time, rank int) item {
+	name := fnv.New64a()
+	name.Write([]byte(trend.Name))
+	text := trend.Name
+	markup := fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(trend.URL), html.EscapeString(trend.Name))
+	if trend.TweetVolume > 0 {
+		text += fmt.Sprintf(" — %d tweets", trend.TweetVolume)
+		markup += fmt.Sprintf(" &mdash; %d tweets", trend.TweetVolume)
+	}
+	if place != "" {
+		text += fmt.Sprintf(" (trending #%d in %s)", rank+1, place)
+		markup += fmt.Sprintf(" (trending #%d in %s)", rank+1, html.EscapeString(place))
+	}
+	return item{
+		ID:        fmt.Sprintf("trend-%s-%s-%x", woeid, asOf.Format("20060102"), name.Sum64()),
+		URL:       trend.URL,
+		Title:     trend.Name,
+		Text:      text,
+		HTML:      markup,
+		CreatedAt: asOf.Add(-time.Duration(rank) * time.Second),
+	}
+}
+
+func trendsFeedKey(woeid string) string {
+	return networkTrends + "/" + woeid
+}
+
+// trendsRefresher refreshes the trends feeds on an interval of their own,
+// as trends change much faster than most timelines.
+type trendsRefresher struct {
+	fetcher  *fetcher
+	keys     []string
+	interval time.Duration
+}
+
+func (t *trendsRefresher) Run() {
+	for {
+		for _, key := range t.keys {
+			if _, err := t.fetcher.Refresh(context.Background(), key); err != nil {
+				log.Print(errors.Wrapf(err, "refreshing %s failed", key))
+			}
+		}
+		time.Sleep(t.interval)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+)
+
+// tweetPayload is the JSON representation of a tweet sent to integrations.
+type tweetPayload struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	Author    string    `json:"author"`
+	AuthorURL string    `json:"author_url,omitempty"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+	Media     []string  `json:"media,omitempty"`
+}
+
+func newTweetPayload(it item) tweetPayload {
+	payload := tweetPayload{
+		ID:        it.ID,
+		URL:       it.URL,
+		Text:      it.Text,
+		CreatedAt: it.CreatedAt,
+		Author:    it.Author.Username,
+		AuthorURL: it.Author.URL,
+		AvatarURL: it.Author.AvatarURL,
+	}
+	for _, media := range it.Media {
+		payload.Media = append(payload.Media, media.URL)
+	}
+	return payload
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+)
+
+// unavailableLifetime is how long readers and caches may keep the answer
+// for an account that can't be read, as suspensions and protection are
+// rarely lifted quickly.
+const unavailableLifetime = time.Hour
+
+// accountUnavailable returns the account error behind err, if there is one.
+func accountUnavailable(err error) (*accountError, bool) {
+	var unavailable *accountError
+	if errors.As(err, &unavailable) {
+		return unavailable, true
+	}
+	return nil, false
+}
+
+// unavailableStatus is the response status for an account that can't be
+// read: 404 when it doesn't exist, 403 when it's suspended or protected.
+func unavailableStatus(unavailable *accountError) int {
+	if unavailable.Reason == feedgen.AccountNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusForbidden
+}
+
+// tombstoneItem explains why a feed has stopped. Its id only depends on
+// the reason, so readers show it once rather than on every fetch.
+func tombstoneItem(feedURL string, unavailable *accountError, now time.Time) item {
+	id := "unavailable-" + unavailable.Reason
+	text := fmt.Sprintf("%s, so this feed has no posts.", unavailable.Error())
+	switch unavailable.Reason {
+	case feedgen.AccountSuspended:
+		text = fmt.Sprintf("%s, so this feed has stopped. It resumes if the suspension is lifted.", unavailable.Error())
+	case feedgen.AccountProtected:
+		text = fmt.Sprintf("%s, so this feed can't show them. It resumes if the account becomes public again.", unavailable.Error())
+	}
+	return item{
+		ID:        id,
+		URL:       feedURL + "#" + id,
+		Text:      text,
+		CreatedAt: now.UTC().Truncate(24 * time.Hour),
+		Author:    itemAuthor{Username: unavailable.Username},
+	}
+}
+
+// writeUnavailable answers for a feed whose account can't be read: with
+// the matching status and an explanation, or with tombstones set, a feed
+// holding just the tombstone item so readers show why it went quiet.
+func writeUnavailable(w http.ResponseWriter, r *http.Request, feedCfg feedConfig, unavailable *accountError, tombstones bool) {
+	setFeedCaching(w, unavailableLifetime)
+	if !tombstones {
+		http.Error(w, unavailable.Error(), unavailableStatus(unavailable))
+		return
+	}
+	items := []item{tombstoneItem(baseURL(r)+feedPath(feedCfg.Username), unavailable, time.Now())}
+	render := func(items []item) (string, error) {
+		return feedgen.RenderRSS(buildFeed(feedCfg, r, items, time.Now(), nil), nil)
+	}
+	if err := writeFeed(w, r, items, render); err != nil {
+		panic(errors.Wrap(err, "unable to create rss feed"))
+	}
+}
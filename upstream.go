@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/halkeye/twitterrss/pkg/feedgen"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+var (
+	upstreamRequests = newCounterVec("twitterrss_upstream_requests_total",
+		"Twitter API calls made, by endpoint.", "endpoint")
+	upstreamErrors = newCounterVec("twitterrss_upstream_errors_total",
+		"Failed Twitter API calls, by endpoint and error class.", "endpoint", "class")
+)
+
+// Upstream error classes.
+const (
+	errorAuth        = "auth"
+	errorRateLimit   = "rate_limit"
+	errorNotFound    = "not_found"
+	errorSuspended   = "suspended"
+	errorNetwork     = "network"
+	errorServerError = "5xx"
+	errorOther       = "other"
+)
+
+// classifyUpstreamError sorts a failed Twitter call into a coarse class so
+// "our credentials broke" can be told apart from "Twitter is down".
+func classifyUpstreamError(resp *http.Response, err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return errorAuth
+	}
+
+	var problem *feedgen.TwitterV2Error
+	if errors.As(err, &problem) {
+		switch {
+		case strings.Contains(problem.Type, "resource-not-found"):
+			return errorNotFound
+		case strings.Contains(strings.ToLower(problem.Detail), "suspended"):
+			return errorSuspended
+		case strings.Contains(problem.Type, "usage-capped"):
+			return errorRateLimit
+		}
+	}
+
+	var apiErr twitter.APIError
+	if errors.As(err, &apiErr) {
+		for _, detail := range apiErr.Errors {
+			switch detail.Code {
+			case 32, 89, 99, 135, 215:
+				return errorAuth
+			case 88:
+				return errorRateLimit
+			case 34, 50:
+				return errorNotFound
+			case 63, 64:
+				return errorSuspended
+			case 130, 131:
+				return errorServerError
+			}
+		}
+	}
+
+	if resp == nil {
+		return errorNetwork
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errorAuth
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return errorRateLimit
+	case resp.StatusCode == http.StatusNotFound:
+		return errorNotFound
+	case resp.StatusCode >= 500:
+		return errorServerError
+	}
+	return errorOther
+}
+
+// recordUpstream counts a Twitter API call and, when it failed, its class.
+func recordUpstream(endpoint string, resp *http.Response, err error) {
+	upstreamRequests.Inc(endpoint)
+	if err != nil {
+		upstreamErrors.Inc(endpoint, classifyUpstreamError(resp, err))
+	}
+}
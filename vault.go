@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/oauth1"
+	"github.com/pkg/errors"
+)
+
+// kubernetesServiceAccountToken is the pod's own token, presented to
+// Vault's Kubernetes auth method.
+const kubernetesServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultDefaultRefresh is how often credentials are read again when Vault
+// gives the secret no lease, as with KV secrets.
+const vaultDefaultRefresh = time.Hour
+
+// vaultCredentials authorizes Twitter calls with credentials read from a
+// Vault secret. The secret holds consumer_key and consumer_secret, plus
+// access_token and access_secret for user context calls, or just a
+// bearer_token. It is read again as its lease runs out, so credentials
+// rotated in Vault are picked up without a restart.
+type vaultCredentials struct {
+	addr string
+	// role is the Kubernetes auth role logged in as; without one the
+	// VAULT_TOKEN environment variable is used.
+	role      string
+	authMount string
+	path      string
+	client    *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	renewable   bool
+	transport   http.RoundTripper
+	refresh     time.Duration
+}
+
+func newVaultCredentials(addr string, role string, authMount string, path string) *vaultCredentials {
+	return &vaultCredentials{
+		addr:      strings.TrimSuffix(addr, "/"),
+		role:      role,
+		authMount: strings.Trim(authMount, "/"),
+		path:      strings.Trim(path, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+		token:     os.Getenv("VAULT_TOKEN"),
+	}
+}
+
+// vaultAuth is the auth block of a Vault login or renewal response.
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// vaultResponse is the part of a Vault API response used here.
+type vaultResponse struct {
+	Auth          *vaultAuth             `json:"auth"`
+	Data          map[string]interface{} `json:"data"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Errors        []string               `json:"errors"`
+}
+
+// call makes a Vault API request, with the current token unless it's a
+// login.
+func (v *vaultCredentials) call(method string, path string, body interface{}, token string) (*vaultResponse, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, v.addr+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	parsed := &vaultResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(parsed); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Wrap(err, "unable to decode Vault response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s: %s", resp.Status, path, strings.Join(parsed.Errors, "; "))
+	}
+	return parsed, nil
+}
+
+// authenticate makes sure there's a live Vault token, logging in with the
+// Kubernetes role or renewing the static token as it nears expiry.
+func (v *vaultCredentials) authenticate() error {
+	if v.token != "" && (v.tokenExpiry.IsZero() || time.Until(v.tokenExpiry) > time.Minute) {
+		return nil
+	}
+	var resp *vaultResponse
+	var err error
+	switch {
+	case v.role != "":
+		jwt, readErr := os.ReadFile(kubernetesServiceAccountToken)
+		if readErr != nil {
+			return errors.Wrap(readErr, "unable to read service account token")
+		}
+		resp, err = v.call("POST", "auth/"+v.authMount+"/login", map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))}, "")
+	case v.token != "" && v.renewable:
+		resp, err = v.call("POST", "auth/token/renew-self", nil, v.token)
+	case v.token != "":
+		return errors.New("vault token has expired and isn't renewable")
+	default:
+		return errors.New("-vault-role or VAULT_TOKEN is required")
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("vault login returned no token")
+	}
+	v.token, v.renewable = resp.Auth.ClientToken, resp.Auth.Renewable
+	v.tokenExpiry = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		v.tokenExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+	}
+	return nil
+}
+
+// Refresh reads the credentials from Vault again.
+func (v *vaultCredentials) Refresh() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.authenticate(); err != nil {
+		return errors.Wrap(err, "vault authentication failed")
+	}
+	resp, err := v.call("GET", v.path, nil, v.token)
+	if err != nil {
+		return err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret under data, beside its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	secret := map[string]string{}
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			secret[key] = s
+		}
+	}
+	transport, err := vaultTransport(secret)
+	if err != nil {
+		return errors.Wrapf(err, "unusable credentials at %s", v.path)
+	}
+	v.transport = transport
+	v.refresh = vaultDefaultRefresh
+	if resp.LeaseDuration > 0 {
+		v.refresh = time.Duration(resp.LeaseDuration) * time.Second / 2
+	}
+	return nil
+}
+
+// vaultTransport authorizes requests with the credentials in secret.
+func vaultTransport(secret map[string]string) (http.RoundTripper, error) {
+	key, consumerSecret := secret["consumer_key"], secret["consumer_secret"]
+	switch {
+	case secret["bearer_token"] != "":
+		return &bearerTransport{token: secret["bearer_token"], next: twitterEgress}, nil
+	case key == "" || consumerSecret == "":
+		return nil, errors.New("consumer_key and consumer_secret (or bearer_token) are required")
+	case secret["access_token"] != "" && secret["access_secret"] != "":
+		config := oauth1.NewConfig(key, consumerSecret)
+		return config.Client(userContextBase, oauth1.NewToken(secret["access_token"], secret["access_secret"])).Transport, nil
+	}
+	return appOnlyTransport(key, consumerSecret), nil
+}
+
+func (v *vaultCredentials) RoundTrip(req *http.Request) (*http.Response, error) {
+	v.mu.Lock()
+	transport := v.transport
+	v.mu.Unlock()
+	if transport == nil {
+		return nil, errors.New("no Twitter credentials have been read from Vault")
+	}
+	return transport.RoundTrip(req)
+}
+
+// Run rereads the credentials as their lease runs out, logging (but
+// surviving) failures so the last good credentials stay in use.
+func (v *vaultCredentials) Run() {
+	for {
+		v.mu.Lock()
+		wait := v.refresh
+		v.mu.Unlock()
+		if wait <= 0 {
+			wait = vaultDefaultRefresh
+		}
+		time.Sleep(wait)
+		if err := v.Refresh(); err != nil {
+			log.Print(errors.Wrap(err, "unable to refresh credentials from vault"))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+)
+
+// version, commit and buildDate are set at build time with, e.g.,
+// -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)".
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Profile is "minimal" for builds leaving out optional subsystems.
+	Profile string `json:"profile"`
+}
+
+// currentBuild is what the binary knows about its build. Without ldflags
+// the version falls back to the module version `go install` embedded.
+func currentBuild() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version(), Profile: buildProfile()}
+	if info.Version == "dev" {
+		if embedded, ok := debug.ReadBuildInfo(); ok && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+			info.Version = embedded.Main.Version
+		}
+	}
+	return info
+}
+
+// generator names the software and release in rendered feeds.
+func generator() string {
+	return "twitterrss " + currentBuild().Version
+}
+
+// VersionHandler reports which build this instance is running.
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	jsonBody, err := json.Marshal(currentBuild())
+	if err != nil {
+		panic(errors.Wrap(err, "Unable to create response"))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonBody)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+// webhookConfig is an endpoint that is POSTed new tweets for a feed. Format
+// is "json" (the default), "discord" or "slack".
+type webhookConfig struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// webhookPayload is the body POSTed to webhooks.
+type webhookPayload struct {
+	Feed   string         `json:"feed"`
+	Tweets []tweetPayload `json:"tweets"`
+	// Alert is set when the tweets are sent because an alert rule fired.
+	Alert *alertPayload `json:"alert,omitempty"`
+}
+
+const webhookSignatureHeader = "X-Twitterrss-Signature"
+
+// webhookNotifier delivers new tweets to every webhook configured on a feed.
+type webhookNotifier struct {
+	cfg    *config
+	client *http.Client
+	queue  *notifyQueue
+}
+
+// webhookJob is a queued webhook delivery.
+type webhookJob struct {
+	Hook webhookConfig `json:"hook"`
+	Body []byte        `json:"body"`
+}
+
+func newWebhookNotifier(cfg *config, queue *notifyQueue) *webhookNotifier {
+	n := &webhookNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		queue:  queue,
+	}
+	queue.Register(notifyWebhook, n.Send)
+	return n
+}
+
+// Notify queues fresh items for each of the feed's webhooks.
+func (n *webhookNotifier) Notify(username string, fresh []item) {
+	feed, ok := n.cfg.Feed(username)
+	if !ok || len(feed.Webhooks) == 0 {
+		return
+	}
+
+	payload := webhookPayload{Feed: username}
+	for _, it := range fresh {
+		payload.Tweets = append(payload.Tweets, newTweetPayload(it))
+	}
+
+	for _, hook := range feed.Webhooks {
+		bodies, err := formatWebhook(hook.Format, payload)
+		if err != nil {
+			log.Print(errors.Wrapf(err, "unable to create webhook payload for %s", hook.URL))
+			continue
+		}
+		n.enqueue(username, hook, bodies)
+	}
+}
+
+func (n *webhookNotifier) enqueue(username string, hook webhookConfig, bodies [][]byte) {
+	for _, body := range bodies {
+		if err := n.queue.Enqueue(notifyWebhook, username, hook.URL, webhookJob{Hook: hook, Body: body}); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// signPayload is the hex HMAC-SHA256 of body, sent as "sha256=<hex>".
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send makes one attempt at a queued webhook delivery.
+func (n *webhookNotifier) Send(job notification) error {
+	payload := webhookJob{}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return backoff.Permanent(err)
+	}
+	hook := payload.Hook
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload.Body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signPayload(hook.Secret, payload.Body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		after, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &retryAfterError{after: time.Duration(after) * time.Second, err: fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode/100 != 2 {
+		return backoff.Permanent(fmt.Errorf("webhook returned %s", resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// websubPublisher notifies a WebSub hub whenever a feed gains new tweets so
+// subscribed readers get pushed updates instead of polling.
+type websubPublisher struct {
+	hub     string
+	baseURL string
+	client  *http.Client
+}
+
+func newWebsubPublisher(hub string, baseURL string) *websubPublisher {
+	return &websubPublisher{
+		hub:     hub,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *websubPublisher) topic(username string) string {
+	return p.baseURL + feedPath(username)
+}
+
+// Publish tells the hub the feed for username has changed.
+func (p *websubPublisher) Publish(username string, fresh []item) {
+	resp, err := p.client.PostForm(p.hub, url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {p.topic(username)},
+	})
+	if err != nil {
+		log.Print(errors.Wrapf(err, "unable to notify hub for %s", username))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Print(fmt.Errorf("hub rejected publish for %s: %s", username, resp.Status))
+	}
+}
+
+// Links are the discovery links advertised in a feed and its headers.
+func (p *websubPublisher) Links(username string) []atomLink {
+	return []atomLink{
+		{Rel: "hub", Href: p.hub},
+		{Rel: "self", Href: p.topic(username), Type: "application/rss+xml"},
+	}
+}
+
+// setLinkHeader advertises links in the HTTP Link header as well, which is
+// how WebSub discovery works for clients that don't parse the body.
+func setLinkHeader(w http.ResponseWriter, links []atomLink) {
+	for _, link := range links {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=%q", link.Href, link.Rel))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// serviceInfo describes what this instance offers, for clients that
+// auto-configure against it.
+type serviceInfo struct {
+	Software     string   `json:"software"`
+	Version      string   `json:"version"`
+	Networks     []string `json:"networks"`
+	Formats      []string `json:"formats"`
+	Capabilities []string `json:"capabilities"`
+	FeedURL      string   `json:"feed_url_template"`
+	OPMLURL      string   `json:"opml_url"`
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	jsonBody, err := json.Marshal(v)
+	if err != nil {
+		panic(errors.Wrap(err, "Unable to create response"))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonBody)
+}
+
+// ServiceInfoHandler serves the instance descriptor at
+// /.well-known/twitterrss.json.
+func ServiceInfoHandler(info serviceInfo) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(r)
+		info.FeedURL = base + "/feed/{username}.xml"
+		info.OPMLURL = base + "/opml.xml"
+		writeJSON(w, "application/json", info)
+	}
+}
+
+const nodeInfoSchema = "http://nodeinfo.diaspora.software/ns/schema/2.0"
+
+// NodeInfoDiscoveryHandler points at the NodeInfo document.
+func NodeInfoDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, "application/json", map[string]interface{}{
+		"links": []map[string]string{
+			{"rel": nodeInfoSchema, "href": baseURL(r) + "/nodeinfo/2.0"},
+		},
+	})
+}
+
+// NodeInfoHandler serves NodeInfo 2.0. Feeds count as users, since each is
+// an account bridged by the instance.
+func NodeInfoHandler(cfg *config, info serviceInfo) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `application/json; profile="`+nodeInfoSchema+`#"`, map[string]interface{}{
+			"version": "2.0",
+			"software": map[string]string{
+				"name":    info.Software,
+				"version": info.Version,
+			},
+			"protocols": []string{},
+			"services": map[string][]string{
+				"inbound":  {},
+				"outbound": {"rss2.0"},
+			},
+			"openRegistrations": false,
+			"usage": map[string]interface{}{
+				"users": map[string]int{"total": len(cfg.usernames())},
+			},
+			"metadata": map[string]interface{}{
+				"networks":     info.Networks,
+				"capabilities": info.Capabilities,
+			},
+		})
+	}
+}
+
+type xrdLink struct {
+	Rel      string `xml:"rel,attr"`
+	Type     string `xml:"type,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+type xrdDocument struct {
+	XMLName xml.Name  `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD"`
+	Links   []xrdLink `xml:"Link"`
+}
+
+// HostMetaHandler serves host-meta, pointing WebFinger lookups at this
+// instance.
+func HostMetaHandler(w http.ResponseWriter, r *http.Request) {
+	doc := xrdDocument{Links: []xrdLink{{
+		Rel:      "lrdd",
+		Type:     "application/jrd+json",
+		Template: baseURL(r) + "/.well-known/webfinger?resource={uri}",
+	}}}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(errors.Wrap(err, "unable to create host-meta"))
+	}
+	w.Header().Set("Content-Type", "application/xrd+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// WebFingerHandler resolves acct:username@host to the username's feed.
+func WebFingerHandler(cfg *config) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource := r.URL.Query().Get("resource")
+		if resource == "" {
+			http.Error(w, "resource is required", http.StatusBadRequest)
+			return
+		}
+		acct := strings.TrimPrefix(strings.TrimPrefix(resource, "acct:"), "@")
+		at := strings.LastIndex(acct, "@")
+		if at < 0 || !strings.EqualFold(acct[at+1:], r.Host) {
+			http.NotFound(w, r)
+			return
+		}
+		feed, ok := cfg.Feed(acct[:at])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, "application/jrd+json", map[string]interface{}{
+			"subject": fmt.Sprintf("acct:%s@%s", feed.Username, r.Host),
+			"aliases": []string{baseURL(r) + feedPath(feed.Username)},
+			"links": []map[string]string{
+				{"rel": "alternate", "type": "application/rss+xml", "href": baseURL(r) + feedPath(feed.Username)},
+			},
+		})
+	}
+}